@@ -54,3 +54,52 @@ Create the name of the service account to use
     {{ default "default" .Values.serviceAccount.name }}
 {{- end -}}
 {{- end -}}
+
+{{/*
+RBAC rules for the agent, shared by the ClusterRole and the per-namespace Roles
+*/}}
+{{- define "cronitor-kubernetes-agent.rbacRules" -}}
+- apiGroups: [""]
+  resources:
+    - events
+    - pods
+    {{- if .Values.config.shipLogs }}
+    - pods/log
+    {{- end }}
+    - namespaces
+  verbs: ["get", "watch", "list"]
+# Warning events are recorded on CronJobs the agent cannot monitor (e.g. invalid annotations)
+- apiGroups: [""]
+  resources:
+    - events
+  verbs: ["create", "patch"]
+- apiGroups: ["batch"]
+  resources:
+    - cronjobs
+    - jobs
+  verbs: ["get", "watch", "list"]
+- apiGroups: ["k8s.cronitor.io"]
+  resources:
+    - cronitorpolicies
+    - clustercronitorpolicies
+  verbs: ["get", "watch", "list"]
+{{- end -}}
+
+{{/*
+Name and key of the Secret holding the API key: the chart-managed Secret, or an existing one
+*/}}
+{{- define "cronitor-kubernetes-agent.apiKeySecretName" -}}
+{{- if .Values.credentials.createSecret.apiKey -}}
+{{ include "cronitor-kubernetes-agent.fullname" . }}-secret
+{{- else -}}
+{{ required "A valid name for a Secret holding the API key is required!" .Values.credentials.secretName }}
+{{- end -}}
+{{- end -}}
+
+{{- define "cronitor-kubernetes-agent.apiKeySecretKey" -}}
+{{- if .Values.credentials.createSecret.apiKey -}}
+CRONITOR_API_KEY
+{{- else -}}
+{{ required "A valid key in a Secret holding the API key is required!" .Values.credentials.secretKey }}
+{{- end -}}
+{{- end -}}
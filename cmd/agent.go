@@ -1,23 +1,33 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Masterminds/semver"
 	"github.com/cronitorio/cronitor-kubernetes/pkg"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/collector"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/metrics"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var dryRun bool
 
+// configReloadInterval is how often the config file is checked for changes.
+const configReloadInterval = 10 * time.Second
+
+// defaultShutdownTimeout leaves time to exit within Kubernetes' default 30 second grace period.
+const defaultShutdownTimeout = 25 * time.Second
+
 var agentCmd = &cobra.Command{
 	PersistentPreRunE: initializeAgentConfig,
 	Use:               "agent",
@@ -56,7 +66,8 @@ func checkVersion() {
 		return
 	}
 	if constraint.Check(latestAvailableVersion) {
-		fmt.Printf(`
+		// On stderr, as dry runs may write their records to stdout
+		fmt.Fprintf(os.Stderr, `
 *************
 A new version of cronitor-kubernetes is available!
 You are using: %s
@@ -67,61 +78,152 @@ Latest version available with Helm: %s
 }
 
 func agentRun(cmd *cobra.Command, args []string) error {
+	// ctx is cancelled by the first SIGINT or SIGTERM; a second one exits right away
+	ctx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
 	checkVersion()
 
-	apiKey := viper.GetString("apikey")
-	if apiKey == "" {
-		return errors.New("a Cronitor api key is required. Provide via --apikey or CRONITOR_API_KEY environmental value")
+	if agentConfig.ApiKey == "" && agentConfig.ApiKeyFile == "" && len(agentConfig.Accounts) == 0 {
+		return errors.New("a Cronitor api key is required. Provide via --apikey or CRONITOR_API_KEY environmental value, or --apikey-file")
+	}
+	if agentConfig.TelemetryKey == "" && (agentConfig.ApiKey != "" || agentConfig.ApiKeyFile != "") {
+		slog.Warn("no telemetry key provided, sending pings with the API key; provide a telemetry key via --telemetry-key or CRONITOR_TELEMETRY_KEY to keep the API key out of ping URLs")
 	}
-	cronitorApi := api.NewCronitorApi(apiKey, viper.GetBool("dryrun"))
-	kubeconfig := viper.GetString("kubeconfig")
-	if kubeconfig == "" {
+	configStore := config.NewStore(agentConfig)
+	cronitorApi := api.NewCronitorApi(configStore)
+	if agentConfig.ApiKeyFile != "" {
+		keyFile, err := api.NewKeyFile(agentConfig.ApiKeyFile)
+		if err != nil {
+			return err
+		}
+		cronitorApi.KeyFile = keyFile
+	}
+	if agentConfig.DryRun {
+		out, err := api.OpenDryRunOutput(agentConfig.DryRunOutput, int64(agentConfig.DryRunMaxSize)<<20)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		cronitorApi.Recorder = api.NewDryRunRecorder(out)
+		slog.Info("dry run: requests to Cronitor are recorded instead of sent", "output", agentConfig.DryRunOutput, "diff", agentConfig.DryRunDiff)
+	}
+	if agentConfig.Kubeconfig == "" {
 		slog.Info("no kubeconfig provided, defaulting to in-cluster...")
 	}
-	namespace := viper.GetString("namespace")
-	collection, err := collector.NewCronJobCollection(kubeconfig, namespace, &cronitorApi)
+	namespaceScope, err := collector.NewNamespaceScope(
+		agentConfig.Namespaces,
+		agentConfig.NamespaceExclude,
+		agentConfig.NamespaceSelector)
+	if err != nil {
+		return err
+	}
+	collection, err := collector.NewCronJobCollection(configStore, namespaceScope, &cronitorApi)
 	if err != nil {
 		return err
 	}
-	if err := collection.LoadAllExistingCronJobs(); err != nil {
+	if metricsAddress := agentConfig.MetricsAddress; metricsAddress != "" {
+		go metrics.Serve(metricsAddress)
+	}
+	if err := collection.LoadAllExistingCronJobs(ctx); err != nil {
 		return err
 	}
-	collection.StartWatchingAll()
-
-	gracefulExit := func() {
-		collection.StopWatchingAll()
+	if err := collection.StartWatchingAll(); err != nil {
+		return err
 	}
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
-	select {
-	case sig := <-c:
-		slog.Info("received signal to exit", "signal", sig.String())
-		gracefulExit()
+	stopConfigWatch := make(chan struct{})
+	go configStore.WatchFile(viper.GetViper(), configReloadInterval, stopConfigWatch)
+	if cronitorApi.KeyFile != nil {
+		go cronitorApi.KeyFile.Watch(ctx, cronitorApi, configReloadInterval)
 	}
-	// case <-leaderlost
+
+	<-ctx.Done()
+	stopSignals()
+	shutdownTimeout := configStore.Get().ShutdownTimeout
+	slog.Info("received signal to exit, sending the telemetry already collected", "timeout", shutdownTimeout)
+	close(stopConfigWatch)
+	drainCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	collection.StopWatchingAll(drainCtx)
 
 	return nil
 }
 
 func init() {
 	agentCmd.Flags().BoolVar(&dryRun, "dryrun", false, "Dry run, do not actually send updates to Cronitor")
+	agentCmd.Flags().String("dryrun-output", "", "In dry run, file to record the requests that would have been sent to as JSON lines (defaults to stdout)")
+	agentCmd.Flags().Int("dryrun-max-size", api.DefaultDryRunMaxSize, "In dry run, size in megabytes at which the output file is rotated")
+	agentCmd.Flags().Bool("dryrun-diff", false, "In dry run, compare each monitor with the one currently in Cronitor")
 
 	//// Features
 	agentCmd.Flags().Bool("ship-logs", false, "Collect and archive the logs from each CronJob run upon completion or failure")
-	agentCmd.Flags().String("namespace", "", "Scope agent collection to only a single Kubernetes namespace")
+	agentCmd.Flags().StringSlice("namespace", nil, "Scope agent collection to only these Kubernetes namespaces (comma-separated or repeated)")
+	agentCmd.Flags().StringSlice("namespace-exclude", nil, "Namespaces to ignore, as globs (e.g. kube-*) or /regular expressions/ (comma-separated or repeated)")
+	agentCmd.Flags().String("namespace-selector", "", "Optional label selector (e.g. cronitor=enabled) limiting collection to matching namespaces; requires cluster-wide access to namespaces")
 	agentCmd.Flags().String("pod-filter", "", "Optional regular expression (on pod.name) to limit which pods are monitored")
+	agentCmd.Flags().String("cronjob-selector", "", "Optional label selector (e.g. tier=prod) limiting collection to matching CronJobs, combined with the include/exclude annotations")
+	agentCmd.Flags().String("metrics-address", "", "Optional address (e.g. :9090) on which to expose agent metrics at /debug/vars")
+	agentCmd.Flags().Int("sync-chunk-size", api.DefaultSyncChunkSize, "Number of monitors to send to Cronitor per request when syncing CronJobs")
+	agentCmd.Flags().Int("sync-concurrency", api.DefaultSyncConcurrency, "Number of requests to send to Cronitor in parallel when syncing CronJobs")
+	agentCmd.Flags().Duration("sync-window", collector.DefaultSyncWindow, "How long to collect changes to CronJobs before syncing their monitors to Cronitor in a single batch")
+	agentCmd.Flags().Duration("shutdown-timeout", defaultShutdownTimeout, "How long to keep sending the pings and logs already collected after being asked to exit")
+	agentCmd.Flags().Duration("missed-run-margin", 0, "Optional time after which a scheduled run that never started is reported as missed (e.g. 5m); 0 disables the check")
+	agentCmd.Flags().String("missed-run-action", "fail", "How to report a missed run: 'fail' sends a failure, 'log' only records a log event")
+	agentCmd.Flags().Duration("pending-grace-period", collector.DefaultPendingGracePeriod, "How long a pod may be kept from starting (unschedulable, image pull errors, missing Secrets) before its run is reported as failed; 0 disables the check")
+	agentCmd.Flags().Bool("sidecar-completion", false, "Watch the pods of jobs and report a run as complete or failed once its main containers have exited, even while sidecar containers such as service mesh proxies keep the pod running")
+	agentCmd.Flags().StringSlice("sidecar-containers", collector.DefaultSidecarContainers, "Names of the sidecar containers that are not a job's main containers, unless a CronJob names its main containers with the k8s.cronitor.io/main-container annotation (comma-separated or repeated)")
+	agentCmd.Flags().Bool("main-container-logs", false, "Collect logs only from the main containers of a job's pod, leaving out sidecar containers")
+	agentCmd.Flags().String("event-rules", "", "Rules mapping Kubernetes events to the state they are reported as, as a JSON list of {kind, reason, message, state} objects (message is an optional regular expression; state is run, complete, fail, ok, logs or ignore), checked before the built-in rules")
+	agentCmd.Flags().String("sync-state-configmap", "", "Optional ConfigMap (namespace/name) in which to remember synced monitors across restarts, to avoid re-sending unchanged ones")
 
 	RootCmd.AddCommand(agentCmd)
 }
 
 func initializeAgentConfig(agentCmd *cobra.Command, args []string) error {
 	_ = viper.BindPFlag("dryrun", agentCmd.Flags().Lookup("dryrun"))
+	_ = viper.BindEnv("dryrun-output", "CRONITOR_AGENT_DRYRUN_OUTPUT")
+	_ = viper.BindPFlag("dryrun-output", agentCmd.Flags().Lookup("dryrun-output"))
+	_ = viper.BindEnv("dryrun-max-size", "CRONITOR_AGENT_DRYRUN_MAX_SIZE")
+	_ = viper.BindPFlag("dryrun-max-size", agentCmd.Flags().Lookup("dryrun-max-size"))
+	_ = viper.BindEnv("dryrun-diff", "CRONITOR_AGENT_DRYRUN_DIFF")
+	_ = viper.BindPFlag("dryrun-diff", agentCmd.Flags().Lookup("dryrun-diff"))
 	_ = viper.BindEnv("ship-logs", "CRONITOR_AGENT_SHIP_LOGS")
 	_ = viper.BindPFlag("ship-logs", agentCmd.Flags().Lookup("ship-logs"))
 	_ = viper.BindEnv("pod-filter", "CRONITOR_AGENT_POD_FILTER")
 	_ = viper.BindPFlag("pod-filter", agentCmd.Flags().Lookup("pod-filter"))
 	_ = viper.BindPFlag("namespace", agentCmd.Flags().Lookup("namespace"))
+	_ = viper.BindEnv("namespace-exclude", "CRONITOR_AGENT_NAMESPACE_EXCLUDE")
+	_ = viper.BindPFlag("namespace-exclude", agentCmd.Flags().Lookup("namespace-exclude"))
+	_ = viper.BindEnv("namespace-selector", "CRONITOR_AGENT_NAMESPACE_SELECTOR")
+	_ = viper.BindPFlag("namespace-selector", agentCmd.Flags().Lookup("namespace-selector"))
+	_ = viper.BindEnv("cronjob-selector", "CRONITOR_AGENT_CRONJOB_SELECTOR")
+	_ = viper.BindPFlag("cronjob-selector", agentCmd.Flags().Lookup("cronjob-selector"))
+	_ = viper.BindEnv("metrics-address", "CRONITOR_AGENT_METRICS_ADDRESS")
+	_ = viper.BindPFlag("metrics-address", agentCmd.Flags().Lookup("metrics-address"))
+	_ = viper.BindEnv("sync-chunk-size", "CRONITOR_AGENT_SYNC_CHUNK_SIZE")
+	_ = viper.BindPFlag("sync-chunk-size", agentCmd.Flags().Lookup("sync-chunk-size"))
+	_ = viper.BindEnv("sync-concurrency", "CRONITOR_AGENT_SYNC_CONCURRENCY")
+	_ = viper.BindPFlag("sync-concurrency", agentCmd.Flags().Lookup("sync-concurrency"))
+	_ = viper.BindEnv("sync-window", "CRONITOR_AGENT_SYNC_WINDOW")
+	_ = viper.BindPFlag("sync-window", agentCmd.Flags().Lookup("sync-window"))
+	_ = viper.BindEnv("shutdown-timeout", "CRONITOR_AGENT_SHUTDOWN_TIMEOUT")
+	_ = viper.BindPFlag("shutdown-timeout", agentCmd.Flags().Lookup("shutdown-timeout"))
+	_ = viper.BindEnv("missed-run-margin", "CRONITOR_AGENT_MISSED_RUN_MARGIN")
+	_ = viper.BindPFlag("missed-run-margin", agentCmd.Flags().Lookup("missed-run-margin"))
+	_ = viper.BindEnv("missed-run-action", "CRONITOR_AGENT_MISSED_RUN_ACTION")
+	_ = viper.BindPFlag("missed-run-action", agentCmd.Flags().Lookup("missed-run-action"))
+	_ = viper.BindEnv("pending-grace-period", "CRONITOR_AGENT_PENDING_GRACE_PERIOD")
+	_ = viper.BindPFlag("pending-grace-period", agentCmd.Flags().Lookup("pending-grace-period"))
+	_ = viper.BindEnv("sidecar-completion", "CRONITOR_AGENT_SIDECAR_COMPLETION")
+	_ = viper.BindPFlag("sidecar-completion", agentCmd.Flags().Lookup("sidecar-completion"))
+	_ = viper.BindEnv("sidecar-containers", "CRONITOR_AGENT_SIDECAR_CONTAINERS")
+	_ = viper.BindPFlag("sidecar-containers", agentCmd.Flags().Lookup("sidecar-containers"))
+	_ = viper.BindEnv("main-container-logs", "CRONITOR_AGENT_MAIN_CONTAINER_LOGS")
+	_ = viper.BindPFlag("main-container-logs", agentCmd.Flags().Lookup("main-container-logs"))
+	_ = viper.BindEnv("event-rules", "CRONITOR_AGENT_EVENT_RULES")
+	_ = viper.BindPFlag("event-rules", agentCmd.Flags().Lookup("event-rules"))
+	_ = viper.BindEnv("sync-state-configmap", "CRONITOR_AGENT_SYNC_STATE_CONFIGMAP")
+	_ = viper.BindPFlag("sync-state-configmap", agentCmd.Flags().Lookup("sync-state-configmap"))
 
 	// We need to add this because declaring PersistentPreRunE in this command
 	// overrides the run coming from Root; it doesn't run both
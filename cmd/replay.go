@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/collector"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var replayCmd = &cobra.Command{
+	PersistentPreRunE: initializeReplayConfig,
+	Use:               "replay",
+	Short:             "Replay recorded Kubernetes events and print the telemetry the agent would send",
+	Long: `Replay feeds recorded Events (e.g. from kubectl get events -o json) through the agent's
+event handling, against the CronJobs, Jobs and Pods in the objects file (e.g. from
+kubectl get cronjobs,jobs,pods -o yaml), without connecting to a cluster or to Cronitor.
+The pings and log uploads the agent would have sent are printed as JSON lines.`,
+	RunE: replayRun,
+}
+
+func replayRun(cmd *cobra.Command, args []string) error {
+	eventsPath, _ := cmd.Flags().GetString("events")
+	objectsPath, _ := cmd.Flags().GetString("objects")
+	outputPath, _ := cmd.Flags().GetString("output")
+	if eventsPath == "" || objectsPath == "" {
+		return errors.New("both --events and --objects are required")
+	}
+
+	var objects []runtime.Object
+	for _, path := range []string{eventsPath, objectsPath} {
+		decoded, err := readObjects(path)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, decoded...)
+	}
+
+	out, err := api.OpenDryRunOutput(outputPath, 0)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return collector.Replay(context.Background(), config.NewStore(agentConfig), objects, api.NewDryRunRecorder(out))
+}
+
+func readObjects(path string) ([]runtime.Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	objects, err := collector.DecodeObjects(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+	return objects, nil
+}
+
+func init() {
+	replayCmd.Flags().String("events", "", "JSON or YAML file of recorded core/v1 Events, e.g. from kubectl get events -o json")
+	replayCmd.Flags().String("objects", "", "JSON or YAML file of the CronJobs, Jobs and Pods the events refer to, e.g. from kubectl get cronjobs,jobs,pods -o yaml")
+	replayCmd.Flags().String("output", "", "File to write the telemetry that would have been sent to (defaults to stdout)")
+	replayCmd.Flags().Bool("ship-logs", false, "Include the log uploads the agent would send with --ship-logs")
+	replayCmd.Flags().String("pod-filter", "", "Optional regular expression (on pod.name) to limit which pods are monitored")
+
+	RootCmd.AddCommand(replayCmd)
+}
+
+func initializeReplayConfig(replayCmd *cobra.Command, args []string) error {
+	_ = viper.BindPFlag("ship-logs", replayCmd.Flags().Lookup("ship-logs"))
+	_ = viper.BindPFlag("pod-filter", replayCmd.Flags().Lookup("pod-filter"))
+	// Logs go to stderr when the telemetry is printed to stdout
+	output, _ := replayCmd.Flags().GetString("output")
+	stdoutIsOutput = output == ""
+
+	// Like the agent command, this overrides the root command's PersistentPreRunE, so call it
+	if replayCmd.Parent().PersistentPreRunE != nil {
+		return replayCmd.Parent().PersistentPreRunE(replayCmd.Parent(), args)
+	}
+	return nil
+}
@@ -1,12 +1,12 @@
 package cmd
 
 import (
-	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"regexp"
 
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -16,6 +16,9 @@ var RootCmd = &cobra.Command{
 	Use:               "cronitor-kubernetes",
 }
 
+// agentConfig is the validated configuration, loaded before any command runs.
+var agentConfig *config.Config
+
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, err)
@@ -24,17 +27,28 @@ func Execute() {
 }
 
 func init() {
+	RootCmd.PersistentFlags().String("config", "", "Optional path to a YAML config file, reloaded when it changes")
 	RootCmd.PersistentFlags().String("kubeconfig", "", "path to a kubeconfig to use")
-	RootCmd.PersistentFlags().String("apikey", "", "Cronitor.io API key")
+	RootCmd.PersistentFlags().String("apikey", "", "Cronitor.io API key, used to manage monitors")
+	RootCmd.PersistentFlags().String("apikey-file", "", "Path to a file holding the Cronitor.io API key (e.g. a mounted Secret), watched for key rotations")
+	RootCmd.PersistentFlags().String("telemetry-key", "", "Cronitor.io telemetry key, used to send pings (defaults to the API key)")
 	RootCmd.PersistentFlags().String("hostname-override", "", "App hostname to use (mainly for testing)")
 	RootCmd.PersistentFlags().String("log-level", "", "Minimum log level to print for the agent (DEBUG, INFO, WARN, ERROR)")
 	RootCmd.PersistentFlags().String("log-format", "", "Log output format (text, json)")
+	RootCmd.PersistentFlags().String("proxy", "", "Proxy URL to send every outbound request through (defaults to the HTTPS_PROXY/NO_PROXY environment variables)")
+	RootCmd.PersistentFlags().String("ca-file", "", "Path to a PEM bundle of extra certificate authorities to trust, e.g. for a TLS-intercepting proxy")
+	RootCmd.PersistentFlags().String("client-cert-file", "", "Path to a PEM client certificate to present for mutual TLS")
+	RootCmd.PersistentFlags().String("client-key-file", "", "Path to the PEM key of the client certificate")
+	RootCmd.PersistentFlags().Duration("connect-timeout", 0, "Timeout for establishing outbound connections, including the TLS handshake (default 30s)")
+	RootCmd.PersistentFlags().Duration("response-timeout", 0, "Timeout for each outbound request, including reading the response (default 2m)")
 	_ = RootCmd.PersistentFlags().MarkHidden("hostname-override")
 	RootCmd.PersistentFlags().Bool("dev", false, "Set the CLI to dev mode (for things like logs, etc.)")
 	_ = RootCmd.PersistentFlags().MarkHidden("dev")
 }
 
 func initializeConfig(cmd *cobra.Command, args []string) error {
+	_ = viper.BindEnv("config", "CRONITOR_AGENT_CONFIG")
+	_ = viper.BindPFlag("config", cmd.Flags().Lookup("config"))
 	_ = viper.BindPFlag("kubeconfig", cmd.Flags().Lookup("kubeconfig"))
 	_ = viper.BindPFlag("hostname-override", cmd.Flags().Lookup("hostname-override"))
 	_ = viper.BindEnv("hostname-override", "CRONITOR_HOSTNAME_OVERRIDE")
@@ -42,56 +56,90 @@ func initializeConfig(cmd *cobra.Command, args []string) error {
 	_ = viper.BindPFlag("log-level", cmd.Flags().Lookup("log-level"))
 	_ = viper.BindPFlag("log-format", cmd.Flags().Lookup("log-format"))
 	_ = viper.BindEnv("version", "APP_VERSION")
+	_ = viper.BindEnv("default-behavior", "DEFAULT_BEHAVIOR")
+	_ = viper.BindEnv("default-env", "DEFAULT_ENV")
+	_ = viper.BindEnv("tags", "TAGS")
 
 	_ = viper.BindEnv("apikey", "CRONITOR_API_KEY")
 	_ = viper.BindPFlag("apikey", cmd.Flags().Lookup("apikey"))
-	apiKey := viper.GetString("apikey")
-
-	if apiKey == "<api key>" {
-		message := "A valid api key is required. You used the string '<api key>' as the api key, which is invalid"
-		slog.Error(message)
-		return errors.New(message)
-	} else if matched, _ := regexp.MatchString(`[\w0-9]+`, apiKey); !matched {
-		message := "you have provided an invalid API key. Cronitor API keys are comprised only of number and letter characters"
-		slog.Error(message)
-		return errors.New(message)
+	_ = viper.BindEnv("apikey-file", "CRONITOR_API_KEY_FILE")
+	_ = viper.BindPFlag("apikey-file", cmd.Flags().Lookup("apikey-file"))
+	_ = viper.BindEnv("telemetry-key", "CRONITOR_TELEMETRY_KEY")
+	_ = viper.BindPFlag("telemetry-key", cmd.Flags().Lookup("telemetry-key"))
+
+	_ = viper.BindEnv("proxy", "CRONITOR_AGENT_PROXY")
+	_ = viper.BindPFlag("proxy", cmd.Flags().Lookup("proxy"))
+	_ = viper.BindEnv("ca-file", "CRONITOR_AGENT_CA_FILE")
+	_ = viper.BindPFlag("ca-file", cmd.Flags().Lookup("ca-file"))
+	_ = viper.BindEnv("client-cert-file", "CRONITOR_AGENT_CLIENT_CERT_FILE")
+	_ = viper.BindPFlag("client-cert-file", cmd.Flags().Lookup("client-cert-file"))
+	_ = viper.BindEnv("client-key-file", "CRONITOR_AGENT_CLIENT_KEY_FILE")
+	_ = viper.BindPFlag("client-key-file", cmd.Flags().Lookup("client-key-file"))
+	_ = viper.BindEnv("connect-timeout", "CRONITOR_AGENT_CONNECT_TIMEOUT")
+	_ = viper.BindPFlag("connect-timeout", cmd.Flags().Lookup("connect-timeout"))
+	_ = viper.BindEnv("response-timeout", "CRONITOR_AGENT_RESPONSE_TIMEOUT")
+	_ = viper.BindPFlag("response-timeout", cmd.Flags().Lookup("response-timeout"))
+
+	cfg, err := config.Load(viper.GetViper())
+	if err != nil {
+		slog.Error("could not load configuration", "error", err)
+		return err
 	}
+	agentConfig = cfg
 
 	// Configure logging based on level and format
-	logLevel := viper.GetString("log-level")
-	logFormat := viper.GetString("log-format")
-
-	if logLevel != "" || logFormat != "" {
-		var level slog.Level
-		switch logLevel {
-		case "DEBUG":
-			level = slog.LevelDebug
-		case "INFO", "":
-			level = slog.LevelInfo
-		case "WARN":
-			level = slog.LevelWarn
-		case "ERROR":
-			level = slog.LevelError
-		default:
-			return fmt.Errorf("invalid log level: %s", logLevel)
-		}
-
-		opts := &slog.HandlerOptions{
-			Level: level,
-		}
-
-		var handler slog.Handler
-		switch logFormat {
-		case "json":
-			handler = slog.NewJSONHandler(os.Stdout, opts)
-		case "text", "":
-			handler = slog.NewTextHandler(os.Stdout, opts)
-		default:
-			return fmt.Errorf("invalid log format: %s (must be 'text' or 'json')", logFormat)
-		}
-
-		slog.SetDefault(slog.New(handler))
+	logLevel := cfg.LogLevel
+	logFormat := cfg.LogFormat
+
+	var level slog.Level
+	switch logLevel {
+	case "DEBUG":
+		level = slog.LevelDebug
+	case "INFO", "":
+		level = slog.LevelInfo
+	case "WARN":
+		level = slog.LevelWarn
+	case "ERROR":
+		level = slog.LevelError
+	default:
+		return fmt.Errorf("invalid log level: %s", logLevel)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: level,
+	}
+
+	var handler slog.Handler
+	switch logFormat {
+	case "json":
+		handler = slog.NewJSONHandler(logWriter(cfg), opts)
+	case "text", "":
+		handler = slog.NewTextHandler(logWriter(cfg), opts)
+	default:
+		return fmt.Errorf("invalid log format: %s (must be 'text' or 'json')", logFormat)
 	}
 
+	// The default handler is replaced even without a level or format, as it writes to stderr
+	slog.SetDefault(slog.New(handler))
+
+	client, err := transport.NewClient(cfg.TransportOptions())
+	if err != nil {
+		slog.Error("could not configure the HTTP client", "error", err)
+		return err
+	}
+	transport.SetClient(client)
+
 	return nil
 }
+
+// stdoutIsOutput is set by commands that write their output to stdout, e.g. replay.
+var stdoutIsOutput bool
+
+// logWriter returns where logs are written: stderr when the dry-run records or a command's
+// output go to stdout, so that the two can be told apart, and stdout otherwise.
+func logWriter(cfg *config.Config) *os.File {
+	if stdoutIsOutput || cfg.DryRun && cfg.DryRunOutput == "" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
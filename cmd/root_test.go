@@ -9,6 +9,8 @@ import (
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
 )
 
 func TestLogLevelParsing(t *testing.T) {
@@ -290,3 +292,21 @@ func TestInvalidApiKey(t *testing.T) {
 		t.Error("Invalid API key provided; somehow RootCmd executed anyway")
 	}
 }
+
+func TestLogWriter(t *testing.T) {
+	if logWriter(&config.Config{}) != os.Stdout {
+		t.Error("expected logs to go to stdout")
+	}
+	if logWriter(&config.Config{DryRun: true, DryRunOutput: "/tmp/dryrun.jsonl"}) != os.Stdout {
+		t.Error("expected logs to go to stdout when the dry-run records go to a file")
+	}
+	if logWriter(&config.Config{DryRun: true}) != os.Stderr {
+		t.Error("expected logs to go to stderr when the dry-run records go to stdout")
+	}
+
+	stdoutIsOutput = true
+	defer func() { stdoutIsOutput = false }()
+	if logWriter(&config.Config{}) != os.Stderr {
+		t.Error("expected logs to go to stderr when the command's output goes to stdout")
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"time"
 
 	"github.com/cronitorio/cronitor-kubernetes/cmd"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
 	"github.com/getsentry/sentry-go"
 )
 
 func init() {
-	// Configure default slog handler (can be overridden via --log-format and --log-level flags)
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	// Configure default slog handler (can be overridden via --log-format and --log-level flags).
+	// It writes to stderr until the configuration is loaded, as stdout may carry dry-run records.
+	handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
 		Level:     slog.LevelInfo,
 		AddSource: false,
 	})
@@ -24,6 +26,8 @@ func main() {
 		err := sentry.Init(sentry.ClientOptions{
 			Dsn:              "https://e36895dc862642deae6ba3773924d1f6@o131626.ingest.sentry.io/6031178",
 			AttachStacktrace: true,
+			// Sentry's requests go through the proxy and TLS settings the agent is configured with
+			HTTPTransport: transport.Transport(),
 		})
 		if err != nil {
 			slog.Error("sentry initialization failed", "error", err)
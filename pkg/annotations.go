@@ -2,10 +2,10 @@ package pkg
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
+	"github.com/cronitorio/cronitor-kubernetes/pkg/policy"
 	v1 "k8s.io/api/batch/v1"
 )
 
@@ -115,22 +115,88 @@ const (
 	// The only valid values are "true" and "false". Default is "false".
 	AnnotationSendPodStartEvent CronitorAnnotation = "k8s.cronitor.io/send-pod-start-event"
 
+	// AnnotationDefaultBehavior is set on a Namespace to decide whether its CronJobs are
+	// included or excluded when they have no include/exclude annotation of their own.
+	// Overrides the chart-wide default for that namespace.
+	// The only valid values are "include" and "exclude".
+	AnnotationDefaultBehavior CronitorAnnotation = "k8s.cronitor.io/default-behavior"
+
 	// AnnotationMetricDuration lets you set duration assertions on the monitor.
 	// The value should be a comparison string starting with "<" or ">", followed by a number and an optional time unit.
 	// Multiple assertions can be comma-separated.
 	// Examples: "< 5 seconds", "> 1 minute", "< 30 seconds, > 5 seconds"
 	// Supported time units: "seconds", "minutes", "hours" (singular forms are also accepted).
 	AnnotationMetricDuration CronitorAnnotation = "k8s.cronitor.io/metric.duration"
+
+	// AnnotationEventRules lets you change how the events of the CronJob, its Jobs and their Pods
+	// are reported, ahead of the agent's own rules.
+	// The value is a JSON (or YAML) list of rules, each with a kind, a reason, an optional message
+	// regular expression and the state to report: run, complete, fail, ok, logs or ignore.
+	// Example: [{"kind": "Pod", "reason": "BackOff", "message": "pulling image", "state": "ignore"}]
+	AnnotationEventRules CronitorAnnotation = "k8s.cronitor.io/event-rules"
+
+	// AnnotationMainContainer names the containers of the CronJob's Pods whose exit ends a run,
+	// when sidecar containers keep the Pod running after them.
+	// Comma-separated list of container names. Default: every container but known sidecars.
+	AnnotationMainContainer CronitorAnnotation = "k8s.cronitor.io/main-container"
 )
 
+// ChartDefaults are the agent-wide defaults for CronJobs, set in the chart or the agent's config.
+type ChartDefaults struct {
+	// Behavior is "include" or "exclude"; empty means "include"
+	Behavior    string
+	Environment string
+	// Tags are added to every CronJob's monitor
+	Tags []string
+}
+
+// NamespaceAnnotationsLookup returns the annotations of a namespace, and whether the namespace is known.
+type NamespaceAnnotationsLookup func(namespace string) (map[string]string, bool)
+
+// PolicyDefaultsLookup returns the merged CronitorPolicy defaults that apply to a CronJob.
+type PolicyDefaultsLookup func(cronjob *v1.CronJob) policy.Defaults
+
+// EventRulesLookup returns the event rules already parsed from a CronJob's annotation, and
+// whether they are known for the annotation it has now.
+type EventRulesLookup func(cronjob *v1.CronJob) ([]EventRule, bool)
+
+// ConfigSources are where CronitorConfigParser finds the defaults for the settings a CronJob
+// doesn't configure with its own annotations. Any of them may be nil, which disables it.
+//
+// Each setting is taken from the first of these that provides it:
+//  1. the CronJob's own annotations
+//  2. its namespace's annotations (NamespaceAnnotations), for env, tags, notify, group and default behavior
+//  3. CronitorPolicies in its namespace, then ClusterCronitorPolicies (PolicyDefaults)
+//  4. the chart-wide defaults (ChartDefaults)
+//
+// EventRules saves GetEventRules parsing the CronJob's annotation again.
+type ConfigSources struct {
+	ChartDefaults        func() ChartDefaults
+	NamespaceAnnotations NamespaceAnnotationsLookup
+	PolicyDefaults       PolicyDefaultsLookup
+	EventRules           EventRulesLookup
+}
+
 type CronitorConfigParser struct {
-	cronjob *v1.CronJob
+	cronjob              *v1.CronJob
+	chartDefaults        ChartDefaults
+	namespaceAnnotations map[string]string
+	policyDefaults       policy.Defaults
+	eventRules           EventRulesLookup
 }
 
-func NewCronitorConfigParser(cronjob *v1.CronJob) CronitorConfigParser {
-	return CronitorConfigParser{
-		cronjob: cronjob,
+func NewCronitorConfigParser(cronjob *v1.CronJob, sources ConfigSources) CronitorConfigParser {
+	parser := CronitorConfigParser{cronjob: cronjob, eventRules: sources.EventRules}
+	if sources.ChartDefaults != nil {
+		parser.chartDefaults = sources.ChartDefaults()
+	}
+	if sources.NamespaceAnnotations != nil {
+		parser.namespaceAnnotations, _ = sources.NamespaceAnnotations(cronjob.Namespace)
+	}
+	if sources.PolicyDefaults != nil {
+		parser.policyDefaults = sources.PolicyDefaults(cronjob)
 	}
+	return parser
 }
 
 // getAnnotationWithFallback retrieves an annotation value, checking the preferred annotation first
@@ -148,11 +214,29 @@ func (cronitorParser CronitorConfigParser) getAnnotationWithFallback(preferred,
 	return "", false
 }
 
+// getInheritedAnnotation retrieves an annotation from the CronJob, or, if the CronJob doesn't
+// set it, from the CronJob's namespace. legacy may be empty if there is no legacy annotation.
+func (cronitorParser CronitorConfigParser) getInheritedAnnotation(preferred, legacy CronitorAnnotation) (string, bool) {
+	if value, ok := cronitorParser.getAnnotationWithFallback(preferred, legacy); ok {
+		return value, true
+	}
+	if value, ok := cronitorParser.namespaceAnnotations[string(preferred)]; ok {
+		return value, true
+	}
+	if value, ok := cronitorParser.namespaceAnnotations[string(legacy)]; ok && legacy != "" {
+		return value, true
+	}
+	return "", false
+}
+
 func (cronitorParser CronitorConfigParser) GetEnvironment() string {
-	if env, ok := cronitorParser.cronjob.Annotations[string(AnnotationEnvironment)]; ok && env != "" {
+	if env, ok := cronitorParser.getInheritedAnnotation(AnnotationEnvironment, ""); ok && env != "" {
+		return env
+	}
+	if env := cronitorParser.policyDefaults.Env; env != "" {
 		return env
 	}
-	if defaultEnvironment := os.Getenv("DEFAULT_ENV"); defaultEnvironment != "" {
+	if defaultEnvironment := cronitorParser.chartDefaults.Environment; defaultEnvironment != "" {
 		return defaultEnvironment
 	}
 	return ""
@@ -174,18 +258,16 @@ func (cronitorParser CronitorConfigParser) GetTimezone() string {
 func (cronitorParser CronitorConfigParser) GetTags() []string {
 	var tagList []string
 
-	// Get tags from Helm chart (via the environment)
-	if stringEnvTagList := os.Getenv("TAGS"); stringEnvTagList != "" {
-		for _, value := range strings.Split(stringEnvTagList, ",") {
-			tagList = append(tagList, strings.TrimSpace(value))
-		}
-	}
+	// Get tags from the Helm chart
+	tagList = append(tagList, cronitorParser.chartDefaults.Tags...)
 
-	// Get tags from CronJob annotations
-	if stringTagList, ok := cronitorParser.cronjob.Annotations[string(AnnotationTags)]; ok && stringTagList != "" {
+	// Get tags from CronJob annotations, or its namespace's or policies' if the CronJob has none
+	if stringTagList, ok := cronitorParser.getInheritedAnnotation(AnnotationTags, ""); ok && stringTagList != "" {
 		for _, value := range strings.Split(stringTagList, ",") {
 			tagList = append(tagList, strings.TrimSpace(value))
 		}
+	} else {
+		tagList = append(tagList, cronitorParser.policyDefaults.Tags...)
 	}
 
 	return tagList
@@ -218,6 +300,8 @@ func (cronitorParser CronitorConfigParser) GetCronitorID() string {
 	// Supports both new (key-inference) and legacy (id-inference) annotation names
 	if annotation, ok := cronitorParser.getAnnotationWithFallback(AnnotationKeyInference, AnnotationIDInference); ok && annotation != "" {
 		inference = annotation
+	} else if policyInference := cronitorParser.policyDefaults.KeyInference; policyInference != "" {
+		inference = policyInference
 	}
 
 	// Return the appropriate ID based on the inference
@@ -255,6 +339,8 @@ func (cronitorParser CronitorConfigParser) GetCronitorName() string {
 	// Check if a prefix annotation exists and override the default if present
 	if annotation, ok := cronitorParser.cronjob.Annotations[string(AnnotationNamePrefix)]; ok && annotation != "" {
 		prefix = annotation
+	} else if policyPrefix := cronitorParser.policyDefaults.NamePrefix; policyPrefix != "" {
+		prefix = policyPrefix
 	}
 
 	// Construct the name based on the prefix
@@ -271,7 +357,10 @@ func (cronitorParser CronitorConfigParser) GetCronitorName() string {
 // Inclusion/exclusion behavior
 
 func (cronitorParser CronitorConfigParser) getDefaultBehavior() defaultBehaviorValue {
-	defaultBehavior := defaultBehaviorValue(os.Getenv("DEFAULT_BEHAVIOR"))
+	if namespaceDefault, ok := cronitorParser.namespaceAnnotations[string(AnnotationDefaultBehavior)]; ok && namespaceDefault != "" {
+		return defaultBehaviorValue(namespaceDefault)
+	}
+	defaultBehavior := defaultBehaviorValue(cronitorParser.chartDefaults.Behavior)
 	if defaultBehavior == defaultBehaviorNoneProvided {
 		defaultBehavior = defaultBehaviorInclude
 	}
@@ -299,30 +388,34 @@ func (cronitorParser CronitorConfigParser) IsCronJobIncluded() (bool, error) {
 		returnBool, err := strconv.ParseBool(raw)
 		return !returnBool, err
 	default:
-		return false, fmt.Errorf("invalid DEFAULT_BEHAVIOR value of \"%s\" provided", defaultBehavior)
+		if _, ok := cronitorParser.namespaceAnnotations[string(AnnotationDefaultBehavior)]; ok {
+			return false, fmt.Errorf("invalid %s value of \"%s\" on namespace %s", AnnotationDefaultBehavior, defaultBehavior, cronjob.Namespace)
+		}
+		return false, fmt.Errorf("invalid default behavior value of \"%s\" provided", defaultBehavior)
 	}
 }
 
-// GetNotify returns the notification list keys for this CronJob.
+// GetNotify returns the notification list keys for this CronJob, inherited from its namespace or policies if not set.
 // Supports both k8s.cronitor.io/notify (preferred) and k8s.cronitor.io/cronitor-notify (legacy).
 func (cronitorParser CronitorConfigParser) GetNotify() []string {
 	var notifications []string
 
-	if stringNotificationList, ok := cronitorParser.getAnnotationWithFallback(AnnotationNotify, AnnotationCronitorNotify); ok {
+	if stringNotificationList, ok := cronitorParser.getInheritedAnnotation(AnnotationNotify, AnnotationCronitorNotify); ok {
 		for _, value := range strings.Split(stringNotificationList, ",") {
 			notifications = append(notifications, strings.TrimSpace(value))
 		}
+		return notifications
 	}
-	return notifications
+	return cronitorParser.policyDefaults.Notify
 }
 
-// GetGroup returns the group key for this CronJob.
+// GetGroup returns the group key for this CronJob, inherited from its namespace or policies if not set.
 // Supports both k8s.cronitor.io/group (preferred) and k8s.cronitor.io/cronitor-group (legacy).
 func (cronitorParser CronitorConfigParser) GetGroup() string {
-	if group, ok := cronitorParser.getAnnotationWithFallback(AnnotationGroup, AnnotationCronitorGroup); ok {
+	if group, ok := cronitorParser.getInheritedAnnotation(AnnotationGroup, AnnotationCronitorGroup); ok {
 		return group
 	}
-	return ""
+	return cronitorParser.policyDefaults.Group
 }
 
 // GetGraceSeconds returns the grace seconds for this CronJob.
@@ -335,6 +428,9 @@ func (cronitorParser CronitorConfigParser) GetGraceSeconds() int {
 		}
 		return graceSecondsInt
 	}
+	if policyGraceSeconds := cronitorParser.policyDefaults.GraceSeconds; policyGraceSeconds != nil {
+		return *policyGraceSeconds
+	}
 	return -1
 }
 
@@ -376,6 +472,35 @@ func (cronitorParser CronitorConfigParser) SendPodStartEvent() bool {
 	return false
 }
 
+// GetEventRules returns the CronJob's own event rules, which take precedence over the agent's.
+func (cronitorParser CronitorConfigParser) GetEventRules() ([]EventRule, error) {
+	if cronitorParser.eventRules != nil {
+		if rules, ok := cronitorParser.eventRules(cronitorParser.cronjob); ok {
+			return rules, nil
+		}
+	}
+	raw, ok := cronitorParser.cronjob.Annotations[string(AnnotationEventRules)]
+	if !ok {
+		return nil, nil
+	}
+	rules, err := ParseEventRules(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %w", AnnotationEventRules, err)
+	}
+	return rules, nil
+}
+
+// GetMainContainers returns the names of the containers the CronJob names as its main ones, if any.
+func (cronitorParser CronitorConfigParser) GetMainContainers() []string {
+	var containers []string
+	for _, name := range strings.Split(cronitorParser.cronjob.Annotations[string(AnnotationMainContainer)], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			containers = append(containers, name)
+		}
+	}
+	return containers
+}
+
 // GetMetricDuration returns the raw metric.duration annotation value.
 // The value is expected to be a comparison string like "< 5 seconds" or "> 1 minute".
 // Multiple assertions can be comma-separated.
@@ -385,3 +510,9 @@ func (cronitorParser CronitorConfigParser) GetMetricDuration() string {
 	}
 	return ""
 }
+
+// GetPolicyAssertions returns the assertions from matching CronitorPolicies. They are used
+// only when the CronJob has no k8s.cronitor.io/metric.duration annotation of its own.
+func (cronitorParser CronitorConfigParser) GetPolicyAssertions() []string {
+	return cronitorParser.policyDefaults.Assertions
+}
@@ -2,16 +2,16 @@ package pkg
 
 import (
 	"encoding/json"
-	"os"
 	"strconv"
 	"testing"
 
+	"github.com/cronitorio/cronitor-kubernetes/pkg/policy"
 	v1 "k8s.io/api/batch/v1"
 )
 
 func TestCronJobInclusion(t *testing.T) {
 	var jsonBlob v1.CronJobList
-	os.Setenv("DEFAULT_BEHAVIOR", "include")
+	sources := ConfigSources{ChartDefaults: func() ChartDefaults { return ChartDefaults{Behavior: "include"} }}
 	err := json.Unmarshal([]byte(`{"metadata":{"selfLink":"/apis/batch/v1beta1/cronjobs","resourceVersion":"41530"},"items":[{"metadata":{"name":"eventrouter-test-croonjob","namespace":"cronitor","selfLink":"/apis/batch/v1beta1/namespaces/cronitor/cronjobs/eventrouter-test-croonjob","uid":"a4892036-090f-4019-8bd1-98bfe0a9034c","resourceVersion":"41467","creationTimestamp":"2020-11-13T06:06:44Z","labels":{"app.kubernetes.io/managed-by":"skaffold","skaffold.dev/run-id":"a592b4e3-dd8e-4b25-a69f-7abe35e264f0"},"managedFields":[{"manager":"Go-http-client","operation":"Update","ApiVersion":"batch/v1beta1","time":"2020-11-13T06:06:44Z","fieldsType":"FieldsV1","fieldsV1":{"f:spec":{"f:concurrencyPolicy":{},"f:failedJobsHistoryLimit":{},"f:jobTemplate":{"f:spec":{"f:template":{"f:spec":{"f:containers":{"k:{\"name\":\"hello\"}":{".":{},"f:args":{},"f:image":{},"f:imagePullPolicy":{},"f:name":{},"f:resources":{},"f:terminationMessagePath":{},"f:terminationMessagePolicy":{}}},"f:dnsPolicy":{},"f:restartPolicy":{},"f:schedulerName":{},"f:securityContext":{},"f:terminationGracePeriodSeconds":{}}}}},"f:schedule":{},"f:successfulJobsHistoryLimit":{},"f:suspend":{}}}},{"manager":"skaffold","operation":"Update","ApiVersion":"batch/v1beta1","time":"2020-11-13T06:06:45Z","fieldsType":"FieldsV1","fieldsV1":{"f:metadata":{"f:labels":{".":{},"f:app.kubernetes.io/managed-by":{},"f:skaffold.dev/run-id":{}}}}},{"manager":"kube-controller-manager","operation":"Update","ApiVersion":"batch/v1beta1","time":"2020-11-13T07:57:06Z","fieldsType":"FieldsV1","fieldsV1":{"f:status":{"f:active":{},"f:lastScheduleTime":{}}}}]},"spec":{"schedule":"*/1 * * * *","concurrencyPolicy":"Forbid","suspend":false,"jobTemplate":{"metadata":{"creationTimestamp":null},"spec":{"template":{"metadata":{"creationTimestamp":null},"spec":{"containers":[{"name":"hello","image":"busybox","args":["/bin/sh","-c","date ; echo Hello from k8s"],"resources":{},"terminationMessagePath":"/dev/termination-log","terminationMessagePolicy":"File","imagePullPolicy":"Always"}],"restartPolicy":"OnFailure","terminationGracePeriodSeconds":30,"dnsPolicy":"ClusterFirst","securityContext":{},"schedulerName":"default-scheduler"}}}},"successfulJobsHistoryLimit":3,"failedJobsHistoryLimit":1},"status":{"active":[{"kind":"Job","namespace":"cronitor","name":"eventrouter-test-croonjob-1605254220","uid":"697df5f5-6366-42fe-a20e-19ec2fefd826","ApiVersion":"batch/v1","resourceVersion":"41465"}],"lastScheduleTime":"2020-11-13T07:57:00Z"}}]}`), &jsonBlob)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
@@ -22,7 +22,7 @@ func TestCronJobInclusion(t *testing.T) {
 		t.Errorf("len(CronJobs) = %d; want 1", got)
 	}
 
-	parser := NewCronitorConfigParser(&jsonBlob.Items[0])
+	parser := NewCronitorConfigParser(&jsonBlob.Items[0], sources)
 	if got, _ := parser.IsCronJobIncluded(); !got {
 		t.Errorf("cronjob.IsCronJobIncluded() = %s; wanted true", strconv.FormatBool(got))
 	}
@@ -40,7 +40,7 @@ func TestGetSchedule(t *testing.T) {
 		t.Errorf("len(CronJobs) = %d; want 1", got)
 	}
 
-	parser := NewCronitorConfigParser(&jsonBlob.Items[0])
+	parser := NewCronitorConfigParser(&jsonBlob.Items[0], ConfigSources{})
 	if result := parser.GetSchedule(); result != "*/1 * * * *" {
 		t.Errorf("expected schedule \"*/1 * * * *\", got %s", result)
 	}
@@ -79,7 +79,7 @@ func TestGetCronitorID(t *testing.T) {
 				t.Fatalf("unexpected error unmarshalling json: %v", err)
 			}
 
-			parser := NewCronitorConfigParser(&cronJob)
+			parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 			if id := parser.GetCronitorID(); id != tc.expectedID {
 				t.Errorf("expected ID %s, got %s", tc.expectedID, id)
 			}
@@ -132,7 +132,7 @@ func TestGetCronitorName(t *testing.T) {
 				t.Fatalf("unexpected error unmarshalling json: %v", err)
 			}
 
-			parser := NewCronitorConfigParser(&cronJob)
+			parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 			if name := parser.GetCronitorName(); name != tc.expectedName {
 				t.Errorf("expected Name %s, got %s", tc.expectedName, name)
 			}
@@ -187,7 +187,7 @@ func TestLogCompleteEvent(t *testing.T) {
 				t.Fatalf("failed to create CronJob from annotations: %v", err)
 			}
 
-			parser := NewCronitorConfigParser(&cronJob)
+			parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 			got, err := parser.LogCompleteEvent()
 
 			if tc.expectError {
@@ -248,7 +248,7 @@ func TestAnnotationBackwardsCompatibility(t *testing.T) {
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
-				parser := NewCronitorConfigParser(&cronJob)
+				parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 				if id := parser.GetSpecifiedCronitorID(); id != tc.expectedID {
 					t.Errorf("expected ID %s, got %s", tc.expectedID, id)
 				}
@@ -292,7 +292,7 @@ func TestAnnotationBackwardsCompatibility(t *testing.T) {
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
-				parser := NewCronitorConfigParser(&cronJob)
+				parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 				if name := parser.GetCronitorName(); name != tc.expectedName {
 					t.Errorf("expected Name %s, got %s", tc.expectedName, name)
 				}
@@ -336,7 +336,7 @@ func TestAnnotationBackwardsCompatibility(t *testing.T) {
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
-				parser := NewCronitorConfigParser(&cronJob)
+				parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 				if group := parser.GetGroup(); group != tc.expectedGroup {
 					t.Errorf("expected Group %s, got %s", tc.expectedGroup, group)
 				}
@@ -387,7 +387,7 @@ func TestAnnotationBackwardsCompatibility(t *testing.T) {
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
-				parser := NewCronitorConfigParser(&cronJob)
+				parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 				notify := parser.GetNotify()
 				if len(notify) != len(tc.expectedNotify) {
 					t.Errorf("expected %d notify entries, got %d", len(tc.expectedNotify), len(notify))
@@ -443,7 +443,7 @@ func TestAnnotationBackwardsCompatibility(t *testing.T) {
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
-				parser := NewCronitorConfigParser(&cronJob)
+				parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 				if graceSeconds := parser.GetGraceSeconds(); graceSeconds != tc.expectedGraceSeconds {
 					t.Errorf("expected GraceSeconds %d, got %d", tc.expectedGraceSeconds, graceSeconds)
 				}
@@ -490,7 +490,7 @@ func TestAnnotationBackwardsCompatibility(t *testing.T) {
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
-				parser := NewCronitorConfigParser(&cronJob)
+				parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 				if id := parser.GetCronitorID(); id != tc.expectedID {
 					t.Errorf("expected ID %s, got %s", tc.expectedID, id)
 				}
@@ -539,7 +539,7 @@ func TestGetMetricDuration(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-			parser := NewCronitorConfigParser(&cronJob)
+			parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 			if got := parser.GetMetricDuration(); got != tc.expected {
 				t.Errorf("GetMetricDuration() = %q, want %q", got, tc.expected)
 			}
@@ -589,7 +589,7 @@ func TestSendPodStartEvent(t *testing.T) {
 				t.Fatalf("failed to create CronJob from annotations: %v", err)
 			}
 
-			parser := NewCronitorConfigParser(&cronJob)
+			parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 			if got := parser.SendPodStartEvent(); got != tc.expectedValue {
 				t.Errorf("SendPodStartEvent() = %v, want %v", got, tc.expectedValue)
 			}
@@ -630,10 +630,157 @@ func TestGetNote(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-			parser := NewCronitorConfigParser(&cronJob)
+			parser := NewCronitorConfigParser(&cronJob, ConfigSources{})
 			if note := parser.GetNote(); note != tc.expectedNote {
 				t.Errorf("expected Note %q, got %q", tc.expectedNote, note)
 			}
 		})
 	}
 }
+
+func TestNamespaceDefaultAnnotations(t *testing.T) {
+	sources := ConfigSources{NamespaceAnnotations: func(namespace string) (map[string]string, bool) {
+		if namespace != "default" {
+			return nil, false
+		}
+		return map[string]string{
+			"k8s.cronitor.io/env":              "staging",
+			"k8s.cronitor.io/tags":             "team-a, batch",
+			"k8s.cronitor.io/cronitor-notify":  "team-a-oncall",
+			"k8s.cronitor.io/group":            "team-a",
+			"k8s.cronitor.io/default-behavior": "exclude",
+		}, true
+	}}
+
+	t.Run("inherits from namespace", func(t *testing.T) {
+		cronJob, err := CronJobFromAnnotations(nil)
+		if err != nil {
+			t.Fatalf("failed to create CronJob from annotations: %v", err)
+		}
+		parser := NewCronitorConfigParser(&cronJob, sources)
+		if got := parser.GetEnvironment(); got != "staging" {
+			t.Errorf("GetEnvironment() = %q, want %q", got, "staging")
+		}
+		if got := parser.GetTags(); len(got) != 2 || got[0] != "team-a" || got[1] != "batch" {
+			t.Errorf("GetTags() = %v, want [team-a batch]", got)
+		}
+		if got := parser.GetNotify(); len(got) != 1 || got[0] != "team-a-oncall" {
+			t.Errorf("GetNotify() = %v, want [team-a-oncall]", got)
+		}
+		if got := parser.GetGroup(); got != "team-a" {
+			t.Errorf("GetGroup() = %q, want %q", got, "team-a")
+		}
+		if included, err := parser.IsCronJobIncluded(); err != nil || included {
+			t.Errorf("IsCronJobIncluded() = %v, %v; want false, nil", included, err)
+		}
+	})
+
+	t.Run("CronJob annotations take precedence", func(t *testing.T) {
+		cronJob, err := CronJobFromAnnotations([]Annotation{
+			{Key: "k8s.cronitor.io/env", Value: "production"},
+			{Key: "k8s.cronitor.io/tags", Value: "reports"},
+			{Key: "k8s.cronitor.io/notify", Value: "default"},
+			{Key: "k8s.cronitor.io/include", Value: "true"},
+		})
+		if err != nil {
+			t.Fatalf("failed to create CronJob from annotations: %v", err)
+		}
+		parser := NewCronitorConfigParser(&cronJob, sources)
+		if got := parser.GetEnvironment(); got != "production" {
+			t.Errorf("GetEnvironment() = %q, want %q", got, "production")
+		}
+		if got := parser.GetTags(); len(got) != 1 || got[0] != "reports" {
+			t.Errorf("GetTags() = %v, want [reports]", got)
+		}
+		if got := parser.GetNotify(); len(got) != 1 || got[0] != "default" {
+			t.Errorf("GetNotify() = %v, want [default]", got)
+		}
+		if included, err := parser.IsCronJobIncluded(); err != nil || !included {
+			t.Errorf("IsCronJobIncluded() = %v, %v; want true, nil", included, err)
+		}
+	})
+
+	t.Run("invalid namespace default behavior", func(t *testing.T) {
+		sources := ConfigSources{NamespaceAnnotations: func(namespace string) (map[string]string, bool) {
+			return map[string]string{"k8s.cronitor.io/default-behavior": "sometimes"}, true
+		}}
+		cronJob, err := CronJobFromAnnotations(nil)
+		if err != nil {
+			t.Fatalf("failed to create CronJob from annotations: %v", err)
+		}
+		if _, err := NewCronitorConfigParser(&cronJob, sources).IsCronJobIncluded(); err == nil {
+			t.Error("expected an error for an invalid namespace default-behavior")
+		}
+	})
+}
+
+func TestPolicyDefaultsPrecedence(t *testing.T) {
+	graceSeconds := 300
+	sources := ConfigSources{}
+	sources.PolicyDefaults = func(cronjob *v1.CronJob) policy.Defaults {
+		return policy.Defaults{
+			Env:          "policy-env",
+			Tags:         []string{"policy-tag"},
+			Notify:       []string{"policy-notify"},
+			Group:        "policy-group",
+			GraceSeconds: &graceSeconds,
+			Assertions:   []string{"metric.duration < 5 min"},
+			NamePrefix:   "none",
+			KeyInference: "name",
+		}
+	}
+	sources.NamespaceAnnotations = func(namespace string) (map[string]string, bool) {
+		return map[string]string{"k8s.cronitor.io/group": "namespace-group"}, true
+	}
+	sources.ChartDefaults = func() ChartDefaults { return ChartDefaults{Environment: "chart-env"} }
+
+	t.Run("policy fills unset settings", func(t *testing.T) {
+		cronJob, err := CronJobFromAnnotations(nil)
+		if err != nil {
+			t.Fatalf("failed to create CronJob from annotations: %v", err)
+		}
+		parser := NewCronitorConfigParser(&cronJob, sources)
+		if got := parser.GetEnvironment(); got != "policy-env" {
+			t.Errorf("GetEnvironment() = %q, want the policy env over the chart default", got)
+		}
+		if got := parser.GetTags(); len(got) != 1 || got[0] != "policy-tag" {
+			t.Errorf("GetTags() = %v, want [policy-tag]", got)
+		}
+		if got := parser.GetNotify(); len(got) != 1 || got[0] != "policy-notify" {
+			t.Errorf("GetNotify() = %v, want [policy-notify]", got)
+		}
+		if got := parser.GetGroup(); got != "namespace-group" {
+			t.Errorf("GetGroup() = %q, want the namespace annotation over the policy", got)
+		}
+		if got := parser.GetGraceSeconds(); got != 300 {
+			t.Errorf("GetGraceSeconds() = %d, want 300", got)
+		}
+		if got := parser.GetCronitorName(); got != "test-cronjob" {
+			t.Errorf("GetCronitorName() = %q, want %q", got, "test-cronjob")
+		}
+		if got := parser.GetCronitorID(); got != generateHashFromName("test-cronjob") {
+			t.Errorf("GetCronitorID() = %q, want the hashed name", got)
+		}
+	})
+
+	t.Run("CronJob annotations take precedence", func(t *testing.T) {
+		cronJob, err := CronJobFromAnnotations([]Annotation{
+			{Key: "k8s.cronitor.io/env", Value: "cronjob-env"},
+			{Key: "k8s.cronitor.io/grace-seconds", Value: "60"},
+			{Key: "k8s.cronitor.io/name-prefix", Value: "namespace"},
+		})
+		if err != nil {
+			t.Fatalf("failed to create CronJob from annotations: %v", err)
+		}
+		parser := NewCronitorConfigParser(&cronJob, sources)
+		if got := parser.GetEnvironment(); got != "cronjob-env" {
+			t.Errorf("GetEnvironment() = %q, want %q", got, "cronjob-env")
+		}
+		if got := parser.GetGraceSeconds(); got != 60 {
+			t.Errorf("GetGraceSeconds() = %d, want 60", got)
+		}
+		if got := parser.GetCronitorName(); got != "default/test-cronjob" {
+			t.Errorf("GetCronitorName() = %q, want %q", got, "default/test-cronjob")
+		}
+	})
+}
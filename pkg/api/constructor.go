@@ -2,11 +2,16 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log/slog"
 	"net/http"
-	"os"
+	"sync"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
 )
 
 // Temporarily borrowed from https://github.com/cronitorio/cronitor-cli/blob/a5e2b681c89ff8fd5803551206d7ce9674122bd1/lib/cronitor.go#L44
@@ -15,6 +20,21 @@ type CronitorApi struct {
 	ApiKey         string
 	IsAutoDiscover bool
 	UserAgent      string
+	// TelemetryKey is sent in ping URLs in place of ApiKey, which is then only used to manage
+	// monitors and ship logs. Without one, pings fall back to ApiKey.
+	TelemetryKey string
+	// KeyFile, if set, supplies the API key in place of ApiKey, and may rotate it at any time
+	KeyFile *KeyFile
+	// Recorder, if set, records the requests that DryRun keeps from being sent
+	Recorder *DryRunRecorder
+	// Config supplies the agent settings the API client depends on (e.g. hostname override, log shipping).
+	// It may be nil, in which case the defaults are used.
+	Config *config.Store
+	// ConfigSources supplies the defaults of the settings CronJobs don't set themselves
+	ConfigSources pkg.ConfigSources
+
+	// uploads tracks the log uploads running in the background; copies of the client share it
+	uploads *sync.WaitGroup
 }
 
 type CronitorApiError struct {
@@ -53,11 +73,52 @@ func (c *CronitorApiError) Unwrap() error {
 	return c.Err
 }
 
-func NewCronitorApi(apikey string, dryRun bool) CronitorApi {
+// IsRejectedApiKey reports whether Cronitor refused a request because of the API key
+// itself, rather than what was sent with it.
+func IsRejectedApiKey(err error) bool {
+	var apiErr CronitorApiError
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		status := apiErr.Response.StatusCode
+		return status == http.StatusUnauthorized || status == http.StatusForbidden
+	}
+	return false
+}
+
+func NewCronitorApi(cfg *config.Store) CronitorApi {
+	current := cfg.Get()
 	return CronitorApi{
-		DryRun:         dryRun,
-		UserAgent:      fmt.Sprintf("cronitor-kubernetes/%s", os.Getenv("APP_VERSION")),
-		ApiKey:         apikey,
+		DryRun:         current.DryRun,
+		UserAgent:      fmt.Sprintf("cronitor-kubernetes/%s", current.Version),
+		ApiKey:         current.ApiKey,
+		TelemetryKey:   current.TelemetryKey,
 		IsAutoDiscover: true,
+		Config:         cfg,
+		ConfigSources: pkg.ConfigSources{
+			ChartDefaults: func() pkg.ChartDefaults { return cfg.Get().ChartDefaults() },
+		},
+		uploads: &sync.WaitGroup{},
+	}
+}
+
+// apiKey returns the API key to authenticate with.
+func (api CronitorApi) apiKey() string {
+	if key := api.KeyFile.Get(); key != "" {
+		return key
 	}
+	return api.ApiKey
+}
+
+// HasApiKey reports whether the client has an API key to authenticate with.
+func (api CronitorApi) HasApiKey() bool {
+	return api.apiKey() != ""
+}
+
+// configParser returns the parser of cronjob's Cronitor settings.
+func (api CronitorApi) configParser(cronjob *v1.CronJob) pkg.CronitorConfigParser {
+	return pkg.NewCronitorConfigParser(cronjob, api.ConfigSources)
+}
+
+// config returns the current agent configuration.
+func (api CronitorApi) config() *config.Config {
+	return api.Config.Get()
 }
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDryRunMaxSize is the size, in megabytes, at which a dry-run output file is rotated.
+const DefaultDryRunMaxSize = 100
+
+// dryRunBackups is how many rotated dry-run output files are kept.
+const dryRunBackups = 3
+
+// Types of DryRunRecord
+const (
+	DryRunMonitors  = "monitors"
+	DryRunPing      = "ping"
+	DryRunLogUpload = "log_upload"
+)
+
+// DryRunRecord is a request the agent would have sent to Cronitor, had it not been in dry-run mode.
+type DryRunRecord struct {
+	Time   time.Time       `json:"time"`
+	Type   string          `json:"type"`
+	Method string          `json:"method"`
+	URL    string          `json:"url"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	// Logs is the content of a log upload
+	Logs string `json:"logs,omitempty"`
+	// Diff compares the monitors of a monitors request with those currently in Cronitor
+	Diff []MonitorDiff `json:"diff,omitempty"`
+}
+
+// MonitorDiff compares a monitor the agent would send with the one currently in Cronitor.
+type MonitorDiff struct {
+	Key string `json:"key"`
+	// Status is "new", "changed", "unchanged", or "unknown" if the monitor couldn't be fetched
+	Status  string                 `json:"status"`
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+	Error   string                 `json:"error,omitempty"`
+}
+
+// FieldChange is a monitor field whose value would change.
+type FieldChange struct {
+	Current  interface{} `json:"current"`
+	Proposed interface{} `json:"proposed"`
+}
+
+// DryRunRecorder writes the requests the agent would have sent as JSON lines.
+type DryRunRecorder struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewDryRunRecorder(out io.Writer) *DryRunRecorder {
+	return &DryRunRecorder{encoder: json.NewEncoder(out)}
+}
+
+// Record writes record. A nil *DryRunRecorder discards it.
+func (r *DryRunRecorder) Record(record DryRunRecord) {
+	if r == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(record); err != nil {
+		slog.Error("could not record dry-run request", "type", record.Type, "error", err)
+	}
+}
+
+// OpenDryRunOutput opens where dry-run records are written: stdout for "" or "-", otherwise
+// the file at path, rotated once it reaches maxSize bytes.
+func OpenDryRunOutput(path string, maxSize int64) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	file := &rotatingFile{path: path, maxSize: maxSize, backups: dryRunBackups}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// rotatingFile appends to a file, renaming it to path.1 (and older files to path.2, ...)
+// once it reaches maxSize bytes and starting a new one.
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	backups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open dry-run output: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open dry-run output: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	for i := f.backups - 1; i > 0; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return fmt.Errorf("could not rotate dry-run output: %w", err)
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// redactKey replaces key in a URL, so that records can be shared.
+func redactKey(rawUrl string, key string, placeholder string) string {
+	if key == "" {
+		return rawUrl
+	}
+	return strings.ReplaceAll(rawUrl, key, placeholder)
+}
+
+// diffMonitors fetches each monitor from Cronitor and compares the fields the agent would send.
+func (api CronitorApi) diffMonitors(ctx context.Context, monitors []CronitorJob) []MonitorDiff {
+	diffs := make([]MonitorDiff, 0, len(monitors))
+	for _, monitor := range monitors {
+		diffs = append(diffs, api.diffMonitor(ctx, monitor))
+	}
+	return diffs
+}
+
+func (api CronitorApi) diffMonitor(ctx context.Context, monitor CronitorJob) MonitorDiff {
+	diff := MonitorDiff{Key: monitor.Key}
+	body, err := api.sendHttpRequest(ctx, "GET", api.monitorUrl()+"/"+url.PathEscape(monitor.Key), "")
+	if err != nil {
+		var apiErr CronitorApiError
+		if errors.As(err, &apiErr) && apiErr.Response != nil && apiErr.Response.StatusCode == http.StatusNotFound {
+			diff.Status = "new"
+			return diff
+		}
+		diff.Status, diff.Error = "unknown", err.Error()
+		return diff
+	}
+
+	var current map[string]interface{}
+	if err := json.Unmarshal(body, &current); err != nil {
+		diff.Status, diff.Error = "unknown", fmt.Sprintf("unexpected monitor from Cronitor: %s", err)
+		return diff
+	}
+	// Round-trip the proposed monitor so that both sides have the same JSON types
+	var proposed map[string]interface{}
+	encoded, _ := json.Marshal(monitor)
+	_ = json.Unmarshal(encoded, &proposed)
+
+	// Fields the agent doesn't send are left as they are by Cronitor
+	for field, value := range proposed {
+		if field == "key" || reflect.DeepEqual(current[field], value) {
+			continue
+		}
+		if diff.Changes == nil {
+			diff.Changes = make(map[string]FieldChange)
+		}
+		diff.Changes[field] = FieldChange{Current: current[field], Proposed: value}
+	}
+	diff.Status = "unchanged"
+	if len(diff.Changes) > 0 {
+		diff.Status = "changed"
+	}
+	return diff
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func readDryRunRecords(t *testing.T, out *bytes.Buffer) []DryRunRecord {
+	t.Helper()
+	var records []DryRunRecord
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record DryRunRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestDryRun_RecordsRequestsInsteadOfSending(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	cronitorApi := NewCronitorApi(config.NewStore(&config.Config{
+		HostnameOverride: server.URL,
+		ShipLogs:         true,
+		DryRun:           true,
+		ApiKey:           "secret-api-key",
+		TelemetryKey:     "secret-telemetry-key",
+	}))
+	cronitorApi.Recorder = NewDryRunRecorder(&out)
+
+	if _, err := cronitorApi.PutCronJobs(context.Background(), testCronJobs(2)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cronjob := &v1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "dry-job", Namespace: "default", UID: "dry-uid"}}
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{Name: "dry-job-123", Namespace: "default", UID: "dry-job-uid"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "dry-pod", Namespace: "default"}}
+	jobEvent := &pkg.JobEvent{}
+	jobEvent.Reason = "BackoffLimitExceeded"
+	if err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "error logs", pod, job, cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cronitorApi.WaitForUploads(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&requests) != 0 {
+		t.Errorf("expected no requests in dry run, got %d", requests)
+	}
+	byType := make(map[string][]DryRunRecord)
+	for _, record := range readDryRunRecords(t, &out) {
+		byType[record.Type] = append(byType[record.Type], record)
+	}
+
+	monitors := byType[DryRunMonitors]
+	if len(monitors) != 1 || monitors[0].Method != "PUT" {
+		t.Fatalf("expected one monitors PUT, got %+v", monitors)
+	}
+	var body []CronitorJob
+	if err := json.Unmarshal(monitors[0].Body, &body); err != nil || len(body) != 2 {
+		t.Errorf("expected the monitors body to hold both monitors, got %s (%v)", monitors[0].Body, err)
+	}
+
+	// The failure ping, then the logs ping after the log upload
+	pings := byType[DryRunPing]
+	if len(pings) != 2 {
+		t.Fatalf("expected two pings, got %+v", pings)
+	}
+	if !strings.Contains(pings[0].URL, "state=fail") || !strings.Contains(pings[0].URL, "series=dry-job-uid") {
+		t.Errorf("expected the ping URL to hold the encoded query, got %s", pings[0].URL)
+	}
+	if strings.Contains(pings[0].URL, "secret-telemetry-key") || !strings.Contains(pings[0].URL, "<telemetry key>") {
+		t.Errorf("expected the telemetry key to be redacted, got %s", pings[0].URL)
+	}
+
+	uploads := byType[DryRunLogUpload]
+	if len(uploads) != 1 || uploads[0].Logs != "error logs" {
+		t.Errorf("expected one log upload with the logs, got %+v", uploads)
+	}
+}
+
+func TestDryRun_DiffsMonitors(t *testing.T) {
+	cronjobs := testCronJobs(2)
+	existing := convertCronJobToCronitorJob(cronjobs[0], pkg.ConfigSources{})
+	existingKey := existing.Key
+	existing.Schedule = "0 0 * * *"
+	existingBody, _ := json.Marshal(existing)
+
+	var puts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			atomic.AddInt32(&puts, 1)
+		}
+		if strings.HasSuffix(r.URL.Path, "/"+existingKey) {
+			w.Write(existingBody)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	cronitorApi := CronitorApi{
+		DryRun:   true,
+		ApiKey:   "test-key",
+		Recorder: NewDryRunRecorder(&out),
+		Config:   config.NewStore(&config.Config{HostnameOverride: server.URL, DryRunDiff: true}),
+	}
+	if _, err := cronitorApi.PutCronJobs(context.Background(), cronjobs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&puts) != 0 {
+		t.Errorf("expected only GET requests in dry run, got %d others", puts)
+	}
+
+	records := readDryRunRecords(t, &out)
+	if len(records) != 1 || len(records[0].Diff) != 2 {
+		t.Fatalf("expected one record diffing both monitors, got %+v", records)
+	}
+	diffs := make(map[string]MonitorDiff)
+	for _, diff := range records[0].Diff {
+		diffs[diff.Key] = diff
+	}
+	changed := diffs[existingKey]
+	if changed.Status != "changed" || len(changed.Changes) != 1 || changed.Changes["schedule"].Current != "0 0 * * *" {
+		t.Errorf("expected only the schedule to change, got %+v", changed)
+	}
+	for key, diff := range diffs {
+		if key != existingKey && diff.Status != "new" {
+			t.Errorf("expected monitor %s to be new, got %+v", key, diff)
+		}
+	}
+}
+
+func TestOpenDryRunOutput_RotatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dryrun.jsonl")
+	out, err := OpenDryRunOutput(path, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer out.Close()
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 5; i++ {
+		if _, err := out.Write(line); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	// Each file holds a single 60 byte line, and only dryRunBackups rotated files are kept
+	for _, name := range []string{path, path + ".1", path + ".2", path + ".3"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+		if info.Size() != int64(len(line)) {
+			t.Errorf("expected %s to hold one line, got %d bytes", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".4"); !os.IsNotExist(err) {
+		t.Errorf("expected no more than %d rotated files", dryRunBackups)
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -63,8 +65,8 @@ func ValidateTagName(tagName string) string {
 	return name
 }
 
-func convertCronJobToCronitorJob(cronJob *v1.CronJob) CronitorJob {
-	configParser := pkg.NewCronitorConfigParser(cronJob)
+func convertCronJobToCronitorJob(cronJob *v1.CronJob, sources pkg.ConfigSources) CronitorJob {
+	configParser := pkg.NewCronitorConfigParser(cronJob, sources)
 
 	metadata := make(map[string]string)
 	if cronJob.Spec.ConcurrencyPolicy != "" {
@@ -102,6 +104,8 @@ func convertCronJobToCronitorJob(cronJob *v1.CronJob) CronitorJob {
 
 	if metricDuration := configParser.GetMetricDuration(); metricDuration != "" {
 		cronitorJob.Assertions = parseMetricDurationAssertions(metricDuration)
+	} else {
+		cronitorJob.Assertions = configParser.GetPolicyAssertions()
 	}
 
 	return cronitorJob
@@ -119,10 +123,18 @@ func parseMetricDurationAssertions(value string) []string {
 	return assertions
 }
 
-func convertCronJobsToCronitorJobs(jobs []*v1.CronJob) []CronitorJob {
+func convertCronJobsToCronitorJobs(jobs []*v1.CronJob, sources pkg.ConfigSources) []CronitorJob {
 	outputList := make([]CronitorJob, 0, len(jobs))
 	for _, job := range jobs {
-		outputList = append(outputList, convertCronJobToCronitorJob(job))
+		outputList = append(outputList, convertCronJobToCronitorJob(job, sources))
 	}
 	return outputList
 }
+
+// MonitorHash returns a hash of the monitor payload sent to Cronitor for the CronJob,
+// which changes whenever the monitor would.
+func (api CronitorApi) MonitorHash(cronJob *v1.CronJob) string {
+	payload, _ := json.Marshal(convertCronJobToCronitorJob(cronJob, api.ConfigSources))
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
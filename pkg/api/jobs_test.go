@@ -17,7 +17,7 @@ func TestNamespaceTag(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJobList.Items[0])
+	cronitorJob := convertCronJobToCronitorJob(&cronJobList.Items[0], pkg.ConfigSources{})
 
 	for _, tag := range cronitorJob.Tags {
 		if tag == "kubernetes-namespace:cronitor" {
@@ -37,7 +37,7 @@ func TestEnvironmentTag(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	for _, tag := range cronitorJob.Tags {
 		if tag == "cluster-env:staging" {
@@ -55,7 +55,7 @@ func TestTagList(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	expectedTagList := []string{"tag1", "tagname:tagvalue"}
 	for _, value := range expectedTagList {
@@ -78,7 +78,7 @@ func TestExistingCronitorID(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.Key != string(cronJob.Annotations["k8s.cronitor.io/cronitor-id"]) {
 		t.Errorf("expected cronitorJob key of `uv93823`, got `%s`", cronitorJob.Key)
@@ -93,7 +93,7 @@ func TestEmptyCronitorIDAnnotation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.Key != string(cronJob.GetUID()) {
 		t.Errorf("expected cronitorJob key of default `%s`, got `%s`", cronJob.GetUID(), cronitorJob.Key)
@@ -108,7 +108,7 @@ func TestCronitorGroupAnnotation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.Group != string(cronJob.Annotations["k8s.cronitor.io/cronitor-group"]) {
 		t.Errorf("expected cronitor-group `%s`, got `%s`", cronJob.Annotations["k8s.cronitor.io/cronitor-group"], cronitorJob.Group)
@@ -123,7 +123,7 @@ func TestCronitorNotifyAnnotation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	var expected = []string{"devops-slack", "infra-teams"}
 
@@ -140,7 +140,7 @@ func TestCronitorGraceSecondsAnnotation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.GraceSeconds != 120 {
 		t.Errorf("expected cronitor-grace-seconds `%d`, got `%d`", 120, cronitorJob.GraceSeconds)
@@ -181,7 +181,7 @@ func TestCronitorNameAnnotation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.Name != "my-custom-monitor-name" {
 		t.Errorf("expected cronitor name 'my-custom-monitor-name', got '%s'", cronitorJob.Name)
@@ -226,7 +226,7 @@ func TestMonitorNameIsNeverUUID(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error unmarshalling json: %v", err)
 			}
-			cronitorJob := convertCronJobToCronitorJob(&cronJob)
+			cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 			// The Name should never be empty
 			if cronitorJob.Name == "" {
@@ -256,7 +256,7 @@ func TestDefaultMonitorName(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	expectedName := fmt.Sprintf("%s/%s", cronJob.Namespace, cronJob.Name)
 	if cronitorJob.Name != expectedName {
@@ -277,7 +277,7 @@ func TestTimezone(t *testing.T) {
 		},
 	}
 
-	cronitorJob := convertCronJobToCronitorJob(cronJob)
+	cronitorJob := convertCronJobToCronitorJob(cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.Timezone != timezone {
 		t.Errorf("expected timezone '%s', got '%s'", timezone, cronitorJob.Timezone)
@@ -290,7 +290,7 @@ func TestTimezoneEmpty(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if cronitorJob.Timezone != "" {
 		t.Errorf("expected empty timezone when not specified, got '%s'", cronitorJob.Timezone)
@@ -311,7 +311,7 @@ func TestTimezoneInJSON(t *testing.T) {
 		},
 	}
 
-	cronitorJob := convertCronJobToCronitorJob(cronJob)
+	cronitorJob := convertCronJobToCronitorJob(cronJob, pkg.ConfigSources{})
 	jsonBytes, err := json.Marshal(cronitorJob)
 	if err != nil {
 		t.Fatalf("failed to marshal cronitorJob: %v", err)
@@ -369,7 +369,7 @@ func TestMetricDurationAnnotation(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error unmarshalling json: %v", err)
 			}
-			cronitorJob := convertCronJobToCronitorJob(&cronJob)
+			cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 			if len(cronitorJob.Assertions) != len(tc.expectedAssertions) {
 				t.Fatalf("expected %d assertions, got %d", len(tc.expectedAssertions), len(cronitorJob.Assertions))
@@ -388,7 +388,7 @@ func TestMetricDurationNoAnnotation(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 
 	if len(cronitorJob.Assertions) != 0 {
 		t.Errorf("expected no assertions when annotation is absent, got %d", len(cronitorJob.Assertions))
@@ -403,7 +403,7 @@ func TestMetricDurationInJSON(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 	jsonBytes, err := json.Marshal(cronitorJob)
 	if err != nil {
 		t.Fatalf("failed to marshal cronitorJob: %v", err)
@@ -434,7 +434,7 @@ func TestMetricDurationAssertionsOmittedWhenEmpty(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 	jsonBytes, err := json.Marshal(cronitorJob)
 	if err != nil {
 		t.Fatalf("failed to marshal cronitorJob: %v", err)
@@ -456,7 +456,7 @@ func TestTimezoneOmittedWhenEmpty(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error unmarshalling json: %v", err)
 	}
-	cronitorJob := convertCronJobToCronitorJob(&cronJob)
+	cronitorJob := convertCronJobToCronitorJob(&cronJob, pkg.ConfigSources{})
 	jsonBytes, err := json.Marshal(cronitorJob)
 	if err != nil {
 		t.Fatalf("failed to marshal cronitorJob: %v", err)
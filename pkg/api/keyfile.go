@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/metrics"
+)
+
+// KeyFile holds the API key read from a file, such as a mounted Secret, and swaps it when
+// the file changes so that the key can be rotated without restarting the agent.
+type KeyFile struct {
+	path string
+	key  atomic.Value
+}
+
+// NewKeyFile reads the API key from path.
+func NewKeyFile(path string) (*KeyFile, error) {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := &KeyFile{path: path}
+	keyFile.key.Store(key)
+	return keyFile, nil
+}
+
+func readKeyFile(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read API key file: %w", err)
+	}
+	key := string(bytes.TrimSpace(contents))
+	if key == "" {
+		return "", fmt.Errorf("API key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// Get returns the current API key. A nil *KeyFile has no key.
+func (f *KeyFile) Get() string {
+	if f == nil {
+		return ""
+	}
+	return f.key.Load().(string)
+}
+
+// Watch polls the key file until ctx is done. When it holds a new key, the key is checked
+// against Cronitor with api and swapped in if it is accepted; a rejected key is logged and
+// the current one kept. A key that can't be checked, e.g. during an outage, is checked again
+// on the next poll. Polling, rather than filesystem notifications, copes with the way
+// Kubernetes swaps the symlinks of mounted Secrets.
+func (f *KeyFile) Watch(ctx context.Context, api CronitorApi, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastRejected string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		key, err := readKeyFile(f.path)
+		if err != nil {
+			slog.Error("could not read the API key file", "path", f.path, "error", err)
+			continue
+		}
+		if key == f.Get() || key == lastRejected {
+			continue
+		}
+
+		if err := api.ValidateApiKey(ctx, key); err != nil {
+			if !IsRejectedApiKey(err) {
+				// e.g. Cronitor is unreachable or rate limiting; check the key again on the next poll
+				slog.Warn("could not check the new API key from the key file, keeping the current key for now",
+					"path", f.path,
+					"error", err)
+				continue
+			}
+			// Cronitor refused the key itself; don't retry it until it changes
+			lastRejected = key
+			metrics.ApiKeyRotations.Add("rejected", 1)
+			slog.Error("new API key from the key file was not accepted, keeping the current key",
+				"path", f.path,
+				"error", err)
+			continue
+		}
+		f.key.Store(key)
+		lastRejected = ""
+		metrics.ApiKeyRotations.Add("rotated", 1)
+		slog.Info("API key rotated from the key file", "path", f.path)
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/metrics"
+)
+
+func writeKeyFile(t *testing.T, path string, key string) {
+	t.Helper()
+	if err := os.WriteFile(path+".tmp", []byte(key+"\n"), 0o600); err != nil {
+		t.Fatalf("could not write key file: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatalf("could not replace key file: %v", err)
+	}
+}
+
+func TestKeyFile_RotatesToValidatedKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key == "bad-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "apikey")
+	writeKeyFile(t, path, "old-key")
+	keyFile, err := NewKeyFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api := CronitorApi{
+		ApiKey:    "unused",
+		UserAgent: "test-agent",
+		KeyFile:   keyFile,
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
+	}
+	if api.apiKey() != "old-key" {
+		t.Fatalf("expected the key from the file to be used, got %q", api.apiKey())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go keyFile.Watch(ctx, api, 10*time.Millisecond)
+
+	rejectedBefore := metricValue(t, "rejected")
+	writeKeyFile(t, path, "bad-key")
+	waitFor(t, func() bool { return metricValue(t, "rejected") > rejectedBefore })
+	if keyFile.Get() != "old-key" {
+		t.Fatalf("expected a rejected key to be ignored, got %q", keyFile.Get())
+	}
+
+	writeKeyFile(t, path, "new-key")
+	waitFor(t, func() bool { return keyFile.Get() == "new-key" })
+	if api.apiKey() != "new-key" {
+		t.Errorf("expected the running client to use the rotated key, got %q", api.apiKey())
+	}
+}
+
+func TestKeyFile_RetriesKeyCronitorCouldNotCheck(t *testing.T) {
+	var checks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key == "new-key" && checks.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "apikey")
+	writeKeyFile(t, path, "old-key")
+	keyFile, err := NewKeyFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	api := CronitorApi{
+		UserAgent: "test-agent",
+		KeyFile:   keyFile,
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go keyFile.Watch(ctx, api, 10*time.Millisecond)
+
+	// The key is checked again after an outage, without the file changing
+	rejectedBefore := metricValue(t, "rejected")
+	writeKeyFile(t, path, "new-key")
+	waitFor(t, func() bool { return keyFile.Get() == "new-key" })
+	if checks.Load() < 2 {
+		t.Errorf("expected the key to be checked again, got %d checks", checks.Load())
+	}
+	if metricValue(t, "rejected") != rejectedBefore {
+		t.Error("expected a key that couldn't be checked not to be counted as rejected")
+	}
+}
+
+func TestNewKeyFile_RejectsEmptyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikey")
+	writeKeyFile(t, path, "  ")
+	if _, err := NewKeyFile(path); err == nil {
+		t.Fatal("expected an error for an empty key file")
+	}
+}
+
+func metricValue(t *testing.T, outcome string) int64 {
+	t.Helper()
+	if value := metrics.ApiKeyRotations.Get(outcome); value != nil {
+		return value.(*expvar.Int).Value()
+	}
+	return 0
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the key file to be processed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
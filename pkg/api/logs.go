@@ -3,16 +3,15 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log/slog"
 	"net/http"
-	"time"
 
-	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
 	"github.com/pkg/errors"
-	"github.com/spf13/viper"
 )
 
 /*
@@ -23,7 +22,7 @@ Body: <gzipped log message>
 
 func (api CronitorApi) logPresignUrl() string {
 	url := fmt.Sprintf("%s/logs/presign", api.mainApiUrl())
-	if dev := viper.GetBool("dev"); dev {
+	if api.config().Dev {
 		url = url + "?dev=true"
 	}
 	return url
@@ -47,8 +46,8 @@ func gzipLogData(logData string) *bytes.Buffer {
 	return &b
 }
 
-func (api CronitorApi) ShipLogData(params *TelemetryEvent) ([]byte, error) {
-	cronitorID := pkg.NewCronitorConfigParser(params.CronJob).GetCronitorID()
+func (api CronitorApi) ShipLogData(ctx context.Context, params *TelemetryEvent) ([]byte, error) {
+	cronitorID := api.configParser(params.CronJob).GetCronitorID()
 	seriesID := string(*params.Series)
 	gzippedLogs := gzipLogData(params.ErrorLogs)
 
@@ -61,13 +60,20 @@ func (api CronitorApi) ShipLogData(params *TelemetryEvent) ([]byte, error) {
 	}
 
 	if api.DryRun {
+		api.Recorder.Record(DryRunRecord{
+			Type:   DryRunLogUpload,
+			Method: "POST",
+			URL:    api.logPresignUrl(),
+			Body:   jsonBytes,
+			Logs:   params.ErrorLogs,
+		})
 		return nil, nil
 	}
 
 	var responseJson struct {
 		Url string `json:"url"`
 	}
-	response, err := api.sendHttpPost(api.logPresignUrl(), string(jsonBytes))
+	response, err := api.sendHttpPost(ctx, api.logPresignUrl(), string(jsonBytes))
 	if err != nil {
 		return nil, errors.Wrap(err, "error generating presign url for log uploading")
 	}
@@ -79,7 +85,7 @@ func (api CronitorApi) ShipLogData(params *TelemetryEvent) ([]byte, error) {
 		return nil, errors.New("no presigned S3 url returned. Something is wrong")
 	}
 
-	req, err := http.NewRequest("PUT", s3LogPutUrl, gzippedLogs)
+	req, err := http.NewRequestWithContext(ctx, "PUT", s3LogPutUrl, gzippedLogs)
 	// In order to add **any** type of headers, this also needs to be adjusted in the
 	//req.Header.Add("Content-Type", "text/plain")
 	//req.Header.Add("Content-Encoding", "gzip")
@@ -87,14 +93,7 @@ func (api CronitorApi) ShipLogData(params *TelemetryEvent) ([]byte, error) {
 		return nil, err
 	}
 
-	if api.DryRun {
-		return nil, nil
-	}
-
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	response2, err := client.Do(req)
+	response2, err := transport.Client().Do(req)
 	if err != nil || response2 == nil {
 		return nil, CronitorApiError{
 			Err:      err,
@@ -2,24 +2,25 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log/slog"
 	"net/http"
+	"sort"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/cronitorio/cronitor-cli/lib"
-	"github.com/spf13/viper"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
 	v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func (api CronitorApi) mainApiUrl() string {
-	if hostnameOverride := viper.GetString("hostname-override"); hostnameOverride != "" {
-		return fmt.Sprintf("%s/api", hostnameOverride)
-	}
-	return "https://cronitor.io/api"
+	return fmt.Sprintf("%s/api", api.config().Hostname("https://cronitor.io"))
 }
 
 func (api CronitorApi) monitorUrl() string {
@@ -27,11 +28,175 @@ func (api CronitorApi) monitorUrl() string {
 	return fmt.Sprintf("%s/monitors", api.mainApiUrl())
 }
 
-func (api CronitorApi) PutCronJob(cronJob *v1.CronJob) ([]*lib.Monitor, error) {
-	return api.PutCronJobs([]*v1.CronJob{cronJob})
+const (
+	// DefaultSyncChunkSize and DefaultSyncConcurrency are used when the config doesn't set
+	// how many monitors to send per request, and how many requests to send at once.
+	DefaultSyncChunkSize   = 100
+	DefaultSyncConcurrency = 4
+)
+
+// MonitorError is Cronitor's rejection of a single monitor within a batch.
+type MonitorError struct {
+	Key     string
+	Message string
+}
+
+func (e MonitorError) Error() string {
+	return fmt.Sprintf("monitor %s rejected by Cronitor: %s", e.Key, e.Message)
 }
 
-func (api CronitorApi) PutCronJobs(cronJobs []*v1.CronJob) ([]*lib.Monitor, error) {
+// SyncError reports the CronJobs whose monitors could not be synced by PutCronJobs.
+// The monitors of every other CronJob were synced.
+type SyncError struct {
+	Failed map[types.UID]error
+}
+
+func (e SyncError) Error() string {
+	messages := make([]string, 0, len(e.Failed))
+	for uid, err := range e.Failed {
+		messages = append(messages, fmt.Sprintf("%s: %s", uid, err))
+	}
+	sort.Strings(messages)
+	return fmt.Sprintf("%d monitor(s) could not be synced: %s", len(e.Failed), strings.Join(messages, "; "))
+}
+
+func (e SyncError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (api CronitorApi) PutCronJob(ctx context.Context, cronJob *v1.CronJob) ([]*lib.Monitor, error) {
+	monitors, err := api.PutCronJobs(ctx, []*v1.CronJob{cronJob})
+	var syncErr SyncError
+	if errors.As(err, &syncErr) {
+		return monitors, syncErr.Failed[cronJob.GetUID()]
+	}
+	return monitors, err
+}
+
+// PutCronJobs syncs the monitors of the given CronJobs, in chunks sent in parallel.
+// If some monitors could not be synced, it returns the rest along with a SyncError.
+func (api CronitorApi) PutCronJobs(ctx context.Context, cronJobs []*v1.CronJob) ([]*lib.Monitor, error) {
+	chunkSize := api.config().SyncChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultSyncChunkSize
+	}
+	concurrency := api.config().SyncConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultSyncConcurrency
+	}
+
+	var chunks [][]*v1.CronJob
+	for start := 0; start < len(cronJobs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(cronJobs) {
+			end = len(cronJobs)
+		}
+		chunks = append(chunks, cronJobs[start:end])
+	}
+
+	type chunkResult struct {
+		monitors []*lib.Monitor
+		failed   map[types.UID]error
+	}
+	results := make([]chunkResult, len(chunks))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk []*v1.CronJob) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i].monitors, results[i].failed = api.putCronJobChunk(ctx, chunk)
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	monitors := make([]*lib.Monitor, 0)
+	failed := make(map[types.UID]error)
+	for _, result := range results {
+		monitors = append(monitors, result.monitors...)
+		for uid, err := range result.failed {
+			failed[uid] = err
+		}
+	}
+	if len(failed) > 0 {
+		return monitors, SyncError{Failed: failed}
+	}
+	return monitors, nil
+}
+
+// maxChunkAttempts bounds how many times a chunk is sent, each time without the monitors
+// Cronitor rejected the time before.
+const maxChunkAttempts = 3
+
+// putCronJobChunk sends one chunk of monitors. If Cronitor rejects some of them, the chunk
+// is sent again without those, so that one invalid monitor doesn't hold back the others. A chunk
+// Cronitor rejects without naming the monitors at fault, or still rejects after maxChunkAttempts,
+// is split in halves that are sent on their own, so that only the monitors Cronitor rejects by
+// themselves fail as invalid.
+func (api CronitorApi) putCronJobChunk(ctx context.Context, cronJobs []*v1.CronJob) ([]*lib.Monitor, map[types.UID]error) {
+	failed := make(map[types.UID]error)
+	for attempt := 1; ; attempt++ {
+		monitors, rejected, err := api.putMonitors(ctx, cronJobs)
+		if err == nil {
+			return monitors, failed
+		}
+		var remaining []*v1.CronJob
+		for i, cronjob := range cronJobs {
+			if rejection, ok := rejected[i]; ok {
+				failed[cronjob.GetUID()] = rejection
+			} else {
+				remaining = append(remaining, cronjob)
+			}
+		}
+		if len(remaining) == 0 {
+			return nil, failed
+		}
+		if len(rejected) > 0 && attempt < maxChunkAttempts {
+			cronJobs = remaining
+			continue
+		}
+
+		// Other errors, e.g. an outage or a rejected API key, are the same for every monitor
+		if !isBadRequest(err) {
+			for _, cronjob := range remaining {
+				failed[cronjob.GetUID()] = err
+			}
+			return nil, failed
+		}
+		if len(rejected) == 0 && len(remaining) == 1 {
+			failed[remaining[0].GetUID()] = err
+			return nil, failed
+		}
+		half := (len(remaining) + 1) / 2
+		for _, part := range [][]*v1.CronJob{remaining[:half], remaining[half:]} {
+			if len(part) == 0 {
+				continue
+			}
+			partMonitors, partFailed := api.putCronJobChunk(ctx, part)
+			monitors = append(monitors, partMonitors...)
+			for uid, partErr := range partFailed {
+				failed[uid] = partErr
+			}
+		}
+		return monitors, failed
+	}
+}
+
+// isBadRequest reports whether Cronitor answered a request with 400 Bad Request.
+func isBadRequest(err error) bool {
+	var apiErr CronitorApiError
+	return errors.As(err, &apiErr) && apiErr.Response != nil && apiErr.Response.StatusCode == http.StatusBadRequest
+}
+
+// putMonitors sends a single request. When Cronitor rejects individual monitors, it returns
+// their errors keyed by the monitor's position in cronJobs.
+func (api CronitorApi) putMonitors(ctx context.Context, cronJobs []*v1.CronJob) ([]*lib.Monitor, map[int]error, error) {
 	// Some of this borrowed from https://github.com/cronitorio/cronitor-cli/blob/a5e2b681c89ff8fd5803551206d7ce9674122bd1/lib/cronitor.go
 	url := api.monitorUrl()
 	if api.IsAutoDiscover {
@@ -40,12 +205,12 @@ func (api CronitorApi) PutCronJobs(cronJobs []*v1.CronJob) ([]*lib.Monitor, erro
 
 	monitorsArray := make([]CronitorJob, 0)
 	for _, cronjob := range cronJobs {
-		monitorsArray = append(monitorsArray, convertCronJobToCronitorJob(cronjob))
+		monitorsArray = append(monitorsArray, convertCronJobToCronitorJob(cronjob, api.ConfigSources))
 	}
 
 	jsonBytes, err := json.Marshal(monitorsArray)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	slog.Debug("sending request",
@@ -53,38 +218,99 @@ func (api CronitorApi) PutCronJobs(cronJobs []*v1.CronJob) ([]*lib.Monitor, erro
 		"body", string(jsonBytes))
 
 	if api.DryRun {
-		return make([]*lib.Monitor, 0), nil
+		record := DryRunRecord{Type: DryRunMonitors, Method: "PUT", URL: url, Body: jsonBytes}
+		if api.config().DryRunDiff {
+			record.Diff = api.diffMonitors(ctx, monitorsArray)
+		}
+		api.Recorder.Record(record)
+		return make([]*lib.Monitor, 0), nil, nil
 	}
 
-	response, err := api.sendHttpPut(url, string(jsonBytes))
+	response, err := api.sendHttpPut(ctx, url, string(jsonBytes))
 	if err != nil {
-		return nil, err
+		var apiErr CronitorApiError
+		if errors.As(err, &apiErr) && apiErr.Response != nil && apiErr.Response.StatusCode == http.StatusBadRequest {
+			if body, readErr := apiErr.ResponseBody(); readErr == nil {
+				if rejected := parseMonitorErrors(body, monitorsArray); len(rejected) > 0 {
+					return nil, rejected, err
+				}
+			}
+		}
+		return nil, nil, err
 	}
 
 	slog.Debug("received response", "response", string(response))
 
 	var responseMonitors []*lib.Monitor
 	if err = json.Unmarshal(response, &responseMonitors); err != nil {
-		return nil, fmt.Errorf("error from %s: %s, error: %s", url, response, err.Error())
+		return nil, nil, fmt.Errorf("error from %s: %s, error: %s", url, response, err.Error())
+	}
+
+	return responseMonitors, nil, nil
+}
+
+// parseMonitorErrors reads the per-monitor errors from the body of a rejected batch, keyed by
+// the monitor's position in the request. Cronitor reports them either as an array matching the
+// request (empty entries for valid monitors), or under "errors" as such an array or as an object
+// keyed by monitor key. It returns nil if the body doesn't single out any monitor.
+func parseMonitorErrors(body []byte, monitors []CronitorJob) map[int]error {
+	var wrapped struct {
+		Errors json.RawMessage `json:"errors"`
+	}
+	if json.Unmarshal(body, &wrapped) == nil && len(wrapped.Errors) > 0 {
+		body = wrapped.Errors
 	}
 
-	return responseMonitors, nil
+	rejected := make(map[int]error)
+	var byPosition []json.RawMessage
+	var byKey map[string]json.RawMessage
+	if json.Unmarshal(body, &byPosition) == nil {
+		if len(byPosition) != len(monitors) {
+			return nil
+		}
+		for i, entry := range byPosition {
+			if message := monitorErrorMessage(entry); message != "" {
+				rejected[i] = MonitorError{Key: monitors[i].Key, Message: message}
+			}
+		}
+	} else if json.Unmarshal(body, &byKey) == nil {
+		for i, monitor := range monitors {
+			if message := monitorErrorMessage(byKey[monitor.Key]); message != "" {
+				rejected[i] = MonitorError{Key: monitor.Key, Message: message}
+			}
+		}
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	return rejected
 }
 
-func (api CronitorApi) sendHttpRequest(method string, url string, body string) ([]byte, error) {
-	client := &http.Client{
-		Timeout: 120 * time.Second,
+// monitorErrorMessage returns the error reported for one monitor, or "" if there is none.
+func monitorErrorMessage(entry json.RawMessage) string {
+	trimmed := strings.TrimSpace(string(entry))
+	switch trimmed {
+	case "", "null", "{}", "[]", `""`:
+		return ""
+	}
+	var message string
+	if json.Unmarshal(entry, &message) == nil {
+		return message
 	}
-	request, err := http.NewRequest(method, url, strings.NewReader(body))
+	return trimmed
+}
+
+func (api CronitorApi) sendHttpRequest(ctx context.Context, method string, url string, body string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
-	request.SetBasicAuth(api.ApiKey, "")
+	request.SetBasicAuth(api.apiKey(), "")
 	request.Header.Add("Content-Type", "application/json")
 	request.Header.Add("User-Agent", api.UserAgent)
 	request.Header.Add("Cronitor-Version", "2020-10-27")
 
-	response, err := client.Do(request)
+	response, err := transport.Client().Do(request)
 	if err != nil {
 		return nil, CronitorApiError{err, response}
 	}
@@ -105,10 +331,23 @@ func (api CronitorApi) sendHttpRequest(method string, url string, body string) (
 	return contents, nil
 }
 
-func (api CronitorApi) sendHttpPost(url string, body string) ([]byte, error) {
-	return api.sendHttpRequest("POST", url, body)
+// ValidateApiKey checks that Cronitor accepts key, with a request that lists a single page
+// of monitors.
+func (api CronitorApi) ValidateApiKey(ctx context.Context, key string) error {
+	if api.DryRun {
+		return nil
+	}
+	candidate := api
+	candidate.ApiKey = key
+	candidate.KeyFile = nil
+	_, err := candidate.sendHttpRequest(ctx, "GET", api.monitorUrl()+"?page=1", "")
+	return err
+}
+
+func (api CronitorApi) sendHttpPost(ctx context.Context, url string, body string) ([]byte, error) {
+	return api.sendHttpRequest(ctx, "POST", url, body)
 }
 
-func (api CronitorApi) sendHttpPut(url string, body string) ([]byte, error) {
-	return api.sendHttpRequest("PUT", url, body)
+func (api CronitorApi) sendHttpPut(ctx context.Context, url string, body string) ([]byte, error) {
+	return api.sendHttpRequest(ctx, "PUT", url, body)
 }
@@ -1,15 +1,23 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
-	"github.com/spf13/viper"
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
 	v1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func TestPutCronJobs_Success(t *testing.T) {
@@ -25,11 +33,8 @@ func TestPutCronJobs_Success(t *testing.T) {
 	}))
 	defer server.Close()
 
-	// Use viper to override the hostname so PutCronJobs uses our mock server
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "test-api-key",
 		UserAgent: "test-agent",
 	}
@@ -47,7 +52,7 @@ func TestPutCronJobs_Success(t *testing.T) {
 		},
 	}
 
-	_, err := api.PutCronJobs(cronJobs)
+	_, err := api.PutCronJobs(context.Background(), cronJobs)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -66,7 +71,7 @@ func TestPutCronJobs_Returns401Error(t *testing.T) {
 	}
 
 	// Test sendHttpRequest directly with the test server URL
-	_, err := api.sendHttpRequest("PUT", server.URL, `[]`)
+	_, err := api.sendHttpRequest(context.Background(), "PUT", server.URL, `[]`)
 	if err == nil {
 		t.Fatal("expected error for 401 response, got nil")
 	}
@@ -94,7 +99,7 @@ func TestPutCronJobs_Returns400Error(t *testing.T) {
 		UserAgent: "test-agent",
 	}
 
-	_, err := api.sendHttpRequest("PUT", server.URL, `[]`)
+	_, err := api.sendHttpRequest(context.Background(), "PUT", server.URL, `[]`)
 	if err == nil {
 		t.Fatal("expected error for 400 response, got nil")
 	}
@@ -121,7 +126,7 @@ func TestPutCronJobs_Returns403Error(t *testing.T) {
 		UserAgent: "test-agent",
 	}
 
-	_, err := api.sendHttpRequest("PUT", server.URL, `[]`)
+	_, err := api.sendHttpRequest(context.Background(), "PUT", server.URL, `[]`)
 	if err == nil {
 		t.Fatal("expected error for 403 response, got nil")
 	}
@@ -148,7 +153,7 @@ func TestPutCronJobs_Returns500Error(t *testing.T) {
 		UserAgent: "test-agent",
 	}
 
-	_, err := api.sendHttpRequest("PUT", server.URL, `[]`)
+	_, err := api.sendHttpRequest(context.Background(), "PUT", server.URL, `[]`)
 	if err == nil {
 		t.Fatal("expected error for 500 response, got nil")
 	}
@@ -191,7 +196,7 @@ func TestPutCronJobs_DryRunSkipsApiCall(t *testing.T) {
 		},
 	}
 
-	_, err := api.PutCronJobs(cronJobs)
+	_, err := api.PutCronJobs(context.Background(), cronJobs)
 	if err != nil {
 		t.Fatalf("expected no error in dry run mode, got %v", err)
 	}
@@ -231,7 +236,7 @@ func TestSendHttpRequest_Success(t *testing.T) {
 		UserAgent: "test-agent",
 	}
 
-	body, err := api.sendHttpRequest("PUT", server.URL, `{}`)
+	body, err := api.sendHttpRequest(context.Background(), "PUT", server.URL, `{}`)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -253,7 +258,7 @@ func TestSendHttpRequest_201IsSuccess(t *testing.T) {
 		UserAgent: "test-agent",
 	}
 
-	body, err := api.sendHttpRequest("PUT", server.URL, `{}`)
+	body, err := api.sendHttpRequest(context.Background(), "PUT", server.URL, `{}`)
 	if err != nil {
 		t.Fatalf("expected no error for 201, got %v", err)
 	}
@@ -325,14 +330,14 @@ func TestPutCronJobs_BatchesAllJobsInSingleRequest(t *testing.T) {
 	// First, manually build the request body like PutCronJobs does
 	monitorsArray := make([]CronitorJob, 0)
 	for _, cronjob := range cronJobs {
-		monitorsArray = append(monitorsArray, convertCronJobToCronitorJob(cronjob))
+		monitorsArray = append(monitorsArray, convertCronJobToCronitorJob(cronjob, pkg.ConfigSources{}))
 	}
 	jsonBytes, err := json.Marshal(monitorsArray)
 	if err != nil {
 		t.Fatalf("failed to marshal cronjobs: %v", err)
 	}
 
-	_, err = api.sendHttpRequest("PUT", server.URL, string(jsonBytes))
+	_, err = api.sendHttpRequest(context.Background(), "PUT", server.URL, string(jsonBytes))
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -376,3 +381,240 @@ func TestPutCronJobs_BatchesAllJobsInSingleRequest(t *testing.T) {
 		}
 	}
 }
+
+func testCronJobs(count int) []*v1.CronJob {
+	var cronJobs []*v1.CronJob
+	for i := 0; i < count; i++ {
+		cronJobs = append(cronJobs, &v1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("cronjob-%d", i),
+				Namespace: "default",
+				UID:       types.UID(fmt.Sprintf("uid-%d", i)),
+			},
+			Spec: v1.CronJobSpec{
+				Schedule: "*/5 * * * *",
+			},
+		})
+	}
+	return cronJobs
+}
+
+func TestPutCronJobs_SendsChunksInParallel(t *testing.T) {
+	var mu sync.Mutex
+	var chunkSizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var monitors []CronitorJob
+		json.NewDecoder(r.Body).Decode(&monitors)
+		mu.Lock()
+		chunkSizes = append(chunkSizes, len(monitors))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL, SyncChunkSize: 2, SyncConcurrency: 2}),
+		ApiKey:    "test-api-key",
+		UserAgent: "test-agent",
+	}
+
+	if _, err := api.PutCronJobs(context.Background(), testCronJobs(5)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	sort.Ints(chunkSizes)
+	if len(chunkSizes) != 3 || chunkSizes[0] != 1 || chunkSizes[2] != 2 {
+		t.Errorf("expected chunks of 2, 2 and 1 monitors, got %v", chunkSizes)
+	}
+}
+
+func TestPutCronJobs_RetriesChunkWithoutRejectedMonitors(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]CronitorJob
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var monitors []CronitorJob
+		json.NewDecoder(r.Body).Decode(&monitors)
+		mu.Lock()
+		requests = append(requests, monitors)
+		mu.Unlock()
+
+		// Reject the monitor of cronjob-1, reporting errors in the same order as the request
+		errs := make([]interface{}, len(monitors))
+		rejected := false
+		for i, monitor := range monitors {
+			if strings.HasSuffix(monitor.Name, "cronjob-1") {
+				errs[i] = map[string][]string{"schedule": {"is invalid"}}
+				rejected = true
+			} else {
+				errs[i] = map[string]interface{}{}
+			}
+		}
+		if rejected {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
+		ApiKey:    "test-api-key",
+		UserAgent: "test-agent",
+	}
+
+	_, err := api.PutCronJobs(context.Background(), testCronJobs(3))
+	var syncErr SyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("expected a SyncError, got %v", err)
+	}
+	if len(syncErr.Failed) != 1 {
+		t.Fatalf("expected only the rejected monitor to fail, got %v", syncErr.Failed)
+	}
+	var monitorErr MonitorError
+	if !errors.As(syncErr.Failed["uid-1"], &monitorErr) || !strings.Contains(monitorErr.Message, "is invalid") {
+		t.Errorf("expected the monitor's own error, got %v", syncErr.Failed["uid-1"])
+	}
+	if len(requests) != 2 || len(requests[1]) != 2 {
+		t.Errorf("expected the chunk to be resent without the rejected monitor, got %d requests", len(requests))
+	}
+}
+
+func TestPutCronJobs_KeepsRejectionsOfEveryAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]CronitorJob
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var monitors []CronitorJob
+		json.NewDecoder(r.Body).Decode(&monitors)
+		mu.Lock()
+		requests = append(requests, monitors)
+		mu.Unlock()
+
+		// cronjob-1 is always rejected; cronjob-2 only once cronjob-1 is left out
+		errs := make([]interface{}, len(monitors))
+		rejected := false
+		for i, monitor := range monitors {
+			errs[i] = map[string]interface{}{}
+			if strings.HasSuffix(monitor.Name, "cronjob-1") || strings.HasSuffix(monitor.Name, "cronjob-2") && len(monitors) == 2 {
+				errs[i] = map[string][]string{"schedule": {"is invalid"}}
+				rejected = true
+			}
+		}
+		if rejected {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
+		ApiKey:    "test-api-key",
+		UserAgent: "test-agent",
+	}
+
+	_, err := api.PutCronJobs(context.Background(), testCronJobs(3))
+	var syncErr SyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("expected a SyncError, got %v", err)
+	}
+	for _, uid := range []types.UID{"uid-1", "uid-2"} {
+		var monitorErr MonitorError
+		if !errors.As(syncErr.Failed[uid], &monitorErr) {
+			t.Errorf("expected %s to fail with its own error, got %v", uid, syncErr.Failed[uid])
+		}
+	}
+	if _, ok := syncErr.Failed["uid-0"]; ok || len(requests) != 3 || len(requests[2]) != 1 {
+		t.Errorf("expected the remaining monitor to be synced on the third attempt, got %v after %d requests", syncErr.Failed, len(requests))
+	}
+}
+
+func TestPutCronJobs_SplitsChunkRejectedWithoutNamedMonitors(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var monitors []CronitorJob
+		json.NewDecoder(r.Body).Decode(&monitors)
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		// Any batch with the monitor of cronjob-2 is rejected as a whole
+		for _, monitor := range monitors {
+			if strings.HasSuffix(monitor.Name, "cronjob-2") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error": "Bad request"}`))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
+		ApiKey:    "test-api-key",
+		UserAgent: "test-agent",
+	}
+
+	_, err := api.PutCronJobs(context.Background(), testCronJobs(5))
+	var syncErr SyncError
+	if !errors.As(err, &syncErr) {
+		t.Fatalf("expected a SyncError, got %v", err)
+	}
+	if len(syncErr.Failed) != 1 || !isBadRequest(syncErr.Failed["uid-2"]) {
+		t.Errorf("expected only the monitor rejected on its own to fail, got %v", syncErr.Failed)
+	}
+	// The 5 monitors, then 3 and 2 of them, then 2 and 1 of the 3
+	if requests != 5 {
+		t.Errorf("expected the chunk to be split until the rejected monitor was found, got %d requests", requests)
+	}
+}
+
+func TestPutCronJobs_FailsChunkOnServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
+		ApiKey:    "test-api-key",
+		UserAgent: "test-agent",
+	}
+
+	_, err := api.PutCronJobs(context.Background(), testCronJobs(3))
+	var syncErr SyncError
+	if !errors.As(err, &syncErr) || len(syncErr.Failed) != 3 {
+		t.Fatalf("expected every monitor of the chunk to fail, got %v", err)
+	}
+	var apiErr CronitorApiError
+	if !errors.As(syncErr.Failed["uid-0"], &apiErr) || apiErr.Response.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the server error, got %v", syncErr.Failed["uid-0"])
+	}
+}
+
+func TestParseMonitorErrors(t *testing.T) {
+	monitors := []CronitorJob{{Key: "a"}, {Key: "b"}}
+
+	byKey := parseMonitorErrors([]byte(`{"errors": {"b": "schedule is invalid"}}`), monitors)
+	if len(byKey) != 1 || byKey[1] == nil {
+		t.Errorf("expected monitor b to be rejected, got %v", byKey)
+	}
+
+	byPosition := parseMonitorErrors([]byte(`[{"name": ["too long"]}, {}]`), monitors)
+	if len(byPosition) != 1 || byPosition[0] == nil {
+		t.Errorf("expected monitor a to be rejected, got %v", byPosition)
+	}
+
+	if generic := parseMonitorErrors([]byte(`{"error": "Bad request"}`), monitors); generic != nil {
+		t.Errorf("expected no monitor to be singled out, got %v", generic)
+	}
+}
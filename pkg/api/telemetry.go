@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log/slog"
@@ -13,9 +14,11 @@ import (
 	"unicode/utf8"
 
 	"github.com/cronitorio/cronitor-kubernetes/pkg"
-	"github.com/spf13/viper"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
 	v1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -60,42 +63,37 @@ func (t TelemetryEvent) CreateLogTelemetryEvent() *TelemetryEvent {
 	return &t
 }
 
-func TranslatePodEventReasonToTelemetryEventStatus(event *pkg.PodEvent) (*TelemetryEventStatus, error) {
-	var Event TelemetryEventStatus
-	switch reason := event.Reason; reason {
-	case "Started":
-		Event = Run
-	case "BackOff":
-		Event = Fail
-	default:
-		return nil, fmt.Errorf("unknown pod event reason \"%s\" received", reason)
+// TranslateEventToTelemetryEventStatus returns how to report an event of kind with reason and
+// message, following cronjobRules, a CronJob's own event rules, then those of cfg, or an error
+// if it isn't reported.
+func TranslateEventToTelemetryEventStatus(kind, reason, message string, cronjobRules []pkg.EventRule, cfg *config.Config) (*TelemetryEventStatus, error) {
+	state := cfg.EventState(kind, reason, message, cronjobRules)
+	if state == pkg.EventStateIgnore {
+		return nil, fmt.Errorf("%s event reason \"%s\" is ignored", strings.ToLower(kind), reason)
 	}
+	Event := TelemetryEventStatus(state)
 	return &Event, nil
 }
 
-func translateJobEventReasonToTelemetryEventStatus(event *pkg.JobEvent) (*TelemetryEventStatus, error) {
-	var Event TelemetryEventStatus
-	switch reason := event.Reason; reason {
-	case "SuccessfulCreate":
-		Event = Run
-	case "Completed":
-		Event = Complete
-	case "BackoffLimitExceeded":
-		Event = Fail
-	default:
-		return nil, fmt.Errorf("unknown job event reason \"%s\" received", reason)
-	}
-	return &Event, nil
+func TranslatePodEventReasonToTelemetryEventStatus(event *pkg.PodEvent, cronjobRules []pkg.EventRule, cfg *config.Config) (*TelemetryEventStatus, error) {
+	return TranslateEventToTelemetryEventStatus("Pod", event.Reason, event.Message, cronjobRules, cfg)
+}
+
+func TranslateJobEventReasonToTelemetryEventStatus(event *pkg.JobEvent, cronjobRules []pkg.EventRule, cfg *config.Config) (*TelemetryEventStatus, error) {
+	return TranslateEventToTelemetryEventStatus("Job", event.Reason, event.Message, cronjobRules, cfg)
 }
 
-func NewTelemetryEventFromKubernetesPodEvent(event *pkg.PodEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) (*TelemetryEvent, error) {
+func NewTelemetryEventFromKubernetesPodEvent(event *pkg.PodEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob, cfg *config.Config, sources pkg.ConfigSources) (*TelemetryEvent, error) {
 	CronJob := cronjob
 	Message := event.Message
 	ErrorLogs := logs
 	Series := job.UID
 	eventTime := event.LastTimestamp
 
-	Event, err := TranslatePodEventReasonToTelemetryEventStatus(event)
+	cronitorConfigParser := pkg.NewCronitorConfigParser(cronjob, sources)
+	// Invalid rules are reported on the CronJob when it is added or updated, and left out here
+	cronjobRules, _ := cronitorConfigParser.GetEventRules()
+	Event, err := TranslatePodEventReasonToTelemetryEventStatus(event, cronjobRules, cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -112,26 +110,33 @@ func NewTelemetryEventFromKubernetesPodEvent(event *pkg.PodEvent, logs string, p
 		Timestamp: strconv.FormatInt(eventTime.Unix(), 10),
 	}
 
-	if env := pkg.NewCronitorConfigParser(cronjob).GetEnvironment(); env != "" {
+	if env := cronitorConfigParser.GetEnvironment(); env != "" {
 		telemetryEvent.Env = env
 	}
 
 	return &telemetryEvent, nil
 }
 
-func NewTelemetryEventFromKubernetesJobEvent(event *pkg.JobEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) (*TelemetryEvent, error) {
+func NewTelemetryEventFromKubernetesJobEvent(event *pkg.JobEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob, cfg *config.Config, sources pkg.ConfigSources) (*TelemetryEvent, error) {
 	CronJob := cronjob
 	Message := event.Message
 	ErrorLogs := logs
 	Series := job.UID
 	eventTime := event.LastTimestamp
 
-	Event, err := translateJobEventReasonToTelemetryEventStatus(event)
+	cronitorConfigParser := pkg.NewCronitorConfigParser(cronjob, sources)
+	// Invalid rules are reported on the CronJob when it is added or updated, and left out here
+	cronjobRules, _ := cronitorConfigParser.GetEventRules()
+	Event, err := TranslateJobEventReasonToTelemetryEventStatus(event, cronjobRules, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	Host := pod.Spec.NodeName
+	// Jobs whose pods could not be created, or were deleted, have no pod to take the node from
+	var Host string
+	if pod != nil {
+		Host = pod.Spec.NodeName
+	}
 
 	telemetryEvent := TelemetryEvent{
 		CronJob:   CronJob,
@@ -143,8 +148,6 @@ func NewTelemetryEventFromKubernetesJobEvent(event *pkg.JobEvent, logs string, p
 		Timestamp: strconv.FormatInt(eventTime.Unix(), 10),
 	}
 
-	cronitorConfigParser := pkg.NewCronitorConfigParser(cronjob)
-
 	if env := cronitorConfigParser.GetEnvironment(); env != "" {
 		telemetryEvent.Env = env
 	}
@@ -193,28 +196,30 @@ func (t TelemetryEvent) Encode() string {
 // telemetryUrl generates the base URL for the Telemetry API.
 // The state and other parameters are passed as query params via Encode().
 func (api CronitorApi) telemetryUrl(params *TelemetryEvent) string {
-	cronitorID := pkg.NewCronitorConfigParser(params.CronJob).GetCronitorID()
-	var hostname string
-	if hostnameOverride := viper.GetString("hostname-override"); hostnameOverride != "" {
-		hostname = hostnameOverride
-	} else {
-		hostname = "https://cronitor.link"
+	cronitorID := api.configParser(params.CronJob).GetCronitorID()
+	hostname := api.config().Hostname("https://cronitor.link")
+	return fmt.Sprintf("%s/ping/%s/%s", hostname, api.telemetryKey(), cronitorID)
+}
+
+// telemetryKey returns the key to send pings with. Ping URLs end up in proxy and access
+// logs, so a dedicated telemetry key should be configured rather than the API key. Without
+// one, pings fall back to the API key, which is logged when the agent starts.
+func (api CronitorApi) telemetryKey() string {
+	if api.TelemetryKey != "" {
+		return api.TelemetryKey
 	}
-	return fmt.Sprintf("%s/ping/%s/%s", hostname, api.ApiKey, cronitorID)
+	return api.apiKey()
 }
 
-func (api CronitorApi) sendTelemetryPostRequest(params *TelemetryEvent) ([]byte, error) {
+func (api CronitorApi) sendTelemetryPostRequest(ctx context.Context, params *TelemetryEvent) ([]byte, error) {
 	telemetryUrl := api.telemetryUrl(params)
-	req, err := http.NewRequest("POST", telemetryUrl, bytes.NewBuffer([]byte{}))
+	req, err := http.NewRequestWithContext(ctx, "POST", telemetryUrl, bytes.NewBuffer([]byte{}))
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("User-Agent", api.UserAgent)
 	req.URL.RawQuery = params.Encode()
-	client := &http.Client{
-		Timeout: 120 * time.Second,
-	}
-	response, err := client.Do(req)
+	response, err := transport.Client().Do(req)
 	if err != nil {
 		return nil, CronitorApiError{
 			Err:      err,
@@ -236,12 +241,17 @@ func (api CronitorApi) sendTelemetryPostRequest(params *TelemetryEvent) ([]byte,
 	return body, nil
 }
 
-func (api CronitorApi) sendTelemetryEvent(t *TelemetryEvent) error {
+func (api CronitorApi) sendTelemetryEvent(ctx context.Context, t *TelemetryEvent) error {
 	if api.DryRun {
+		api.Recorder.Record(DryRunRecord{
+			Type:   DryRunPing,
+			Method: "POST",
+			URL:    redactKey(api.telemetryUrl(t), api.telemetryKey(), "<telemetry key>") + "?" + t.Encode(),
+		})
 		return nil
 	}
 
-	_, err := api.sendTelemetryPostRequest(t)
+	_, err := api.sendTelemetryPostRequest(ctx, t)
 	if err != nil {
 		return err
 	}
@@ -249,74 +259,133 @@ func (api CronitorApi) sendTelemetryEvent(t *TelemetryEvent) error {
 	return nil
 }
 
-func (api CronitorApi) MakeAndSendTelemetryPodEventAndLogs(event *pkg.PodEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) error {
-	telemetryEvent, err := NewTelemetryEventFromKubernetesPodEvent(event, logs, pod, job, cronjob)
-	if err != nil {
-		return err
+// shipLogsInBackground uploads the logs of a telemetry event, then sends the matching logs
+// ping, without holding up the event's own ping. The upload is tracked so that it can be
+// waited for on shutdown; ctx aborts it. objectKind and object identify the Pod or Job the
+// logs come from, for error logs.
+func (api CronitorApi) shipLogsInBackground(ctx context.Context, telemetryEvent *TelemetryEvent, objectKind string, object meta_v1.Object) {
+	if len(telemetryEvent.ErrorLogs) == 0 || !api.config().ShipLogs {
+		return
 	}
-
-	shipLogs := viper.GetBool("ship-logs")
-	go func(telemetryEvent *TelemetryEvent, pod *corev1.Pod) {
-		if len(telemetryEvent.ErrorLogs) == 0 || !shipLogs {
-			return
+	if api.uploads != nil {
+		api.uploads.Add(1)
+	}
+	go func() {
+		if api.uploads != nil {
+			defer api.uploads.Done()
 		}
-		_, err := api.ShipLogData(telemetryEvent)
+		_, err := api.ShipLogData(ctx, telemetryEvent)
 		if err != nil {
 			if strings.Contains(err.Error(), "no such host") {
 				// This error is due entirely to logs.cronitor.link not existing yet,
 				// so discard for now
 				return
 			}
-			slog.Error("unexpected error sending log data for pod",
-				"namespace", pod.Namespace,
-				"pod", pod.Name,
+			slog.Error("unexpected error sending log data for "+objectKind,
+				"namespace", object.GetNamespace(),
+				objectKind, object.GetName(),
 				"error", err)
 		}
 		logTelemetryEvent := telemetryEvent.CreateLogTelemetryEvent()
-		err = api.sendTelemetryEvent(logTelemetryEvent)
+		err = api.sendTelemetryEvent(ctx, logTelemetryEvent)
 		if err != nil {
-			slog.Error("unexpected error sending log telemetry event for pod",
-				"namespace", pod.Namespace,
-				"pod", pod.Name,
+			slog.Error("unexpected error sending log telemetry event for "+objectKind,
+				"namespace", object.GetNamespace(),
+				objectKind, object.GetName(),
 				"error", err)
 		}
-	}(telemetryEvent, pod)
+	}()
+}
 
-	return api.sendTelemetryEvent(telemetryEvent)
+// WaitForUploads blocks until the log uploads started so far have finished, or ctx is done.
+// It returns ctx's error if uploads were still in flight.
+func (api CronitorApi) WaitForUploads(ctx context.Context) error {
+	if api.uploads == nil {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		api.uploads.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (api CronitorApi) MakeAndSendTelemetryJobEventAndLogs(event *pkg.JobEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) error {
-	telemetryEvent, err := NewTelemetryEventFromKubernetesJobEvent(event, logs, pod, job, cronjob)
+func (api CronitorApi) MakeAndSendTelemetryPodEventAndLogs(ctx context.Context, event *pkg.PodEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) error {
+	telemetryEvent, err := NewTelemetryEventFromKubernetesPodEvent(event, logs, pod, job, cronjob, api.config(), api.ConfigSources)
 	if err != nil {
 		return err
 	}
 
-	shipLogs := viper.GetBool("ship-logs")
-	go func(telemetryEvent *TelemetryEvent, job *v1.Job) {
-		if len(telemetryEvent.ErrorLogs) == 0 || !shipLogs {
-			return
-		}
-		_, err := api.ShipLogData(telemetryEvent)
-		if err != nil {
-			if strings.Contains(err.Error(), "no such host") {
-				// This error is due entirely to logs.cronitor.link not existing yet,
-				// so discard for now
-				return
-			}
-			slog.Error("unexpected error sending log data for job",
-				"namespace", job.Namespace,
-				"job", job.Name,
-				"error", err)
-		}
-		logTelemetryEvent := telemetryEvent.CreateLogTelemetryEvent()
-		err = api.sendTelemetryEvent(logTelemetryEvent)
-		if err != nil {
-			slog.Error("unexpected error sending log telemetry event for pod",
-				"namespace", pod.Namespace,
-				"pod", pod.Name,
-				"error", err)
-		}
-	}(telemetryEvent, job)
+	api.shipLogsInBackground(ctx, telemetryEvent, "pod", pod)
+	return api.sendTelemetryEvent(ctx, telemetryEvent)
+}
+
+func (api CronitorApi) MakeAndSendTelemetryJobEventAndLogs(ctx context.Context, event *pkg.JobEvent, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) error {
+	telemetryEvent, err := NewTelemetryEventFromKubernetesJobEvent(event, logs, pod, job, cronjob, api.config(), api.ConfigSources)
+	if err != nil {
+		return err
+	}
+
+	api.shipLogsInBackground(ctx, telemetryEvent, "job", job)
+	return api.sendTelemetryEvent(ctx, telemetryEvent)
+}
+
+// newTelemetryEvent returns the telemetry reporting state for cronjob at timestamp, in the
+// environment the CronJob is monitored in.
+func (api CronitorApi) newTelemetryEvent(cronjob *v1.CronJob, state TelemetryEventStatus, message string, series *types.UID, timestamp time.Time) *TelemetryEvent {
+	return &TelemetryEvent{
+		CronJob:   cronjob,
+		Event:     state,
+		Message:   message,
+		Series:    series,
+		Env:       api.configParser(cronjob).GetEnvironment(),
+		Timestamp: strconv.FormatInt(timestamp.Unix(), 10),
+	}
+}
+
+// MakeAndSendTelemetryStuckPod reports the run of a Pod that was kept from starting, e.g. by an
+// image that can't be pulled, as failed with message.
+func (api CronitorApi) MakeAndSendTelemetryStuckPod(ctx context.Context, message string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) error {
+	series := job.UID
+	telemetryEvent := api.newTelemetryEvent(cronjob, Fail, message, &series, time.Now())
+	telemetryEvent.Host = pod.Spec.NodeName
+	return api.sendTelemetryEvent(ctx, telemetryEvent)
+}
+
+// MakeAndSendTelemetryMainContainersExited reports the run of a Pod whose main containers have
+// all exited, while sidecar containers keep it running, with state Complete or Fail.
+func (api CronitorApi) MakeAndSendTelemetryMainContainersExited(ctx context.Context, state TelemetryEventStatus, message string, logs string, pod *corev1.Pod, job *v1.Job, cronjob *v1.CronJob) error {
+	series := job.UID
+	telemetryEvent := api.newTelemetryEvent(cronjob, state, message, &series, time.Now())
+	telemetryEvent.ErrorLogs = logs
+	telemetryEvent.Host = pod.Spec.NodeName
+	// As for the Job's own Completed event
+	if logCompleteEvent, _ := api.configParser(cronjob).LogCompleteEvent(); logCompleteEvent && state == Complete {
+		telemetryEvent.Event = Logs
+		telemetryEvent.Message = fmt.Sprintf("Job %s is completed with status %s", job.Name, Complete)
+	}
+
+	api.shipLogsInBackground(ctx, telemetryEvent, "pod", pod)
+	return api.sendTelemetryEvent(ctx, telemetryEvent)
+}
+
+// MakeAndSendTelemetryCronJobEvent reports an event recorded on cronjob itself, such as a run
+// the CronJob controller could not start, with state Fail or Logs. Such events don't belong
+// to a Job, so they have no series.
+func (api CronitorApi) MakeAndSendTelemetryCronJobEvent(ctx context.Context, event *pkg.CronJobEvent, state TelemetryEventStatus, cronjob *v1.CronJob) error {
+	return api.sendTelemetryEvent(ctx, api.newTelemetryEvent(cronjob, state, event.Message, nil, event.LastTimestamp.Time))
+}
 
-	return api.sendTelemetryEvent(telemetryEvent)
+// MakeAndSendTelemetryMissedRun reports that the run of cronjob scheduled at scheduledTime was
+// never started, with state Fail or Logs. There is no Job to take the series from, so the
+// series is derived from the CronJob and the scheduled time instead.
+func (api CronitorApi) MakeAndSendTelemetryMissedRun(ctx context.Context, cronjob *v1.CronJob, state TelemetryEventStatus, scheduledTime time.Time, message string) error {
+	series := types.UID(fmt.Sprintf("%s-missed-%d", cronjob.UID, scheduledTime.Unix()))
+	return api.sendTelemetryEvent(ctx, api.newTelemetryEvent(cronjob, state, message, &series, time.Now()))
 }
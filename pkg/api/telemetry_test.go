@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -10,7 +11,8 @@ import (
 	"time"
 
 	"github.com/cronitorio/cronitor-kubernetes/pkg"
-	"github.com/spf13/viper"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/cronitortest"
 	v1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -82,6 +84,32 @@ func TestTelemetryUrlWithCustomCronitorID(t *testing.T) {
 	}
 }
 
+func TestTelemetryUrlUsesTelemetryKey(t *testing.T) {
+	cronjob := &v1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-cronjob",
+			Namespace: "default",
+			UID:       "test-uid-123",
+		},
+	}
+
+	api := CronitorApi{
+		ApiKey:       "test-api-key",
+		TelemetryKey: "test-telemetry-key",
+		UserAgent:    "test-agent",
+	}
+
+	telemetryUrl := api.telemetryUrl(&TelemetryEvent{CronJob: cronjob, Event: Run})
+
+	expectedUrl := "https://cronitor.link/ping/test-telemetry-key/test-uid-123"
+	if telemetryUrl != expectedUrl {
+		t.Errorf("expected URL '%s', got '%s'", expectedUrl, telemetryUrl)
+	}
+	if strings.Contains(telemetryUrl, "test-api-key") {
+		t.Error("the API key should not be sent in ping URLs when a telemetry key is configured")
+	}
+}
+
 // TestTelemetryEncodeAllParams verifies that ALL telemetry parameters are correctly encoded
 func TestTelemetryEncodeAllParams(t *testing.T) {
 	series := types.UID("job-uid-456")
@@ -203,7 +231,7 @@ func TestTranslatePodEventReasonToTelemetryEventStatus(t *testing.T) {
 			event := &pkg.PodEvent{}
 			event.Reason = tc.reason
 
-			status, err := TranslatePodEventReasonToTelemetryEventStatus(event)
+			status, err := TranslatePodEventReasonToTelemetryEventStatus(event, nil, nil)
 
 			if tc.expectError {
 				if err == nil {
@@ -227,6 +255,7 @@ func TestTranslateJobEventReasonToTelemetryEventStatus(t *testing.T) {
 	tests := []struct {
 		name           string
 		reason         string
+		cfg            *config.Config
 		expectedStatus TelemetryEventStatus
 		expectError    bool
 	}{
@@ -245,11 +274,49 @@ func TestTranslateJobEventReasonToTelemetryEventStatus(t *testing.T) {
 			reason:         "BackoffLimitExceeded",
 			expectedStatus: Fail,
 		},
+		{
+			name:           "DeadlineExceeded maps to fail",
+			reason:         "DeadlineExceeded",
+			expectedStatus: Fail,
+		},
+		{
+			name:           "FailedCreate maps to fail",
+			reason:         "FailedCreate",
+			expectedStatus: Fail,
+		},
+		{
+			name:           "PodFailurePolicy maps to fail",
+			reason:         "PodFailurePolicy",
+			expectedStatus: Fail,
+		},
+		{
+			name:           "Suspended maps to logs",
+			reason:         "Suspended",
+			expectedStatus: Logs,
+		},
 		{
 			name:        "Unknown reason returns error",
 			reason:      "SomeUnknownReason",
 			expectError: true,
 		},
+		{
+			name:           "Configured reason is added",
+			reason:         "SuccessCriteriaMet",
+			cfg:            &config.Config{EventRules: []pkg.EventRule{{Kind: "Job", Reason: "SuccessCriteriaMet", State: "logs"}}},
+			expectedStatus: Logs,
+		},
+		{
+			name:           "Configured reason overrides the default",
+			reason:         "Suspended",
+			cfg:            &config.Config{EventRules: []pkg.EventRule{{Kind: "Job", Reason: "Suspended", State: "fail"}}},
+			expectedStatus: Fail,
+		},
+		{
+			name:        "Ignored reason returns error",
+			reason:      "Resumed",
+			cfg:         &config.Config{EventRules: []pkg.EventRule{{Kind: "Job", Reason: "Resumed", State: "ignore"}}},
+			expectError: true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -257,7 +324,7 @@ func TestTranslateJobEventReasonToTelemetryEventStatus(t *testing.T) {
 			event := &pkg.JobEvent{}
 			event.Reason = tc.reason
 
-			status, err := translateJobEventReasonToTelemetryEventStatus(event)
+			status, err := TranslateJobEventReasonToTelemetryEventStatus(event, nil, tc.cfg)
 
 			if tc.expectError {
 				if err == nil {
@@ -277,6 +344,56 @@ func TestTranslateJobEventReasonToTelemetryEventStatus(t *testing.T) {
 	}
 }
 
+func TestTranslateEventToTelemetryEventStatus_EventRules(t *testing.T) {
+	cronjob := &v1.CronJob{ObjectMeta: metav1.ObjectMeta{
+		Name:      "test-cronjob",
+		Namespace: "default",
+		Annotations: map[string]string{
+			"k8s.cronitor.io/event-rules": `[{"kind": "Pod", "reason": "BackOff", "message": "pulling image", "state": "logs"}]`,
+		},
+	}}
+	cronjobRules, err := pkg.NewCronitorConfigParser(cronjob, pkg.ConfigSources{}).GetEventRules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg := &config.Config{EventRules: []pkg.EventRule{
+		{Kind: "Pod", Reason: "BackOff", State: "ignore"},
+		{Reason: "Evicted", State: "fail"},
+	}}
+	tests := []struct {
+		name           string
+		kind           string
+		reason         string
+		message        string
+		cronjobRules   []pkg.EventRule
+		expectedStatus TelemetryEventStatus
+		expectError    bool
+	}{
+		{"annotation rule matches", "Pod", "BackOff", `Back-off pulling image "app:missing"`, cronjobRules, Logs, false},
+		{"annotation rule doesn't match the message", "Pod", "BackOff", "Back-off restarting failed container", cronjobRules, "", true},
+		{"annotation rules only apply when given", "Pod", "BackOff", `Back-off pulling image "app:missing"`, nil, "", true},
+		{"rule without a kind matches every kind", "Job", "Evicted", "", nil, Fail, false},
+		{"defaults still apply", "Pod", "Started", "", cronjobRules, Run, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			status, err := TranslateEventToTelemetryEventStatus(tc.kind, tc.reason, tc.message, tc.cronjobRules, cfg)
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("expected error but got status '%s'", *status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *status != tc.expectedStatus {
+				t.Errorf("expected status '%s', got '%s'", tc.expectedStatus, *status)
+			}
+		})
+	}
+}
+
 func TestTelemetryEventIncludesEnvironmentFromAnnotation(t *testing.T) {
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
@@ -311,7 +428,7 @@ func TestTelemetryEventIncludesEnvironmentFromAnnotation(t *testing.T) {
 	jobEvent.Reason = "Completed"
 	jobEvent.Message = "Job completed successfully"
 
-	telemetryEvent, err := NewTelemetryEventFromKubernetesJobEvent(jobEvent, "", pod, job, cronjob)
+	telemetryEvent, err := NewTelemetryEventFromKubernetesJobEvent(jobEvent, "", pod, job, cronjob, nil, pkg.ConfigSources{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -357,7 +474,7 @@ func TestTelemetryEventWithoutEnvironmentAnnotation(t *testing.T) {
 	jobEvent := &pkg.JobEvent{}
 	jobEvent.Reason = "Completed"
 
-	telemetryEvent, err := NewTelemetryEventFromKubernetesJobEvent(jobEvent, "", pod, job, cronjob)
+	telemetryEvent, err := NewTelemetryEventFromKubernetesJobEvent(jobEvent, "", pod, job, cronjob, nil, pkg.ConfigSources{})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -385,9 +502,6 @@ func TestSendTelemetryRequestVerifiesAllParams(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test-cronjob",
@@ -411,11 +525,12 @@ func TestSendTelemetryRequestVerifiesAllParams(t *testing.T) {
 	}
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "test-api-key",
 		UserAgent: "cronitor-kubernetes/test",
 	}
 
-	_, err := api.sendTelemetryPostRequest(telemetryEvent)
+	_, err := api.sendTelemetryPostRequest(context.Background(), telemetryEvent)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -473,9 +588,6 @@ func TestSendTelemetryRequestMinimalParams(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test-cronjob",
@@ -491,11 +603,12 @@ func TestSendTelemetryRequestMinimalParams(t *testing.T) {
 	}
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "test-api-key",
 		UserAgent: "test-agent",
 	}
 
-	_, err := api.sendTelemetryPostRequest(telemetryEvent)
+	_, err := api.sendTelemetryPostRequest(context.Background(), telemetryEvent)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -523,9 +636,6 @@ func TestTelemetryFailureReturnsError(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test-cronjob",
@@ -540,11 +650,12 @@ func TestTelemetryFailureReturnsError(t *testing.T) {
 	}
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "invalid-key",
 		UserAgent: "test-agent",
 	}
 
-	_, err := api.sendTelemetryPostRequest(telemetryEvent)
+	_, err := api.sendTelemetryPostRequest(context.Background(), telemetryEvent)
 	if err == nil {
 		t.Error("expected error for 401 response, got nil")
 	}
@@ -568,9 +679,6 @@ func TestTelemetryDryRunSkipsRequest(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "test-cronjob",
@@ -585,12 +693,13 @@ func TestTelemetryDryRunSkipsRequest(t *testing.T) {
 	}
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "test-api-key",
 		UserAgent: "test-agent",
 		DryRun:    true,
 	}
 
-	err := api.sendTelemetryEvent(telemetryEvent)
+	err := api.sendTelemetryEvent(context.Background(), telemetryEvent)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -617,9 +726,6 @@ func TestMakeAndSendTelemetryJobEvent_CompletedJob(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "my-scheduled-job",
@@ -654,11 +760,12 @@ func TestMakeAndSendTelemetryJobEvent_CompletedJob(t *testing.T) {
 	jobEvent.Message = "Job completed successfully"
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "my-api-key",
 		UserAgent: "cronitor-kubernetes/test",
 	}
 
-	err := api.MakeAndSendTelemetryJobEventAndLogs(jobEvent, "", pod, job, cronjob)
+	err := api.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "", pod, job, cronjob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -710,9 +817,6 @@ func TestMakeAndSendTelemetryJobEvent_WithCustomCronitorID(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "my-scheduled-job",
@@ -746,11 +850,12 @@ func TestMakeAndSendTelemetryJobEvent_WithCustomCronitorID(t *testing.T) {
 	jobEvent.Reason = "Completed"
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "my-api-key",
 		UserAgent: "cronitor-kubernetes/test",
 	}
 
-	err := api.MakeAndSendTelemetryJobEventAndLogs(jobEvent, "", pod, job, cronjob)
+	err := api.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "", pod, job, cronjob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -772,9 +877,6 @@ func TestMakeAndSendTelemetryJobEvent_FailedJob(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "failing-job",
@@ -806,11 +908,12 @@ func TestMakeAndSendTelemetryJobEvent_FailedJob(t *testing.T) {
 	jobEvent.Message = "Job has reached the specified backoff limit"
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "test-key",
 		UserAgent: "test-agent",
 	}
 
-	err := api.MakeAndSendTelemetryJobEventAndLogs(jobEvent, "", pod, job, cronjob)
+	err := api.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "", pod, job, cronjob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -830,9 +933,6 @@ func TestMakeAndSendTelemetryPodEvent_Started(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "scheduled-task",
@@ -868,11 +968,12 @@ func TestMakeAndSendTelemetryPodEvent_Started(t *testing.T) {
 	podEvent.Message = "Started container"
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "pod-test-key",
 		UserAgent: "cronitor-kubernetes/test",
 	}
 
-	err := api.MakeAndSendTelemetryPodEventAndLogs(podEvent, "", pod, job, cronjob)
+	err := api.MakeAndSendTelemetryPodEventAndLogs(context.Background(), podEvent, "", pod, job, cronjob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -913,9 +1014,6 @@ func TestMakeAndSendTelemetryPodEvent_BackOff(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	defer viper.Set("hostname-override", "")
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "flaky-job",
@@ -947,11 +1045,12 @@ func TestMakeAndSendTelemetryPodEvent_BackOff(t *testing.T) {
 	podEvent.Message = "Back-off restarting failed container"
 
 	api := CronitorApi{
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 		ApiKey:    "test-key",
 		UserAgent: "test-agent",
 	}
 
-	err := api.MakeAndSendTelemetryPodEventAndLogs(podEvent, "", pod, job, cronjob)
+	err := api.MakeAndSendTelemetryPodEventAndLogs(context.Background(), podEvent, "", pod, job, cronjob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -965,9 +1064,6 @@ func TestMakeAndSendTelemetryPodEvent_BackOff(t *testing.T) {
 // and verifies the expected Cronitor API endpoints
 func TestTelemetryURLVerification(t *testing.T) {
 	t.Run("default telemetry URL is cronitor.link", func(t *testing.T) {
-		// Clear any override
-		viper.Set("hostname-override", "")
-
 		cronjob := &v1.CronJob{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "test-job",
@@ -995,8 +1091,6 @@ func TestTelemetryURLVerification(t *testing.T) {
 	})
 
 	t.Run("hostname-override redirects telemetry", func(t *testing.T) {
-		viper.Set("hostname-override", "http://mock-server.local")
-		defer viper.Set("hostname-override", "")
 
 		cronjob := &v1.CronJob{
 			ObjectMeta: metav1.ObjectMeta{
@@ -1007,6 +1101,7 @@ func TestTelemetryURLVerification(t *testing.T) {
 		}
 
 		api := CronitorApi{
+			Config: config.NewStore(&config.Config{HostnameOverride: "http://mock-server.local"}),
 			ApiKey: "my-api-key",
 		}
 
@@ -1064,9 +1159,6 @@ func TestMakeAndSendTelemetryJobEventAndLogs_AsyncLogShipping(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	viper.Set("ship-logs", true)
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "async-test-job", Namespace: "default", UID: "async-uid",
@@ -1086,10 +1178,10 @@ func TestMakeAndSendTelemetryJobEventAndLogs_AsyncLogShipping(t *testing.T) {
 	jobEvent.Reason = "Completed"
 	jobEvent.Message = "Job completed"
 
-	cronitorApi := CronitorApi{ApiKey: "test-key", UserAgent: "test-agent"}
+	cronitorApi := CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: config.NewStore(&config.Config{HostnameOverride: server.URL, ShipLogs: true})}
 
 	start := time.Now()
-	err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(jobEvent, "some error logs here", pod, job, cronjob)
+	err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "some error logs here", pod, job, cronjob)
 	elapsed := time.Since(start)
 
 	if err != nil {
@@ -1101,17 +1193,13 @@ func TestMakeAndSendTelemetryJobEventAndLogs_AsyncLogShipping(t *testing.T) {
 		t.Errorf("MakeAndSendTelemetryJobEventAndLogs took %v, expected <100ms (async log shipping)", elapsed)
 	}
 
-	// Wait for the async goroutine to fully complete before cleaning up viper
+	// Wait for the async goroutine to fully complete
 	select {
 	case <-goroutineDone:
 		// good — goroutine finished all work
 	case <-time.After(3 * time.Second):
 		t.Error("async log shipping goroutine did not complete within timeout")
 	}
-
-	// Clean up viper AFTER goroutine is done to avoid races
-	viper.Set("hostname-override", "")
-	viper.Set("ship-logs", false)
 }
 
 // TestMakeAndSendTelemetryPodEventAndLogs_AsyncLogShipping verifies async behavior for pod events.
@@ -1140,9 +1228,6 @@ func TestMakeAndSendTelemetryPodEventAndLogs_AsyncLogShipping(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	viper.Set("ship-logs", true)
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "async-pod-test", Namespace: "default", UID: "async-pod-uid",
@@ -1162,10 +1247,10 @@ func TestMakeAndSendTelemetryPodEventAndLogs_AsyncLogShipping(t *testing.T) {
 	podEvent.Reason = "Started"
 	podEvent.Message = "Container started"
 
-	cronitorApi := CronitorApi{ApiKey: "test-key", UserAgent: "test-agent"}
+	cronitorApi := CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: config.NewStore(&config.Config{HostnameOverride: server.URL, ShipLogs: true})}
 
 	start := time.Now()
-	err := cronitorApi.MakeAndSendTelemetryPodEventAndLogs(podEvent, "some pod logs here", pod, job, cronjob)
+	err := cronitorApi.MakeAndSendTelemetryPodEventAndLogs(context.Background(), podEvent, "some pod logs here", pod, job, cronjob)
 	elapsed := time.Since(start)
 
 	if err != nil {
@@ -1182,8 +1267,6 @@ func TestMakeAndSendTelemetryPodEventAndLogs_AsyncLogShipping(t *testing.T) {
 		t.Error("async log shipping goroutine did not complete within timeout")
 	}
 
-	viper.Set("hostname-override", "")
-	viper.Set("ship-logs", false)
 }
 
 // TestMakeAndSendTelemetry_NoLogs_SkipsLogShipping verifies that when there are no logs,
@@ -1203,13 +1286,6 @@ func TestMakeAndSendTelemetry_NoLogs_SkipsLogShipping(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-	viper.Set("ship-logs", true)
-	defer func() {
-		viper.Set("hostname-override", "")
-		viper.Set("ship-logs", false)
-	}()
-
 	cronjob := &v1.CronJob{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: "no-logs-job", Namespace: "default", UID: "no-logs-uid",
@@ -1228,10 +1304,10 @@ func TestMakeAndSendTelemetry_NoLogs_SkipsLogShipping(t *testing.T) {
 	jobEvent := &pkg.JobEvent{}
 	jobEvent.Reason = "Completed"
 
-	cronitorApi := CronitorApi{ApiKey: "test-key", UserAgent: "test-agent"}
+	cronitorApi := CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: config.NewStore(&config.Config{HostnameOverride: server.URL, ShipLogs: true})}
 
 	// Empty logs — should NOT trigger log shipping
-	err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(jobEvent, "", pod, job, cronjob)
+	err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "", pod, job, cronjob)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -1249,3 +1325,75 @@ func TestMakeAndSendTelemetry_NoLogs_SkipsLogShipping(t *testing.T) {
 		t.Errorf("expected 0 log presign requests, got %d", presigns)
 	}
 }
+
+// TestWaitForUploads_WaitsForLogShipping verifies that WaitForUploads returns once the
+// background log upload and its logs ping have been sent.
+func TestWaitForUploads_WaitsForLogShipping(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	server.Fail(cronitortest.LogPresignEndpoint, cronitortest.Latency(100*time.Millisecond))
+
+	cronjob := &v1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "drain-job", Namespace: "default", UID: "drain-uid"}}
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{Name: "drain-job-123", Namespace: "default", UID: "drain-job-uid"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "drain-pod", Namespace: "default"}}
+	jobEvent := &pkg.JobEvent{}
+	jobEvent.Reason = "BackoffLimitExceeded"
+
+	cronitorApi := NewCronitorApi(config.NewStore(&config.Config{HostnameOverride: server.URL, ShipLogs: true, ApiKey: "test-key"}))
+	if err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(context.Background(), jobEvent, "error logs", pod, job, cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := cronitorApi.WaitForUploads(ctx); err != nil {
+		t.Fatalf("expected the upload to finish, got %v", err)
+	}
+	server.ExpectPings(t, "drain-uid", "fail", "logs")
+	if uploads := server.LogUploads(); len(uploads) != 1 || uploads[0].Logs != "error logs" {
+		t.Errorf("expected the logs to be uploaded before WaitForUploads returned, got %+v", uploads)
+	}
+}
+
+// TestWaitForUploads_Deadline verifies that WaitForUploads gives up once its context is done,
+// and that cancelling the context of the upload aborts it.
+func TestWaitForUploads_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/logs/presign") {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	cronjob := &v1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "stuck-job", Namespace: "default", UID: "stuck-uid"}}
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{Name: "stuck-job-123", Namespace: "default", UID: "stuck-job-uid"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "stuck-pod", Namespace: "default"}}
+	jobEvent := &pkg.JobEvent{}
+	jobEvent.Reason = "BackoffLimitExceeded"
+
+	cronitorApi := NewCronitorApi(config.NewStore(&config.Config{HostnameOverride: server.URL, ShipLogs: true, ApiKey: "test-key"}))
+	requestCtx, abort := context.WithCancel(context.Background())
+	defer abort()
+	if err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(requestCtx, jobEvent, "error logs", pod, job, cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := cronitorApi.WaitForUploads(ctx); err == nil {
+		t.Fatal("expected WaitForUploads to give up while the upload is stuck")
+	}
+
+	abort()
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := cronitorApi.WaitForUploads(ctx); err != nil {
+		t.Errorf("expected the aborted upload to finish, got %v", err)
+	}
+}
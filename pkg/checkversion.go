@@ -4,11 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log/slog"
-	"net/http"
 	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
 	"github.com/ghodss/yaml"
 )
 
@@ -32,7 +32,7 @@ type chart struct {
 }
 
 func getChartYaml() ([]byte, error) {
-	resp, err := http.Get(chartRepositoryUrl)
+	resp, err := transport.Client().Get(chartRepositoryUrl)
 	if err != nil {
 		return nil, err
 	}
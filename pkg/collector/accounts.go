@@ -0,0 +1,244 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// account is a separate Cronitor account that the CronJobs of some namespaces belong to.
+type account struct {
+	name       string
+	namespaces []namespacePattern
+	selector   labels.Selector
+
+	secretNamespace string
+	secretName      string
+	apiKeyKey       string
+	telemetryKeyKey string
+
+	mu sync.RWMutex
+	// api is the client authenticated with the account's keys, once they have been read,
+	// and loadErr the reason they couldn't be
+	api     *api.CronitorApi
+	loadErr error
+}
+
+func (a *account) matches(namespace string, namespaceLabels map[string]string) bool {
+	for _, pattern := range a.namespaces {
+		if pattern.Matches(namespace) {
+			return true
+		}
+	}
+	return a.selector != nil && !a.selector.Empty() && a.selector.Matches(labels.Set(namespaceLabels))
+}
+
+// accountRouter picks the Cronitor account, and so the API client, that each CronJob is
+// synced and reported with, by its namespace. Namespaces no account claims use the
+// agent's own API key.
+type accountRouter struct {
+	defaultApi *api.CronitorApi
+	accounts   []*account
+	scope      *NamespaceScope
+}
+
+func newAccountRouter(accounts []config.Account, defaultApi *api.CronitorApi, scope *NamespaceScope) (*accountRouter, error) {
+	if len(accounts) == 0 {
+		return nil, nil
+	}
+	router := &accountRouter{defaultApi: defaultApi, scope: scope}
+	for _, cfg := range accounts {
+		secretNamespace, secretName, _ := strings.Cut(cfg.Secret, "/")
+		acct := &account{
+			name:            cfg.Name,
+			secretNamespace: secretNamespace,
+			secretName:      secretName,
+			apiKeyKey:       cfg.ApiKeyKey,
+			telemetryKeyKey: cfg.TelemetryKeyKey,
+		}
+		for _, namespace := range cfg.Namespaces {
+			pattern, err := newNamespacePattern(namespace)
+			if err != nil {
+				return nil, fmt.Errorf("account %q: %w", cfg.Name, err)
+			}
+			acct.namespaces = append(acct.namespaces, pattern)
+		}
+		if cfg.NamespaceSelector != "" {
+			selector, err := labels.Parse(cfg.NamespaceSelector)
+			if err != nil {
+				return nil, fmt.Errorf("account %q has an invalid namespace selector %q: %w", cfg.Name, cfg.NamespaceSelector, err)
+			}
+			acct.selector = selector
+		}
+		router.accounts = append(router.accounts, acct)
+	}
+	return router, nil
+}
+
+// loadKeys reads each account's keys from its Secret when the agent starts; the Secrets are
+// then watched for changes (see newAccountSecretInformer). An account whose keys can't be
+// read is logged, and its CronJobs are reported as they are synced.
+func (r *accountRouter) loadKeys(ctx context.Context, clientset kubernetes.Interface) {
+	if r == nil {
+		return
+	}
+	for _, acct := range r.accounts {
+		secret, err := clientset.CoreV1().Secrets(acct.secretNamespace).Get(ctx, acct.secretName, meta_v1.GetOptions{})
+		r.setKeys(acct, secret, err)
+	}
+}
+
+// setKeys sets an account's keys from its Secret, or, if readErr isn't nil, records that the
+// Secret couldn't be read. It reports whether the account's keys changed.
+func (r *accountRouter) setKeys(acct *account, secret *corev1.Secret, readErr error) bool {
+	var cronitorApi *api.CronitorApi
+	err := readErr
+	if err == nil {
+		cronitorApi, err = acct.keysFromSecret(secret, r.defaultApi)
+	}
+	acct.mu.Lock()
+	previous := acct.api
+	acct.api, acct.loadErr = cronitorApi, err
+	acct.mu.Unlock()
+
+	if err != nil {
+		slog.Error("could not read the keys of a Cronitor account",
+			"account", acct.name,
+			"secret", acct.secretNamespace+"/"+acct.secretName,
+			"error", err)
+		return previous != nil
+	}
+	if previous != nil && previous.ApiKey == cronitorApi.ApiKey && previous.TelemetryKey == cronitorApi.TelemetryKey {
+		return false
+	}
+	slog.Info("loaded Cronitor account", "account", acct.name)
+	if cronitorApi.TelemetryKey == "" {
+		slog.Warn("no telemetry key for Cronitor account, sending its pings with its API key",
+			"account", acct.name,
+			"secret", acct.secretNamespace+"/"+acct.secretName,
+			"key", acct.telemetryKeyKey)
+	}
+	return true
+}
+
+func (a *account) keysFromSecret(secret *corev1.Secret, defaultApi *api.CronitorApi) (*api.CronitorApi, error) {
+	apiKey := strings.TrimSpace(string(secret.Data[a.apiKeyKey]))
+	if apiKey == "" {
+		return nil, fmt.Errorf("secret %s/%s has no %s key", a.secretNamespace, a.secretName, a.apiKeyKey)
+	}
+	cronitorApi := *defaultApi
+	cronitorApi.ApiKey = apiKey
+	cronitorApi.TelemetryKey = strings.TrimSpace(string(secret.Data[a.telemetryKeyKey]))
+	cronitorApi.KeyFile = nil
+	return &cronitorApi, nil
+}
+
+// newAccountSecretInformer watches the Secret holding an account's keys, so that rotated keys
+// are used without restarting the agent, and the CronJobs of an account whose Secret couldn't
+// be read are synced once it can.
+func newAccountSecretInformer(watcher *CronJobWatcher, acct *account) cache.SharedIndexInformer {
+	coll := watcher.collection
+	factory := informers.NewSharedInformerFactoryWithOptions(coll.clientset, 0,
+		informers.WithNamespace(acct.secretNamespace),
+		informers.WithTweakListOptions(func(options *meta_v1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", acct.secretName).String()
+		}))
+	informer := factory.Core().V1().Secrets().Informer()
+
+	onSecret := func(obj interface{}) {
+		secret, ok := obj.(*corev1.Secret)
+		if !ok || secret.Name != acct.secretName {
+			return
+		}
+		if coll.accounts.setKeys(acct, secret, nil) {
+			watcher.resyncAccount(acct.name)
+		}
+	}
+	// A deleted Secret leaves the keys read from it in use
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onSecret,
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			onSecret(newObj)
+		},
+	})
+	return informer
+}
+
+// resyncAccount re-evaluates the CronJobs synced with an account whose keys changed.
+func (c *CronJobWatcher) resyncAccount(name string) {
+	slog.Info("cronitor account keys changed, resyncing cronjobs", "account", name)
+	for _, cronjob := range c.cachedCronJobs(meta_v1.NamespaceAll) {
+		if !c.collection.namespaceScope.IsNamespaceIncluded(cronjob.Namespace) {
+			continue
+		}
+		if account, _, _ := c.collection.resolveAccount(cronjob.Namespace); account == name {
+			c.collection.resyncCronJob(cronjob)
+		}
+	}
+}
+
+// resolve returns the account that the CronJobs of a namespace belong to ("" for the agent's
+// own) and its API client, or a NoAccountError if the account has no usable key.
+func (r *accountRouter) resolve(namespace string) (string, *api.CronitorApi, error) {
+	var namespaceLabels map[string]string
+	if r.scope != nil {
+		namespaceLabels, _ = r.scope.NamespaceLabels(namespace)
+	}
+	for _, acct := range r.accounts {
+		if !acct.matches(namespace, namespaceLabels) {
+			continue
+		}
+		acct.mu.RLock()
+		cronitorApi, loadErr := acct.api, acct.loadErr
+		acct.mu.RUnlock()
+		if cronitorApi == nil {
+			if loadErr == nil {
+				loadErr = errors.New("its keys have not been loaded")
+			}
+			return acct.name, nil, NoAccountError{Namespace: namespace, Account: acct.name, Err: loadErr}
+		}
+		return acct.name, cronitorApi, nil
+	}
+	if r.defaultApi == nil || !r.defaultApi.HasApiKey() {
+		return "", nil, NoAccountError{Namespace: namespace}
+	}
+	return "", r.defaultApi, nil
+}
+
+// resolveAccount returns the account a CronJob in namespace is synced and reported with
+// ("" for the agent's own) and its API client.
+// The client is given the collection's defaults for the settings CronJobs don't set themselves.
+func (coll *CronJobCollection) resolveAccount(namespace string) (string, *api.CronitorApi, error) {
+	name, cronitorApi := "", coll.cronitorApi
+	if coll.accounts != nil {
+		var err error
+		if name, cronitorApi, err = coll.accounts.resolve(namespace); err != nil {
+			return name, nil, err
+		}
+	}
+	if cronitorApi == nil {
+		return name, nil, nil
+	}
+	withSources := *cronitorApi
+	withSources.ConfigSources = coll.configSources()
+	return name, &withSources, nil
+}
+
+// apiFor returns the API client to sync and report the CronJobs of a namespace with.
+func (coll *CronJobCollection) apiFor(namespace string) (*api.CronitorApi, error) {
+	_, cronitorApi, err := coll.resolveAccount(namespace)
+	return cronitorApi, err
+}
@@ -0,0 +1,249 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// accountsServer records the monitors received under each API key, and rejects the keys in rejectedKeys.
+type accountsServer struct {
+	server       *httptest.Server
+	mu           sync.Mutex
+	monitors     map[string][]string
+	rejectedKeys map[string]bool
+}
+
+func newAccountsServer(rejectedKeys ...string) *accountsServer {
+	s := &accountsServer{monitors: make(map[string][]string), rejectedKeys: make(map[string]bool)}
+	for _, key := range rejectedKeys {
+		s.rejectedKeys[key] = true
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _, _ := r.BasicAuth()
+		if s.rejectedKeys[key] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var monitors []api.CronitorJob
+		json.NewDecoder(r.Body).Decode(&monitors)
+		s.mu.Lock()
+		for _, monitor := range monitors {
+			s.monitors[key] = append(s.monitors[key], monitor.Name)
+		}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("[]"))
+	}))
+	return s
+}
+
+func (s *accountsServer) monitorsFor(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.monitors[key]
+}
+
+func accountSecret(namespace string, name string, apiKey string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Data:       map[string][]byte{config.DefaultAccountApiKeyKey: []byte(apiKey)},
+	}
+}
+
+// createAccountsCollection creates a collection that syncs to server, with the given default API key and accounts.
+func createAccountsCollection(t *testing.T, server *accountsServer, defaultKey string, accounts []config.Account, objects ...*corev1.Secret) *CronJobCollection {
+	t.Helper()
+	cfg := config.NewStore(&config.Config{HostnameOverride: server.server.URL})
+	cronitorApi := &api.CronitorApi{ApiKey: defaultKey, UserAgent: "test-agent", Config: cfg}
+	scope, _ := NewNamespaceScope(nil, nil, "")
+	scope.setNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "billing", Labels: map[string]string{"team": "b"}}})
+
+	for i := range accounts {
+		accounts[i].ApiKeyKey = config.DefaultAccountApiKeyKey
+		accounts[i].TelemetryKeyKey = config.DefaultAccountTelemetryKeyKey
+	}
+	router, err := newAccountRouter(accounts, cronitorApi, scope)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	clientset := fake.NewSimpleClientset()
+	for _, secret := range objects {
+		clientset.CoreV1().Secrets(secret.Namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+	}
+	router.loadKeys(context.Background(), clientset)
+
+	return &CronJobCollection{
+		clientset:      clientset,
+		cronitorApi:    cronitorApi,
+		accounts:       router,
+		namespaceScope: scope,
+		cronjobs:       make(map[types.UID]*v1.CronJob),
+		config:         cfg,
+	}
+}
+
+func TestSyncPending_GroupsMonitorsByAccount(t *testing.T) {
+	server := newAccountsServer()
+	defer server.server.Close()
+
+	coll := createAccountsCollection(t, server, "default-key", []config.Account{
+		{Name: "team-a", Namespaces: []string{"team-a*"}, Secret: "cronitor/team-a"},
+		{Name: "team-b", NamespaceSelector: "team=b", Secret: "cronitor/team-b"},
+		{Name: "team-c", Namespaces: []string{"team-c"}, Secret: "cronitor/missing"},
+	},
+		accountSecret("cronitor", "team-a", "team-a-key"),
+		accountSecret("cronitor", "team-b", "team-b-key"),
+	)
+	coll.markPendingSync(
+		createTestCronJob("job-a", "team-a-prod", "uid-a", "*/5 * * * *"),
+		createTestCronJob("job-b", "billing", "uid-b", "*/5 * * * *"),
+		createTestCronJob("job-c", "team-c", "uid-c", "*/5 * * * *"),
+		createTestCronJob("job-d", "default", "uid-d", "*/5 * * * *"),
+	)
+
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for key, expected := range map[string]string{"team-a-key": "job-a", "team-b-key": "job-b", "default-key": "job-d"} {
+		monitors := server.monitorsFor(key)
+		if len(monitors) != 1 || !strings.HasSuffix(monitors[0], expected) {
+			t.Errorf("expected %s to be synced with %s, got %v", expected, key, monitors)
+		}
+	}
+	if coll.IsTracked("uid-c") || coll.IsPendingSync("uid-c") {
+		t.Error("expected the CronJob of an account without keys to be neither tracked nor retried")
+	}
+
+	cronitorApi, err := coll.apiFor("team-a-prod")
+	if err != nil || cronitorApi.ApiKey != "team-a-key" {
+		t.Errorf("expected telemetry for team-a-prod to use the team-a account, got %v", err)
+	}
+}
+
+func TestSyncPending_ResyncsMonitorsMovedToAnotherAccount(t *testing.T) {
+	server := newAccountsServer()
+	defer server.server.Close()
+
+	accounts := []config.Account{{Name: "team-b", NamespaceSelector: "team=b", Secret: "cronitor/team-b"}}
+	coll := createAccountsCollection(t, server, "default-key", accounts, accountSecret("cronitor", "team-b", "team-b-key"))
+	coll.syncState, _ = newSyncState(nil, "", coll.monitorHash)
+	cronjob := createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *")
+	coll.markPendingSync(cronjob)
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The namespace is labeled into the team-b account
+	coll.namespaceScope.setNamespace(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "b"}}})
+	if coll.syncState.unchanged(cronjob) {
+		t.Fatal("expected the monitor to need syncing to its new account")
+	}
+	coll.resyncCronJob(cronjob)
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if monitors := server.monitorsFor("team-b-key"); len(monitors) != 1 {
+		t.Errorf("expected the monitor to be created in the new account, got %v", monitors)
+	}
+}
+
+func TestAccountSecretInformer_SyncsOnceKeysCanBeRead(t *testing.T) {
+	server := newAccountsServer()
+	defer server.server.Close()
+
+	coll := createAccountsCollection(t, server, "default-key", []config.Account{
+		{Name: "team-a", Namespaces: []string{"team-a"}, Secret: "cronitor/team-a"},
+	})
+	cronjob := createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *")
+	informer, err := newCronJobInformer(coll, metav1.NamespaceAll, "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	informer.GetIndexer().Add(cronjob)
+	watcher := &CronJobWatcher{
+		informers:  map[string]cache.SharedIndexInformer{metav1.NamespaceAll: informer},
+		version:    "v1",
+		collection: coll,
+	}
+	onAdd(coll, cronjob)
+	if coll.IsTracked("uid-a") {
+		t.Fatal("expected the CronJob not to be synced without its account's keys")
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go newAccountSecretInformer(watcher, coll.accounts.accounts[0]).Run(stop)
+	secret := accountSecret("cronitor", "team-a", "team-a-key")
+	if _, err := coll.clientset.CoreV1().Secrets("cronitor").Create(context.Background(), secret, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !coll.IsTracked("uid-a") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if monitors := server.monitorsFor("team-a-key"); len(monitors) != 1 {
+		t.Errorf("expected the CronJob to be synced with the account's new key, got %v", monitors)
+	}
+}
+
+func TestAccountRouter_NamespaceWithoutKey(t *testing.T) {
+	server := newAccountsServer()
+	defer server.server.Close()
+
+	coll := createAccountsCollection(t, server, "", []config.Account{
+		{Name: "team-a", Namespaces: []string{"team-a"}, Secret: "cronitor/team-a"},
+	}, accountSecret("cronitor", "team-a", "team-a-key"))
+
+	_, err := coll.apiFor("default")
+	var noAccountErr NoAccountError
+	if !errors.As(err, &noAccountErr) || noAccountErr.Namespace != "default" {
+		t.Fatalf("expected a NoAccountError for a namespace without a key, got %v", err)
+	}
+	if ClassifyError(err) != ErrorClassConfig {
+		t.Error("expected a namespace without a key to be a configuration error")
+	}
+	if !strings.Contains(err.Error(), "default") {
+		t.Errorf("expected the error to name the namespace, got %q", err)
+	}
+}
+
+func TestSyncPending_RejectedAccountKeyDoesNotStopOthers(t *testing.T) {
+	server := newAccountsServer("team-a-key")
+	defer server.server.Close()
+
+	coll := createAccountsCollection(t, server, "default-key", []config.Account{
+		{Name: "team-a", Namespaces: []string{"team-a"}, Secret: "cronitor/team-a"},
+	}, accountSecret("cronitor", "team-a", "team-a-key"))
+	coll.markPendingSync(
+		createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *"),
+		createTestCronJob("job-d", "default", "uid-d", "*/5 * * * *"),
+	)
+
+	err := coll.syncPending(context.Background())
+	if err != nil || api.IsRejectedApiKey(err) {
+		t.Fatalf("expected an account's rejected key not to fail the sync, got %v", err)
+	}
+	if !coll.IsTracked("uid-d") {
+		t.Error("expected the CronJob of the agent's own account to be synced")
+	}
+	if coll.IsTracked("uid-a") || coll.IsPendingSync("uid-a") {
+		t.Error("expected the CronJob of the account with a rejected key to be dropped")
+	}
+}
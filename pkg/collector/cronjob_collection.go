@@ -8,59 +8,251 @@ import (
 
 	"github.com/cronitorio/cronitor-kubernetes/pkg"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/metrics"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/normalizer"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/policy"
 	"github.com/getsentry/sentry-go"
 	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Reasons used for the Kubernetes Warning events the agent records on CronJobs it could not handle
+const (
+	EventReasonInvalidConfiguration = "CronitorInvalidConfiguration"
+	EventReasonSyncFailed           = "CronitorSyncFailed"
 )
 
 type CronJobCollection struct {
-	clientset           kubernetes.Interface
-	serverVersion       *version.Info
-	cronitorApi         *api.CronitorApi
-	cronjobs            map[types.UID]*v1.CronJob
-	cronjobsMu          sync.RWMutex // protects cronjobs map
-	kubernetesNamespace string
-	loaded              bool
-	stopper             func()
-}
-
-func NewCronJobCollection(pathToKubeconfig string, namespace string, cronitorApi *api.CronitorApi) (*CronJobCollection, error) {
-	config, err := GetConfig(pathToKubeconfig)
+	clientset       kubernetes.Interface
+	config          *config.Store
+	serverVersion   *version.Info
+	cronitorApi     *api.CronitorApi
+	accounts        *accountRouter
+	cronjobs        map[types.UID]*v1.CronJob
+	cronjobsMu      sync.RWMutex // protects cronjobs, pendingSync and pendingTelemetry
+	namespaceScope  *NamespaceScope
+	cronjobSelector labels.Selector
+	dynamicClient   dynamic.Interface
+	policies        *policy.Store
+	policyWatches   []policyWatch
+	recorder        record.EventRecorder
+	loaded          bool
+	stopper         func(ctx context.Context)
+
+	// ctx is the context of every request the collection makes outside of LoadAllExistingCronJobs.
+	// It is cancelled once StopWatchingAll gives up waiting, aborting the requests still in flight.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// pendingSync holds included CronJobs whose monitors could not be synced yet,
+	// and pendingTelemetry the telemetry held for them until they are
+	pendingSync      map[types.UID]*v1.CronJob
+	pendingTelemetry map[types.UID][]func()
+
+	// syncQueue coalesces and batches the monitors to sync as CronJobs change
+	syncQueue *syncQueue
+
+	// syncState remembers the monitors last synced, to skip sending unchanged ones again
+	syncState *syncState
+
+	// missedRuns reports the runs CronJobs missed, while the collection is being watched
+	missedRuns *missedRunChecker
+
+	// eventRules holds the event rules of the included CronJobs
+	eventRules eventRuleStore
+}
+
+func newEventRecorder(clientset kubernetes.Interface) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "cronitor-kubernetes"})
+}
+
+// NewCronJobCollection creates a collection of the CronJobs in the given namespace scope.
+// The configured CronJob selector is an optional label selector CronJobs must match,
+// in addition to the inclusion annotations, to be monitored.
+func NewCronJobCollection(cfg *config.Store, namespaceScope *NamespaceScope, cronitorApi *api.CronitorApi) (*CronJobCollection, error) {
+	restConfig, err := GetConfig(cfg.Get().Kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	clientset := GetClientSet(restConfig)
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
-	clientset := GetClientSet(config)
-	discoveryClient := GetDiscoveryClient(config)
+	discoveryClient := GetDiscoveryClient(restConfig)
 	serverVersion, err := discoveryClient.ServerVersion()
 	if err != nil {
 		return nil, err
 	}
-	return &CronJobCollection{
-		clientset:           clientset,
-		serverVersion:       serverVersion,
-		cronitorApi:         cronitorApi,
-		kubernetesNamespace: namespace,
-		cronjobs:            make(map[types.UID]*v1.CronJob),
-		loaded:              false,
-	}, nil
+	return NewCronJobCollectionWithClients(cfg, namespaceScope, cronitorApi, clientset, dynamicClient, serverVersion)
 }
 
-func (coll *CronJobCollection) AddCronJob(cronjob *v1.CronJob) error {
-	_, err := coll.cronitorApi.PutCronJob(cronjob)
+// NewCronJobCollectionWithClients is NewCronJobCollection with the given Kubernetes clients,
+// e.g. fake ones in tests, and the version of the cluster they talk to, which decides the
+// batch API version CronJobs are watched with. dynamicClient may be nil if there are no
+// policy watches.
+func NewCronJobCollectionWithClients(cfg *config.Store, namespaceScope *NamespaceScope, cronitorApi *api.CronitorApi,
+	clientset kubernetes.Interface, dynamicClient dynamic.Interface, serverVersion *version.Info) (*CronJobCollection, error) {
+	cronjobSelector := cfg.Get().CronJobSelector
+	selector, err := labels.Parse(cronjobSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cronjob selector %q: %w", cronjobSelector, err)
+	}
+	accounts, err := newAccountRouter(cfg.Get().Accounts, cronitorApi, namespaceScope)
 	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	coll := &CronJobCollection{
+		clientset:       clientset,
+		config:          cfg,
+		serverVersion:   serverVersion,
+		cronitorApi:     cronitorApi,
+		accounts:        accounts,
+		namespaceScope:  namespaceScope,
+		cronjobSelector: selector,
+		dynamicClient:   dynamicClient,
+		policies:        policy.NewStore(),
+		recorder:        newEventRecorder(clientset),
+		cronjobs:        make(map[types.UID]*v1.CronJob),
+		syncQueue:       newSyncQueue(pendingSyncInitialBackoff, pendingSyncMaxBackoff),
+		loaded:          false,
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+	coll.syncState, err = newSyncState(clientset, cfg.Get().SyncStateConfigMap, coll.monitorHash)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return coll, nil
+}
+
+// context returns the context of the collection's requests.
+func (coll *CronJobCollection) context() context.Context {
+	if coll.ctx == nil {
+		return context.Background()
+	}
+	return coll.ctx
+}
+
+// configSources returns where CronJobs find the defaults for the settings they don't set
+// themselves: their namespace's annotations, the CronitorPolicies that match them, and the
+// agent's config. Their event rules are taken as parsed when they were added or updated.
+func (coll *CronJobCollection) configSources() pkg.ConfigSources {
+	return pkg.ConfigSources{
+		ChartDefaults:        func() pkg.ChartDefaults { return coll.config.Get().ChartDefaults() },
+		NamespaceAnnotations: coll.namespaceScope.NamespaceAnnotations,
+		PolicyDefaults:       coll.policies.Resolve,
+		EventRules:           coll.eventRules.lookup,
+	}
+}
+
+// monitorHash returns a hash of the monitor synced for cronjob, or "" if its account can't be
+// resolved. The hash names the account the monitor is synced to, so that a CronJob whose
+// namespace moves to another account has its monitor created there.
+func (coll *CronJobCollection) monitorHash(cronjob *v1.CronJob) string {
+	account, cronitorApi, err := coll.resolveAccount(cronjob.Namespace)
+	if err != nil || cronitorApi == nil {
+		return ""
+	}
+	hash := cronitorApi.MonitorHash(cronjob)
+	if account != "" {
+		hash = account + "/" + hash
+	}
+	return hash
+}
+
+// configParser returns the parser of cronjob's Cronitor settings.
+func (coll *CronJobCollection) configParser(cronjob *v1.CronJob) pkg.CronitorConfigParser {
+	return pkg.NewCronitorConfigParser(cronjob, coll.configSources())
+}
+
+// isCronJobIncluded decides whether a CronJob should be monitored, combining the CronJob
+// label selector with the inclusion/exclusion annotations.
+func (coll *CronJobCollection) isCronJobIncluded(cronjob *v1.CronJob) (bool, error) {
+	if coll.cronjobSelector != nil && !coll.cronjobSelector.Matches(labels.Set(cronjob.Labels)) {
+		return false, nil
+	}
+	return coll.configParser(cronjob).IsCronJobIncluded()
+}
+
+// cronjobListOptions pushes the CronJob label selector down to the Kubernetes API,
+// so CronJobs that don't match are never listed or watched.
+func (coll *CronJobCollection) cronjobListOptions(options *meta_v1.ListOptions) {
+	if coll.cronjobSelector != nil && !coll.cronjobSelector.Empty() {
+		options.LabelSelector = coll.cronjobSelector.String()
+	}
+}
+
+// reportCronJobError routes an error encountered while handling a single CronJob to the
+// logs, the error metric, Sentry, and a Warning event on the CronJob itself, so that one
+// misconfigured CronJob never affects the handling of any other.
+func (coll *CronJobCollection) reportCronJobError(cronjob *v1.CronJob, err error) {
+	class := ClassifyError(err)
+	reason := EventReasonSyncFailed
+	logLevel := slog.LevelError
+	if class == ErrorClassConfig {
+		reason = EventReasonInvalidConfiguration
+		logLevel = slog.LevelWarn
+	}
+
+	slog.Log(context.Background(), logLevel, "error handling cronjob",
+		"namespace", cronjob.Namespace,
+		"name", cronjob.Name,
+		"UID", cronjob.UID,
+		"errorClass", class,
+		"error", err)
+	metrics.CronJobErrors.Add(string(class), 1)
+	sentry.WithScope(func(scope *sentry.Scope) {
+		scope.SetTag("error_class", string(class))
 		sentry.CaptureException(err)
-		slog.Error("error adding cronjob to Cronitor",
+	})
+	if coll.recorder != nil {
+		coll.recorder.Event(cronjob, corev1.EventTypeWarning, reason, err.Error())
+	}
+}
+
+func (coll *CronJobCollection) AddCronJob(cronjob *v1.CronJob) error {
+	if coll.syncState.unchanged(cronjob) {
+		coll.cronjobsMu.Lock()
+		coll.removePendingSyncLocked(cronjob.GetUID())
+		coll.cronjobs[cronjob.GetUID()] = cronjob
+		coll.cronjobsMu.Unlock()
+		slog.Debug("cronjob's monitor unchanged, not sent to Cronitor",
 			"namespace", cronjob.Namespace,
 			"name", cronjob.Name,
-			"UID", cronjob.UID,
-			"error", err)
+			"UID", cronjob.UID)
+		return nil
+	}
+
+	cronitorApi, err := coll.apiFor(cronjob.Namespace)
+	if err == nil {
+		_, err = cronitorApi.PutCronJob(coll.context(), cronjob)
+	}
+	if err != nil {
+		err = fmt.Errorf("error adding cronjob to Cronitor: %w", err)
+		coll.reportCronJobError(cronjob, err)
+		if ClassifyError(err) == ErrorClassTransient {
+			coll.markPendingSync(cronjob)
+		}
 		return err
 	}
+	coll.recordSynced(cronjob)
 	coll.cronjobsMu.Lock()
+	coll.removePendingSyncLocked(cronjob.GetUID())
 	coll.cronjobs[cronjob.GetUID()] = cronjob
 	coll.cronjobsMu.Unlock()
 	slog.Info("cronjob added to Cronitor",
@@ -73,100 +265,265 @@ func (coll *CronJobCollection) AddCronJob(cronjob *v1.CronJob) error {
 func (coll *CronJobCollection) RemoveCronJob(cronjob *v1.CronJob) {
 	coll.cronjobsMu.Lock()
 	delete(coll.cronjobs, cronjob.GetUID())
+	coll.removePendingSyncLocked(cronjob.GetUID())
 	coll.cronjobsMu.Unlock()
+	coll.eventRules.remove(cronjob.GetUID())
+	coll.syncQueue.forget(cronjob.GetUID())
+	coll.syncState.forget(cronjob.GetUID())
 	slog.Info("cronjob no longer watched (Still present in Cronitor)",
 		"namespace", cronjob.Namespace,
 		"name", cronjob.Name)
 }
 
-func (coll *CronJobCollection) LoadAllExistingCronJobs() error {
+// resyncCronJob re-sends a CronJob's monitor, or stops watching it, after configuration
+// it inherits from outside the CronJob itself has changed.
+func (coll *CronJobCollection) resyncCronJob(cronjob *v1.CronJob) {
+	included, err := coll.isCronJobIncluded(cronjob)
+	if err != nil {
+		coll.reportCronJobError(cronjob, CronJobConfigError{cronjob.Namespace, cronjob.Name, err})
+		return
+	}
+	if included {
+		coll.storeEventRules(cronjob)
+		coll.queueSync(cronjob)
+	} else if coll.IsTracked(cronjob.GetUID()) || coll.IsPendingSync(cronjob.GetUID()) {
+		coll.RemoveCronJob(cronjob)
+	}
+}
+
+// RemoveCronJobsInNamespace stops watching every tracked CronJob in a namespace,
+// e.g. when the namespace no longer matches the namespace selector.
+func (coll *CronJobCollection) RemoveCronJobsInNamespace(namespace string) {
+	coll.cronjobsMu.Lock()
+	var removed []*v1.CronJob
+	for uid, cronjob := range coll.cronjobs {
+		if cronjob.Namespace == namespace {
+			removed = append(removed, cronjob)
+			delete(coll.cronjobs, uid)
+		}
+	}
+	for uid, cronjob := range coll.pendingSync {
+		if cronjob.Namespace == namespace {
+			coll.removePendingSyncLocked(uid)
+			coll.syncQueue.forget(uid)
+			coll.eventRules.remove(uid)
+		}
+	}
+	coll.cronjobsMu.Unlock()
+	for _, cronjob := range removed {
+		coll.eventRules.remove(cronjob.GetUID())
+		coll.syncQueue.forget(cronjob.GetUID())
+		coll.syncState.forget(cronjob.GetUID())
+		slog.Info("cronjob no longer watched (Still present in Cronitor)",
+			"namespace", cronjob.Namespace,
+			"name", cronjob.Name)
+	}
+}
+
+// listCronJobs lists the CronJobs in a namespace (or all namespaces, for metav1.NamespaceAll)
+// using the preferred batch API version, normalized to batch/v1.
+func (coll *CronJobCollection) listCronJobs(ctx context.Context, namespace string) ([]v1.CronJob, error) {
 	clientset := coll.clientset
 	listOptions := meta_v1.ListOptions{}
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	// note that if it's global, coll.kubernetesNamespace will be "" (empty string)
+	coll.cronjobListOptions(&listOptions)
 
 	var cronjobs []v1.CronJob
 	if version, err := coll.GetPreferredBatchApiVersion(); err != nil {
-		return err
+		return nil, err
 	} else if version == "v1" {
 		api := clientset.BatchV1()
-		cronJobList, err := api.CronJobs(coll.kubernetesNamespace).List(ctx, listOptions)
+		cronJobList, err := api.CronJobs(namespace).List(ctx, listOptions)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		cronjobs = cronJobList.Items
 	} else if version == "v1beta1" {
 		api := clientset.BatchV1beta1()
-		cronJobList, err := api.CronJobs(coll.kubernetesNamespace).List(ctx, listOptions)
+		cronJobList, err := api.CronJobs(namespace).List(ctx, listOptions)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for _, cj := range cronJobList.Items {
 			cronjobs = append(cronjobs, *normalizer.CronJobConvertV1Beta1ToV1(&cj))
 		}
 	} else {
-		return fmt.Errorf("unexpected apiVersion %s returned", version)
+		return nil, fmt.Errorf("unexpected apiVersion %s returned", version)
+	}
+	return cronjobs, nil
+}
+
+func (coll *CronJobCollection) LoadAllExistingCronJobs(ctx context.Context) error {
+
+	if coll.namespaceScope != nil {
+		if err := coll.namespaceScope.loadNamespaces(ctx, coll.clientset); err != nil {
+			if coll.namespaceScope.usesSelector() {
+				return err
+			}
+			// Namespace-level defaults are optional, so carry on without them
+			slog.Info("namespace annotations unavailable, namespace-level defaults are disabled", "error", err)
+		}
+	}
+	coll.loadPolicies(ctx)
+	coll.accounts.loadKeys(ctx, coll.clientset)
+	if err := coll.syncState.load(ctx); err != nil {
+		// Without the hashes, every monitor is sent again once
+		slog.Warn("could not load synced monitor hashes", "error", err)
+	}
+
+	// When watching all namespaces, WatchedNamespaces is just metav1.NamespaceAll ("")
+	var cronjobs []v1.CronJob
+	for _, namespace := range coll.namespaceScope.WatchedNamespaces() {
+		namespaceCronJobs, err := coll.listCronJobs(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		for _, cronjob := range namespaceCronJobs {
+			if coll.namespaceScope.IsNamespaceIncluded(cronjob.Namespace) {
+				cronjobs = append(cronjobs, cronjob)
+			}
+		}
 	}
 
 	// Collect all included cronjobs first
 	var includedCronJobs []*v1.CronJob
 	for i := range cronjobs {
 		cronjob := &cronjobs[i]
-		if included, err := pkg.NewCronitorConfigParser(cronjob).IsCronJobIncluded(); err == nil && included {
+		included, err := coll.isCronJobIncluded(cronjob)
+		if err != nil {
+			coll.reportCronJobError(cronjob, CronJobConfigError{cronjob.Namespace, cronjob.Name, err})
+			continue
+		}
+		if included {
 			includedCronJobs = append(includedCronJobs, cronjob)
 		}
 	}
 
-	// Sync all cronjobs to Cronitor in a single batch API call
+	coll.syncState.prune(includedCronJobs)
+
+	// Sync all cronjobs to Cronitor in chunked batch API calls. They are only tracked once
+	// their monitors are synced; until then they are pending sync.
 	if len(includedCronJobs) > 0 {
-		_, err := coll.cronitorApi.PutCronJobs(includedCronJobs)
-		if err != nil {
+		coll.markPendingSync(includedCronJobs...)
+		if err := coll.syncPending(ctx); err != nil && api.IsRejectedApiKey(err) {
+			coll.cronjobsMu.Lock()
+			for _, cronjob := range includedCronJobs {
+				coll.removePendingSyncLocked(cronjob.GetUID())
+			}
+			coll.cronjobsMu.Unlock()
 			sentry.CaptureException(err)
 			slog.Error("failed to sync cronjobs to Cronitor - check your API key is a valid SDK key (not a telemetry key)",
 				"cronjob_count", len(includedCronJobs),
 				"error", err)
 			return fmt.Errorf("failed to sync cronjobs to Cronitor: %w", err)
+		} else if err != nil {
+			// Cronitor is unreachable: start watching anyway, the sync queue keeps retrying
+			sentry.CaptureException(err)
+			slog.Warn("could not sync cronjobs to Cronitor, will retry in the background",
+				"cronjob_count", len(includedCronJobs),
+				"error", err)
 		}
-
-		// Only add to local collection after successful API call
-		coll.cronjobsMu.Lock()
-		for _, cronjob := range includedCronJobs {
-			coll.cronjobs[cronjob.GetUID()] = cronjob
-			slog.Debug("cronjob synced to Cronitor",
-				"namespace", cronjob.Namespace,
-				"name", cronjob.Name,
-				"UID", cronjob.UID)
-		}
-		coll.cronjobsMu.Unlock()
 	}
 
 	coll.loaded = true
+	coll.cronjobsMu.RLock()
+	syncedCount, pendingCount := len(coll.cronjobs), len(coll.pendingSync)
+	coll.cronjobsMu.RUnlock()
 	slog.Info("existing CronJobs have been synced to Cronitor",
 		"total_found", len(cronjobs),
-		"synced_count", len(coll.cronjobs))
+		"synced_count", syncedCount,
+		"pending_count", pendingCount)
 	return nil
 }
 
-func (coll *CronJobCollection) StartWatchingAll() {
-	cronJobWatcher := NewCronJobWatcher(*coll)
+func (coll *CronJobCollection) StartWatchingAll() error {
+	cronJobWatcher, err := NewCronJobWatcher(coll)
+	if err != nil {
+		return err
+	}
 
-	coll.stopper = func() {
+	stop := make(chan struct{})
+	syncQueueDone := make(chan struct{})
+	if coll.syncQueue != nil {
+		go func() {
+			defer close(syncQueueDone)
+			coll.runSyncQueue()
+		}()
+	} else {
+		close(syncQueueDone)
+	}
+	if !coll.config.Get().DryRun {
+		go coll.syncState.run(stop)
+	}
+	coll.missedRuns = newMissedRunChecker(coll, func() []*v1.CronJob {
+		return cronJobWatcher.cachedCronJobs(meta_v1.NamespaceAll)
+	})
+	go coll.missedRuns.run(stop)
+
+	coll.stopper = func(ctx context.Context) {
+		// Stop taking in changes and events first, then let what was already taken in finish
+		close(stop)
 		cronJobWatcher.StopWatching()
+		drained := cronJobWatcher.waitForHandlers(ctx)
+		queued := coll.syncQueue.shutDown()
+		drained = drained && waitUntil(ctx, syncQueueDone)
+		if drained && len(queued) > 0 {
+			// CronJobs still waiting out the sync window are synced now, and their held telemetry sent
+			if _, err := coll.syncCronJobs(ctx, queued); err != nil {
+				slog.Warn("could not sync every queued cronjob before exiting", "error", err)
+			}
+		}
+		drained = drained && coll.waitForUploads(ctx)
+		if !drained {
+			slog.Warn("shutdown deadline reached, abandoning the requests to Cronitor still in flight", "error", ctx.Err())
+		}
+		if coll.cancel != nil {
+			coll.cancel()
+		}
+		// ctx may be done by now, but the hashes of what was synced are still worth keeping
+		if !coll.config.Get().DryRun {
+			saveCtx, cancelSave := context.WithTimeout(context.Background(), syncStateSaveTimeout)
+			defer cancelSave()
+			if err := coll.syncState.save(saveCtx); err != nil {
+				slog.Error("could not save synced monitor hashes", "error", err)
+			}
+		}
 	}
 
 	cronJobWatcher.StartWatching()
+	return nil
 }
 
-func (coll *CronJobCollection) StopWatchingAll() {
+// StopWatchingAll stops watching CronJobs and their events, then waits until ctx is done for
+// the pings, monitor syncs and log uploads already under way to be sent. Those still in
+// flight after that are abandoned.
+func (coll *CronJobCollection) StopWatchingAll(ctx context.Context) {
 	if coll.stopper == nil {
 		slog.Warn("CronJobCollection.StopWatchingAll() called, but it wasn't running")
 		return
 	}
-	coll.stopper()
+	coll.stopper(ctx)
 	coll.stopper = nil
 }
 
+// waitForUploads waits until ctx is done for the log uploads of every account's API client,
+// which share the same tracking, to finish.
+func (coll *CronJobCollection) waitForUploads(ctx context.Context) bool {
+	if coll.cronitorApi == nil {
+		return true
+	}
+	return coll.cronitorApi.WaitForUploads(ctx) == nil
+}
+
+// waitUntil waits for done to be closed, and reports whether it was before ctx was done.
+func waitUntil(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (coll *CronJobCollection) GetAllWatchedCronJobUIDs() []types.UID {
 	coll.cronjobsMu.RLock()
 	defer coll.cronjobsMu.RUnlock()
@@ -191,14 +548,39 @@ func (coll *CronJobCollection) GetCronJob(uid types.UID) (*v1.CronJob, bool) {
 	return cronjob, exists
 }
 
+// cronjobEventRules returns the CronJob's own event rules. Invalid rules are reported on the
+// CronJob when it is added or updated, and left out here.
+func (coll *CronJobCollection) cronjobEventRules(cronjob *v1.CronJob) []pkg.EventRule {
+	rules, _ := coll.configParser(cronjob).GetEventRules()
+	return rules
+}
+
+// storeEventRules parses and stores the CronJob's own event rules, reporting them on it if they
+// are invalid. Its events are then handled with the agent's rules alone, so it is still monitored.
+func (coll *CronJobCollection) storeEventRules(cronjob *v1.CronJob) {
+	if err := coll.eventRules.set(cronjob); err != nil {
+		coll.reportCronJobError(cronjob, CronJobConfigError{cronjob.Namespace, cronjob.Name, err})
+	}
+}
+
+// updateTrackedCronJob replaces the stored copy of a tracked CronJob, so that its events are
+// handled with its current annotations, without syncing its monitor.
+func (coll *CronJobCollection) updateTrackedCronJob(cronjob *v1.CronJob) {
+	coll.cronjobsMu.Lock()
+	defer coll.cronjobsMu.Unlock()
+	if _, ok := coll.cronjobs[cronjob.GetUID()]; ok {
+		coll.cronjobs[cronjob.GetUID()] = cronjob
+	}
+}
+
 // CompareServerVersion will return 1 if the server version is higher than the compared version,
 // -1 if it is lower than the compared version, or 0 if they are the same
-func (coll CronJobCollection) CompareServerVersion(major int, minor int) (int, error) {
+func (coll *CronJobCollection) CompareServerVersion(major int, minor int) (int, error) {
 	serverVersionString := fmt.Sprintf("v%s.%s", coll.serverVersion.Major, coll.serverVersion.Minor)
 	return version.CompareKubeAwareVersionStrings(fmt.Sprintf("v%d.%d", major, minor), serverVersionString), nil
 }
 
-func (coll CronJobCollection) GetPreferredBatchApiVersion() (string, error) {
+func (coll *CronJobCollection) GetPreferredBatchApiVersion() (string, error) {
 	if result, err := coll.CompareServerVersion(1, 24); err != nil {
 		return "", err
 	} else if result >= 0 {
@@ -1,10 +1,18 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestServerVersionCompare(t *testing.T) {
@@ -67,10 +75,49 @@ func TestStopWatchingAll_WhenNotStarted(t *testing.T) {
 		}
 	}()
 
-	coll.StopWatchingAll()
+	coll.StopWatchingAll(context.Background())
 
 	// Verify stopper is still nil (wasn't set to something else)
 	if coll.stopper != nil {
 		t.Error("stopper should remain nil after StopWatchingAll on unstarted collection")
 	}
 }
+
+func TestLoadAllExistingCronJobs_PushesDownCronJobSelector(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	prodCronjob := createTestCronJob("prod-job", "default", "uid-prod", "*/5 * * * *")
+	prodCronjob.Labels = map[string]string{"tier": "prod"}
+	devCronjob := createTestCronJob("dev-job", "default", "uid-dev", "*/5 * * * *")
+	devCronjob.Labels = map[string]string{"tier": "dev"}
+
+	clientset := fake.NewSimpleClientset(prodCronjob, devCronjob)
+	cfg := config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL})
+	selector, _ := labels.Parse("tier=prod")
+	coll := &CronJobCollection{
+		clientset:       clientset,
+		serverVersion:   &version.Info{Major: "1", Minor: "25"},
+		cronitorApi:     &api.CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: cfg},
+		cronjobs:        make(map[types.UID]*v1.CronJob),
+		cronjobSelector: selector,
+		config:          cfg,
+	}
+
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var listSelector string
+	for _, action := range clientset.Actions() {
+		if listAction, ok := action.(k8stesting.ListAction); ok && action.GetResource().Resource == "cronjobs" {
+			listSelector = listAction.GetListRestrictions().Labels.String()
+		}
+	}
+	if listSelector != "tier=prod" {
+		t.Errorf("expected the selector to be pushed down to the list call, got %q", listSelector)
+	}
+	if !coll.IsTracked("uid-prod") || coll.IsTracked("uid-dev") {
+		t.Error("expected only the CronJob matching the selector to be tracked")
+	}
+}
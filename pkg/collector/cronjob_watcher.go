@@ -1,32 +1,38 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
+	"reflect"
 
-	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/normalizer"
 	v1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/tools/cache"
 )
 
-func onAdd(coll CronJobCollection, cronjob *v1.CronJob) {
-	configParser := pkg.NewCronitorConfigParser(cronjob)
-	included, err := configParser.IsCronJobIncluded()
+func onAdd(coll *CronJobCollection, cronjob *v1.CronJob) {
+	included, err := coll.isCronJobIncluded(cronjob)
 	if err != nil {
-		panic(err)
+		coll.reportCronJobError(cronjob, CronJobConfigError{cronjob.Namespace, cronjob.Name, err})
+		return
 	}
 	if !included {
 		// If we aren't meant to include the CronJob in Cronitor,
 		// there's nothing to do here.
 		return
 	}
+	coll.storeEventRules(cronjob)
 
 	// Skip if already tracked (was synced during initial LoadAllExistingCronJobs)
-	if coll.IsTracked(cronjob.GetUID()) {
+	// or waiting to be synced in the background
+	if coll.IsTracked(cronjob.GetUID()) || coll.IsPendingSync(cronjob.GetUID()) {
 		slog.Debug("cronjob already tracked, skipping",
 			"namespace", cronjob.Namespace,
 			"name", cronjob.Name,
@@ -34,29 +40,31 @@ func onAdd(coll CronJobCollection, cronjob *v1.CronJob) {
 		return
 	}
 
-	if err := coll.AddCronJob(cronjob); err != nil {
-		// Error is already logged and sent to Sentry in AddCronJob
-		// Continue watching - the cronjob won't be tracked until a successful sync
-		return
-	}
+	// The cronjob won't be tracked until its monitor is synced
+	coll.queueSync(cronjob)
 }
 
-func onUpdate(coll CronJobCollection, cronjobOld *v1.CronJob, cronjobNew *v1.CronJob) {
-	configParserOld := pkg.NewCronitorConfigParser(cronjobOld)
-	configParserNew := pkg.NewCronitorConfigParser(cronjobNew)
-	wasIncluded, err := configParserOld.IsCronJobIncluded()
+func onUpdate(coll *CronJobCollection, cronjobOld *v1.CronJob, cronjobNew *v1.CronJob) {
+	configParserOld := coll.configParser(cronjobOld)
+	configParserNew := coll.configParser(cronjobNew)
+	wasIncluded, err := coll.isCronJobIncluded(cronjobOld)
 	if err != nil {
-		panic(err)
+		// The old version was already reported when we saw it; whether we are
+		// tracking it is the best record of whether it was included.
+		wasIncluded = coll.IsTracked(cronjobOld.GetUID()) || coll.IsPendingSync(cronjobOld.GetUID())
 	}
-	nowIncluded, err := configParserNew.IsCronJobIncluded()
+	nowIncluded, err := coll.isCronJobIncluded(cronjobNew)
 	if err != nil {
-		panic(err)
+		// Leave the CronJob as it is until its configuration is fixed
+		coll.reportCronJobError(cronjobNew, CronJobConfigError{cronjobNew.Namespace, cronjobNew.Name, err})
+		return
+	}
+	if nowIncluded {
+		coll.storeEventRules(cronjobNew)
 	}
 	if !wasIncluded && nowIncluded {
 		// Newly included - sync it (bypass IsTracked check since it's a deliberate add)
-		if err := coll.AddCronJob(cronjobNew); err != nil {
-			return
-		}
+		coll.queueSync(cronjobNew)
 	} else if wasIncluded && !nowIncluded {
 		onDelete(coll, cronjobOld)
 	} else if wasIncluded && nowIncluded {
@@ -71,24 +79,27 @@ func onUpdate(coll CronJobCollection, cronjobOld *v1.CronJob, cronjobNew *v1.Cro
 			"configOld", configParserOld.GetSchedule(),
 			"configNew", configParserNew.GetSchedule())
 
+		// A CronJob still waiting to be synced will be synced as it is now
+		if coll.IsPendingSync(cronjobNew.GetUID()) {
+			coll.queueSync(cronjobNew)
+			return
+		}
+
 		// If the schedule or timezone is updated, sync the change
 		if configParserOld.GetSchedule() != configParserNew.GetSchedule() ||
 			configParserOld.GetTimezone() != configParserNew.GetTimezone() {
-			if err := coll.AddCronJob(cronjobNew); err != nil {
-				return
-			}
+			coll.queueSync(cronjobNew)
+			return
 		}
+		coll.updateTrackedCronJob(cronjobNew)
 	}
 }
 
-func onDelete(coll CronJobCollection, cronjob *v1.CronJob) {
-	configParser := pkg.NewCronitorConfigParser(cronjob)
-	included, err := configParser.IsCronJobIncluded()
-	if err != nil {
-		panic(err)
-	}
-	if !included {
-		// If the CronJob was never included in Cronitor, then nothing to do here.
+func onDelete(coll *CronJobCollection, cronjob *v1.CronJob) {
+	// Whether we are tracking the CronJob is the record of whether it was included;
+	// by the time it is deleted its annotations or labels may no longer say so
+	// (e.g. when it is relabeled out of the CronJob selector).
+	if !coll.IsTracked(cronjob.GetUID()) && !coll.IsPendingSync(cronjob.GetUID()) {
 		return
 	}
 
@@ -96,57 +107,151 @@ func onDelete(coll CronJobCollection, cronjob *v1.CronJob) {
 }
 
 type CronJobWatcher struct {
-	informer    cache.SharedIndexInformer
-	stopper     chan struct{}
-	jobsWatcher *WatchWrapper
+	// informers holds one CronJob informer per watched namespace,
+	// keyed by namespace (metav1.NamespaceAll when watching the whole cluster)
+	informers         map[string]cache.SharedIndexInformer
+	namespaceInformer cache.SharedIndexInformer
+	policyInformers   []cache.SharedIndexInformer
+	podInformers      []cache.SharedIndexInformer
+	accountInformers  []cache.SharedIndexInformer
+	version           string
+	collection        *CronJobCollection
+	stopper           chan struct{}
+	jobsWatchers      []*WatchWrapper
 }
 
-func (c CronJobWatcher) StartWatching() {
+func (c *CronJobWatcher) StartWatching() {
 	defer runtime.HandleCrash()
 
 	slog.Info("the CronJob watcher is starting...")
-	go c.informer.Run(c.stopper)
-	go c.jobsWatcher.Start()
+	for _, informer := range c.informers {
+		go informer.Run(c.stopper)
+	}
+	if c.namespaceInformer != nil {
+		go c.namespaceInformer.Run(c.stopper)
+	}
+	for _, informer := range c.policyInformers {
+		go informer.Run(c.stopper)
+	}
+	for _, informer := range c.podInformers {
+		go informer.Run(c.stopper)
+	}
+	for _, informer := range c.accountInformers {
+		go informer.Run(c.stopper)
+	}
+	for _, jobsWatcher := range c.jobsWatchers {
+		go jobsWatcher.Start()
+	}
 }
 
-func (c CronJobWatcher) StopWatching() {
+func (c *CronJobWatcher) StopWatching() {
 	close(c.stopper)
-	c.jobsWatcher.Stop()
+	for _, jobsWatcher := range c.jobsWatchers {
+		jobsWatcher.Stop()
+	}
+}
+
+// waitForHandlers waits until ctx is done for the events the jobs watchers took in before
+// they were stopped to be handled, and reports whether they were.
+func (c *CronJobWatcher) waitForHandlers(ctx context.Context) bool {
+	for _, jobsWatcher := range c.jobsWatchers {
+		if !jobsWatcher.wait(ctx) {
+			return false
+		}
+	}
+	return true
+}
+
+// informerForNamespace returns the CronJob informer whose cache holds the given namespace.
+func (c *CronJobWatcher) informerForNamespace(namespace string) (cache.SharedIndexInformer, bool) {
+	if informer, ok := c.informers[namespace]; ok {
+		return informer, true
+	}
+	informer, ok := c.informers[meta_v1.NamespaceAll]
+	return informer, ok
+}
+
+// onNamespaceScopeChange syncs or drops the CronJobs of a namespace that has started
+// or stopped matching the namespace selector.
+func (c *CronJobWatcher) onNamespaceScopeChange(namespace string, wasIncluded bool, nowIncluded bool) {
+	if wasIncluded == nowIncluded {
+		return
+	}
+	if !nowIncluded {
+		slog.Info("namespace no longer in scope", "namespace", namespace)
+		c.collection.RemoveCronJobsInNamespace(namespace)
+		return
+	}
+
+	slog.Info("namespace now in scope", "namespace", namespace)
+	for _, cronjob := range c.cachedCronJobs(namespace) {
+		onAdd(c.collection, cronjob)
+	}
+}
+
+// resyncNamespace re-evaluates every CronJob in a namespace after the namespace's
+// Cronitor annotations change, since the CronJobs inherit them.
+func (c *CronJobWatcher) resyncNamespace(namespace string) {
+	slog.Info("namespace annotations changed, resyncing cronjobs", "namespace", namespace)
+	for _, cronjob := range c.cachedCronJobs(namespace) {
+		c.collection.resyncCronJob(cronjob)
+	}
+}
+
+// onConfigChange applies a reloaded config file. The pod filter and log shipping are read
+// from the config as events arrive; changed CronJob defaults need every CronJob resynced.
+func (c *CronJobWatcher) onConfigChange(previous *config.Config, current *config.Config) {
+	if previous != nil && reflect.DeepEqual(previous.ChartDefaults(), current.ChartDefaults()) {
+		return
+	}
+
+	slog.Info("cronjob defaults changed, resyncing cronjobs")
+	for _, cronjob := range c.cachedCronJobs(meta_v1.NamespaceAll) {
+		if c.collection.namespaceScope.IsNamespaceIncluded(cronjob.Namespace) {
+			c.collection.resyncCronJob(cronjob)
+		}
+	}
 }
 
 func coerceObjToV1CronJob(version string, obj interface{}) *v1.CronJob {
+	// Deletions that the informer missed while disconnected arrive wrapped in a tombstone
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
 	var cronjob *v1.CronJob
 	if version == "v1" {
-		cronjob = obj.(*v1.CronJob)
+		cronjob, _ = obj.(*v1.CronJob)
 	} else if version == "v1beta1" {
-		temp := obj.(*v1beta1.CronJob)
-		cronjob = normalizer.CronJobConvertV1Beta1ToV1(temp)
+		if temp, ok := obj.(*v1beta1.CronJob); ok {
+			cronjob = normalizer.CronJobConvertV1Beta1ToV1(temp)
+		}
 	}
 
 	return cronjob
 }
 
-func NewCronJobWatcher(coll CronJobCollection) CronJobWatcher {
+func newCronJobInformer(coll *CronJobCollection, namespace string, version string) (cache.SharedIndexInformer, error) {
 	clientset := coll.clientset
 	var factory informers.SharedInformerFactory
-	if coll.kubernetesNamespace == "" {
-		factory = informers.NewSharedInformerFactory(clientset, 0)
-	} else {
-		factory = informers.NewSharedInformerFactoryWithOptions(clientset, 0, informers.WithNamespace(coll.kubernetesNamespace))
+	factoryOptions := []informers.SharedInformerOption{
+		informers.WithTweakListOptions(coll.cronjobListOptions),
+	}
+	if namespace != meta_v1.NamespaceAll {
+		factoryOptions = append(factoryOptions, informers.WithNamespace(namespace))
 	}
+	factory = informers.NewSharedInformerFactoryWithOptions(clientset, 0, factoryOptions...)
 
 	var informer cache.SharedIndexInformer
-	version, err := coll.GetPreferredBatchApiVersion()
-	if err != nil {
-		panic(err)
-	} else if version == "v1" {
+	if version == "v1" {
 		informer = factory.Batch().V1().CronJobs().Informer()
 	} else if version == "v1beta1" {
 		informer = factory.Batch().V1beta1().CronJobs().Informer()
 	} else {
-		panic(fmt.Sprintf("Invalid ApiVersion %s requested", version))
+		return nil, fmt.Errorf("invalid ApiVersion %s requested", version)
 	}
 
+	scope := coll.namespaceScope
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(obj interface{}) {
 			cronjob := coerceObjToV1CronJob(version, obj)
@@ -154,6 +259,9 @@ func NewCronJobWatcher(coll CronJobCollection) CronJobWatcher {
 				slog.Error("failed to coerce object to CronJob", "version", version)
 				return
 			}
+			if !scope.IsNamespaceIncluded(cronjob.Namespace) {
+				return
+			}
 			onAdd(coll, cronjob)
 		},
 		DeleteFunc: func(obj interface{}) {
@@ -171,15 +279,99 @@ func NewCronJobWatcher(coll CronJobCollection) CronJobWatcher {
 				slog.Error("failed to coerce object to CronJob", "version", version)
 				return
 			}
+			if !scope.IsNamespaceIncluded(newCronjob.Namespace) {
+				return
+			}
 			onUpdate(coll, oldCronjob, newCronjob)
 		},
 	})
 
-	jobsWatcher := NewJobsEventWatcher(&coll)
+	return informer, nil
+}
+
+func newNamespaceInformer(watcher *CronJobWatcher) cache.SharedIndexInformer {
+	scope := watcher.collection.namespaceScope
+	factory := informers.NewSharedInformerFactory(watcher.collection.clientset, 0)
+	informer := factory.Core().V1().Namespaces().Informer()
+
+	onNamespace := func(obj interface{}) {
+		namespace, ok := obj.(*corev1.Namespace)
+		if !ok {
+			return
+		}
+		previousAnnotations, wasIncluded, nowIncluded := scope.setNamespace(namespace)
+		if wasIncluded != nowIncluded {
+			watcher.onNamespaceScopeChange(namespace.Name, wasIncluded, nowIncluded)
+		} else if nowIncluded && hasCronitorAnnotationChanges(previousAnnotations, namespace.Annotations) {
+			watcher.resyncNamespace(namespace.Name)
+		}
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onNamespace,
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			onNamespace(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if namespace, ok := obj.(*corev1.Namespace); ok {
+				// The CronJobs' own deletions remove them from the collection
+				scope.deleteNamespace(namespace.Name)
+			}
+		},
+	})
+
+	return informer
+}
+
+func NewCronJobWatcher(coll *CronJobCollection) (*CronJobWatcher, error) {
+	version, err := coll.GetPreferredBatchApiVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	watcher := &CronJobWatcher{
+		informers:  make(map[string]cache.SharedIndexInformer),
+		version:    version,
+		collection: coll,
+		stopper:    make(chan struct{}),
+	}
+
+	for _, namespace := range coll.namespaceScope.WatchedNamespaces() {
+		informer, err := newCronJobInformer(coll, namespace, version)
+		if err != nil {
+			return nil, err
+		}
+		watcher.informers[namespace] = informer
+
+		jobsWatcher, err := NewJobsEventWatcher(coll, namespace)
+		if err != nil {
+			return nil, err
+		}
+		watcher.jobsWatchers = append(watcher.jobsWatchers, jobsWatcher)
+
+		// The Pods are watched with the same handler, which knows the runs they already reported
+		if coll.config != nil && coll.config.Get().SidecarCompletion {
+			watcher.podInformers = append(watcher.podInformers, newJobPodInformer(jobsWatcher.eventHandler, namespace))
+		}
+	}
 
-	return CronJobWatcher{
-		informer:    informer,
-		stopper:     make(chan struct{}),
-		jobsWatcher: jobsWatcher,
+	if coll.namespaceScope != nil && coll.namespaceScope.namespacesWatchable {
+		watcher.namespaceInformer = newNamespaceInformer(watcher)
+	}
+	for _, watch := range coll.policyWatches {
+		watcher.policyInformers = append(watcher.policyInformers, newPolicyInformer(watcher, watch))
+	}
+	if coll.accounts != nil {
+		for _, acct := range coll.accounts.accounts {
+			watcher.accountInformers = append(watcher.accountInformers, newAccountSecretInformer(watcher, acct))
+		}
+	}
+	if coll.config != nil {
+		coll.config.OnChange(watcher.onConfigChange)
 	}
+
+	return watcher, nil
 }
@@ -1,17 +1,22 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 
 	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
-	"github.com/spf13/viper"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
 	v1 "k8s.io/api/batch/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 // mockAPIServer creates a test server that tracks API calls
@@ -19,6 +24,7 @@ type mockAPIServer struct {
 	server       *httptest.Server
 	requestCount int
 	lastBody     string
+	status       int
 	mu           sync.Mutex
 }
 
@@ -37,6 +43,10 @@ func newMockAPIServer() *mockAPIServer {
 			m.lastBody = string(body)
 		}
 
+		if m.status != 0 && m.status != http.StatusOK {
+			w.WriteHeader(m.status)
+			return
+		}
 		w.WriteHeader(http.StatusOK)
 		// Return empty array for monitors response
 		w.Write([]byte("[]"))
@@ -44,6 +54,13 @@ func newMockAPIServer() *mockAPIServer {
 	return m
 }
 
+// setStatus makes the server answer every following request with the given status code
+func (m *mockAPIServer) setStatus(status int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status = status
+}
+
 func (m *mockAPIServer) getRequestCount() int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -55,18 +72,20 @@ func (m *mockAPIServer) close() {
 }
 
 // createTestCollection creates a minimal CronJobCollection for testing
-func createTestCollection(mockServer *mockAPIServer) CronJobCollection {
-	// Set the hostname override to use our mock server
-	viper.Set("hostname-override", mockServer.server.URL)
+func createTestCollection(mockServer *mockAPIServer) *CronJobCollection {
+	// Point the API client at our mock server
+	cfg := config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL})
 
 	cronitorApi := &api.CronitorApi{
 		ApiKey:    "test-key",
 		UserAgent: "test-agent",
+		Config:    cfg,
 	}
 
-	return CronJobCollection{
+	return &CronJobCollection{
 		cronitorApi: cronitorApi,
 		cronjobs:    make(map[types.UID]*v1.CronJob),
+		config:      cfg,
 	}
 }
 
@@ -464,11 +483,10 @@ func TestBulkSync_AllJobsInSingleRequest(t *testing.T) {
 	}))
 	defer server.Close()
 
-	viper.Set("hostname-override", server.URL)
-
 	cronitorApi := &api.CronitorApi{
 		ApiKey:    "test-key",
 		UserAgent: "test-agent",
+		Config:    config.NewStore(&config.Config{HostnameOverride: server.URL}),
 	}
 
 	// Simulate bulk sync by calling PutCronJobs directly
@@ -478,7 +496,7 @@ func TestBulkSync_AllJobsInSingleRequest(t *testing.T) {
 		createTestCronJob("job-3", "ns3", "uid-3", "0 0 * * *"),
 	}
 
-	_, err := cronitorApi.PutCronJobs(cronjobs)
+	_, err := cronitorApi.PutCronJobs(context.Background(), cronjobs)
 	if err != nil {
 		t.Fatalf("PutCronJobs failed: %v", err)
 	}
@@ -529,3 +547,173 @@ func TestCoerceObjToV1CronJob_ReturnsNilForUnknownVersion(t *testing.T) {
 		t.Error("expected nil result for empty version")
 	}
 }
+
+func TestOnAdd_InvalidAnnotationRecordsWarningEvent(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	recorder := record.NewFakeRecorder(10)
+	coll.recorder = recorder
+
+	cronjob := createTestCronJob("bad-job", "default", "uid-bad", "*/5 * * * *")
+	cronjob.Annotations = map[string]string{
+		"k8s.cronitor.io/exclude": "not-a-bool",
+	}
+
+	// Should not panic; the error is reported and the CronJob is skipped
+	onAdd(coll, cronjob)
+
+	if mockServer.getRequestCount() != 0 {
+		t.Errorf("expected 0 API calls for a misconfigured job, got %d", mockServer.getRequestCount())
+	}
+	if coll.IsTracked(cronjob.GetUID()) {
+		t.Error("expected misconfigured job not to be tracked")
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning "+EventReasonInvalidConfiguration) {
+			t.Errorf("expected a %s warning event, got %q", EventReasonInvalidConfiguration, event)
+		}
+	default:
+		t.Error("expected a warning event to be recorded")
+	}
+}
+
+func TestOnUpdate_InvalidAnnotationKeepsTracking(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.recorder = record.NewFakeRecorder(10)
+
+	oldCronjob := createTestCronJob("my-job", "default", "uid-keep", "*/5 * * * *")
+	newCronjob := createTestCronJob("my-job", "default", "uid-keep", "*/10 * * * *")
+	newCronjob.Annotations = map[string]string{
+		"k8s.cronitor.io/exclude": "maybe",
+	}
+	coll.cronjobs[oldCronjob.GetUID()] = oldCronjob
+
+	onUpdate(coll, oldCronjob, newCronjob)
+
+	if mockServer.getRequestCount() != 0 {
+		t.Errorf("expected 0 API calls when the new version is misconfigured, got %d", mockServer.getRequestCount())
+	}
+	if !coll.IsTracked(oldCronjob.GetUID()) {
+		t.Error("expected job to remain tracked until its configuration is fixed")
+	}
+}
+
+func TestOnUpdate_RecoversFromInvalidAnnotation(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+
+	oldCronjob := createTestCronJob("my-job", "default", "uid-fixed", "*/5 * * * *")
+	oldCronjob.Annotations = map[string]string{
+		"k8s.cronitor.io/exclude": "maybe",
+	}
+	newCronjob := createTestCronJob("my-job", "default", "uid-fixed", "*/5 * * * *")
+
+	onUpdate(coll, oldCronjob, newCronjob)
+
+	if mockServer.getRequestCount() != 1 {
+		t.Errorf("expected 1 API call once the configuration is fixed, got %d", mockServer.getRequestCount())
+	}
+	if !coll.IsTracked(newCronjob.GetUID()) {
+		t.Error("expected job to be tracked once its configuration is fixed")
+	}
+}
+
+func TestCoerceObjToV1CronJob_UnwrapsTombstone(t *testing.T) {
+	cronjob := createTestCronJob("deleted-job", "default", "uid-gone", "*/5 * * * *")
+	tombstone := cache.DeletedFinalStateUnknown{Key: "default/deleted-job", Obj: cronjob}
+
+	result := coerceObjToV1CronJob("v1", tombstone)
+	if result == nil || result.UID != cronjob.UID {
+		t.Errorf("expected tombstone to be unwrapped to the cronjob, got %v", result)
+	}
+
+	// An unexpected object type should not panic
+	if result := coerceObjToV1CronJob("v1", "not a cronjob"); result != nil {
+		t.Errorf("expected nil for an unexpected object type, got %v", result)
+	}
+}
+
+func TestOnAdd_SkipsWhenCronJobSelectorDoesNotMatch(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.cronjobSelector, _ = labels.Parse("tier=prod")
+
+	devCronjob := createTestCronJob("dev-job", "default", "uid-dev", "*/5 * * * *")
+	devCronjob.Labels = map[string]string{"tier": "dev"}
+	onAdd(coll, devCronjob)
+
+	if mockServer.getRequestCount() != 0 {
+		t.Errorf("expected 0 API calls for a CronJob outside the selector, got %d", mockServer.getRequestCount())
+	}
+
+	prodCronjob := createTestCronJob("prod-job", "default", "uid-prod", "*/5 * * * *")
+	prodCronjob.Labels = map[string]string{"tier": "prod"}
+	onAdd(coll, prodCronjob)
+
+	if !coll.IsTracked(prodCronjob.GetUID()) {
+		t.Error("expected the CronJob matching the selector to be tracked")
+	}
+}
+
+func TestOnDelete_RemovesTrackedJobRelabeledOutOfSelector(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.cronjobSelector, _ = labels.Parse("tier=prod")
+
+	cronjob := createTestCronJob("my-job", "default", "uid-relabeled", "*/5 * * * *")
+	coll.cronjobs[cronjob.GetUID()] = cronjob
+
+	// A label-filtered watch reports a CronJob relabeled out of the selector as deleted,
+	// carrying the new labels
+	cronjob.Labels = map[string]string{"tier": "dev"}
+	onDelete(coll, cronjob)
+
+	if coll.IsTracked(cronjob.GetUID()) {
+		t.Error("expected the relabeled CronJob to no longer be tracked")
+	}
+}
+
+func TestOnConfigChange_ResyncsWhenDefaultsChange(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	informer, err := newCronJobInformer(coll, metav1.NamespaceAll, "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	informer.GetIndexer().Add(createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"))
+	watcher := &CronJobWatcher{
+		informers:  map[string]cache.SharedIndexInformer{metav1.NamespaceAll: informer},
+		version:    "v1",
+		collection: coll,
+	}
+	coll.config.OnChange(watcher.onConfigChange)
+	hostname := coll.config.Get().HostnameOverride
+
+	coll.config.Update(&config.Config{HostnameOverride: hostname, ShipLogs: true})
+	if count := mockServer.getRequestCount(); count != 0 {
+		t.Errorf("expected no resync when the defaults are unchanged, got %d requests", count)
+	}
+
+	coll.config.Update(&config.Config{HostnameOverride: hostname, Tags: []string{"reloaded"}})
+	if count := mockServer.getRequestCount(); count != 1 {
+		t.Errorf("expected the CronJob to be resynced, got %d requests", count)
+	}
+	if !strings.Contains(mockServer.lastBody, `"reloaded"`) {
+		t.Errorf("expected the reloaded tags on the monitor, got body %s", mockServer.lastBody)
+	}
+}
@@ -1,5 +1,12 @@
 package collector
 
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+)
+
 type PodNotFoundError struct {
 	podNamespace string
 	podName      string
@@ -27,3 +34,76 @@ func (e JobNotFoundError) Error() string {
 func (e JobNotFoundError) Unwrap() error {
 	return e.Err
 }
+
+// ErrorClass describes how an error encountered while handling a single
+// CronJob should be treated.
+type ErrorClass string
+
+const (
+	// ErrorClassConfig is a problem with the CronJob's own configuration, such as
+	// an invalid annotation value. It will not go away until the object is changed.
+	ErrorClassConfig ErrorClass = "config"
+
+	// ErrorClassTransient is a problem that may resolve by itself, such as a failed
+	// request to the Cronitor API.
+	ErrorClassTransient ErrorClass = "transient"
+)
+
+// CronJobConfigError wraps an error caused by the configuration of a CronJob,
+// for instance an annotation that could not be parsed.
+type CronJobConfigError struct {
+	cronjobNamespace string
+	cronjobName      string
+	Err              error
+}
+
+func (e CronJobConfigError) Error() string {
+	return "invalid configuration on cronjob " + e.cronjobNamespace + "/" + e.cronjobName + ": " + e.Err.Error()
+}
+
+func (e CronJobConfigError) Unwrap() error {
+	return e.Err
+}
+
+// NoAccountError means that a CronJob's namespace has no Cronitor account with a usable
+// API key: it belongs to an account whose keys couldn't be read or were rejected, or it
+// matches no account and the agent has no API key of its own.
+type NoAccountError struct {
+	Namespace string
+	// Account is the account the namespace belongs to, if any
+	Account string
+	Err     error
+}
+
+func (e NoAccountError) Error() string {
+	if e.Account == "" {
+		return "no Cronitor account for namespace " + e.Namespace + ": it matches no configured account and the agent has no API key of its own"
+	}
+	return "Cronitor account " + e.Account + " for namespace " + e.Namespace + " is unavailable: " + e.Err.Error()
+}
+
+// ClassifyError decides whether an error is caused by a CronJob's configuration
+// or is transient. Cronitor API rejections (4xx other than 429) are treated as
+// configuration errors, since retrying the same monitor will fail the same way.
+func ClassifyError(err error) ErrorClass {
+	var configErr CronJobConfigError
+	if errors.As(err, &configErr) {
+		return ErrorClassConfig
+	}
+	var monitorErr api.MonitorError
+	if errors.As(err, &monitorErr) {
+		return ErrorClassConfig
+	}
+	var noAccountErr NoAccountError
+	if errors.As(err, &noAccountErr) {
+		return ErrorClassConfig
+	}
+	var apiErr api.CronitorApiError
+	if errors.As(err, &apiErr) && apiErr.Response != nil {
+		status := apiErr.Response.StatusCode
+		if status >= 400 && status < 500 && status != http.StatusTooManyRequests {
+			return ErrorClassConfig
+		}
+	}
+	return ErrorClassTransient
+}
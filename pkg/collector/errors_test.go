@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+)
+
+func TestClassifyError(t *testing.T) {
+	apiError := func(status int) error {
+		return api.CronitorApiError{
+			Err:      fmt.Errorf("error response code %d returned", status),
+			Response: &http.Response{StatusCode: status},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{"annotation error", CronJobConfigError{"default", "my-job", errors.New("invalid syntax")}, ErrorClassConfig},
+		{"wrapped annotation error", fmt.Errorf("context: %w", CronJobConfigError{"default", "my-job", errors.New("invalid syntax")}), ErrorClassConfig},
+		{"400 from Cronitor", apiError(http.StatusBadRequest), ErrorClassConfig},
+		{"wrapped 400 from Cronitor", fmt.Errorf("error adding cronjob: %w", apiError(http.StatusBadRequest)), ErrorClassConfig},
+		{"429 from Cronitor", apiError(http.StatusTooManyRequests), ErrorClassTransient},
+		{"500 from Cronitor", apiError(http.StatusInternalServerError), ErrorClassTransient},
+		{"network error", api.CronitorApiError{Err: errors.New("connection refused")}, ErrorClassTransient},
+		{"unknown error", errors.New("something else"), ErrorClassTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
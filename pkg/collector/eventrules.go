@@ -0,0 +1,93 @@
+package collector
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// eventRuleStore holds the event rules of the included CronJobs, parsed once from their
+// annotations when they are added or updated, and the reasons any of them name, so that events
+// the agent's rules ignore are only fetched for when a CronJob's rule may report them.
+// Its zero value is ready to use.
+type eventRuleStore struct {
+	mu       sync.RWMutex
+	cronjobs map[types.UID]parsedEventRules
+	// reasons counts the rules naming each reason, in lower case
+	reasons map[string]int
+}
+
+// parsedEventRules are the rules parsed from a CronJob's event rules annotation.
+type parsedEventRules struct {
+	annotation string
+	rules      []pkg.EventRule
+}
+
+// set parses and stores cronjob's event rules, unless they were stored for the same annotation
+// already. Invalid rules are stored as none, and their error returned.
+func (s *eventRuleStore) set(cronjob *v1.CronJob) error {
+	annotation := cronjob.Annotations[string(pkg.AnnotationEventRules)]
+	s.mu.RLock()
+	stored, ok := s.cronjobs[cronjob.GetUID()]
+	s.mu.RUnlock()
+	if ok && stored.annotation == annotation {
+		return nil
+	}
+
+	rules, err := pkg.NewCronitorConfigParser(cronjob, pkg.ConfigSources{}).GetEventRules()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cronjobs == nil {
+		s.cronjobs = make(map[types.UID]parsedEventRules)
+		s.reasons = make(map[string]int)
+	}
+	s.removeLocked(cronjob.GetUID())
+	s.cronjobs[cronjob.GetUID()] = parsedEventRules{annotation: annotation, rules: rules}
+	for _, rule := range rules {
+		s.reasons[strings.ToLower(rule.Reason)]++
+	}
+	return err
+}
+
+// remove forgets the rules of the CronJob with uid.
+func (s *eventRuleStore) remove(uid types.UID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(uid)
+}
+
+func (s *eventRuleStore) removeLocked(uid types.UID) {
+	stored, ok := s.cronjobs[uid]
+	if !ok {
+		return
+	}
+	for _, rule := range stored.rules {
+		reason := strings.ToLower(rule.Reason)
+		if s.reasons[reason]--; s.reasons[reason] <= 0 {
+			delete(s.reasons, reason)
+		}
+	}
+	delete(s.cronjobs, uid)
+}
+
+// lookup returns cronjob's stored rules, if they were parsed from the annotation it has now.
+// It is the pkg.EventRulesLookup of the collection's CronJobs.
+func (s *eventRuleStore) lookup(cronjob *v1.CronJob) ([]pkg.EventRule, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stored, ok := s.cronjobs[cronjob.GetUID()]
+	if !ok || stored.annotation != cronjob.Annotations[string(pkg.AnnotationEventRules)] {
+		return nil, false
+	}
+	return stored.rules, true
+}
+
+// namesReason reports whether any stored rule matches events with reason.
+func (s *eventRuleStore) namesReason(reason string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reasons[strings.ToLower(reason)] > 0
+}
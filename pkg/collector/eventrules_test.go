@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+)
+
+func TestEventRuleStore(t *testing.T) {
+	var store eventRuleStore
+	cronjob := createTestCronJob("rules", "default", "uid-rules", "*/5 * * * *")
+	cronjob.Annotations = map[string]string{
+		string(pkg.AnnotationEventRules): `[{"kind": "Pod", "reason": "Evicted", "state": "fail"}]`,
+	}
+	if err := store.set(cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rules, ok := store.lookup(cronjob); !ok || len(rules) != 1 {
+		t.Fatalf("expected the parsed rule to be stored, got %+v", rules)
+	}
+	if !store.namesReason("evicted") || store.namesReason("BackOff") {
+		t.Error("expected only the reasons of the stored rules to be named")
+	}
+
+	// Rules parsed from another annotation are stale until the CronJob is set again
+	updated := cronjob.DeepCopy()
+	updated.Annotations[string(pkg.AnnotationEventRules)] = `[{"reason": "BackOff", "state": "ignore"}]`
+	if _, ok := store.lookup(updated); ok {
+		t.Error("expected stale rules not to be returned")
+	}
+	if err := store.set(updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if store.namesReason("Evicted") || !store.namesReason("BackOff") {
+		t.Error("expected the reasons of the replaced rules to be forgotten")
+	}
+
+	// Invalid rules are reported once, and stored as none
+	updated = updated.DeepCopy()
+	updated.Annotations[string(pkg.AnnotationEventRules)] = `[{"reason": "BackOff", "state": "page"}]`
+	if err := store.set(updated); err == nil {
+		t.Error("expected invalid rules to be rejected")
+	}
+	if err := store.set(updated); err != nil {
+		t.Errorf("expected the same annotation not to be parsed again, got %v", err)
+	}
+	if rules, ok := store.lookup(updated); !ok || len(rules) != 0 {
+		t.Errorf("expected invalid rules to be stored as none, got %+v", rules)
+	}
+
+	store.remove(updated.GetUID())
+	if _, ok := store.lookup(updated); ok || store.namesReason("BackOff") {
+		t.Error("expected a removed CronJob's rules to be forgotten")
+	}
+}
@@ -7,13 +7,12 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"regexp"
+	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/cronitorio/cronitor-kubernetes/pkg"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
-	"github.com/spf13/viper"
 	v1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,22 +25,19 @@ import (
 
 type EventHandler struct {
 	collection     *CronJobCollection
-	podFilter      *regexp.Regexp
 	watchStartTime atomic.Pointer[meta_v1.Time]
+	stuckPods      stuckPodTracker
+	// exitedPods holds the Pods whose runs were reported once their main containers exited,
+	// and the Jobs that completed that way
+	exitedPods reportedSet
+	// failedCreates holds the Jobs whose FailedCreate event was reported, as the Job controller
+	// records another on each retry
+	failedCreates reportedSet
 }
 
-func createPodFilter() *regexp.Regexp {
-	if filterStr := viper.GetString("pod-filter"); filterStr != "" {
-		slog.Debug("pod filter enabled", "filter", filterStr)
-		return regexp.MustCompile(filterStr)
-	}
-
-	return nil
-}
-
-func (e EventHandler) fetchPod(namespace string, podName string) (*corev1.Pod, error) {
+func (e *EventHandler) fetchPod(namespace string, podName string) (*corev1.Pod, error) {
 	clientset := e.collection.clientset
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(e.collection.context())
 	defer cancel()
 	podsClient := clientset.CoreV1().Pods(namespace)
 	pod, err := podsClient.Get(ctx, podName, meta_v1.GetOptions{})
@@ -52,10 +48,12 @@ func (e EventHandler) fetchPod(namespace string, podName string) (*corev1.Pod, e
 	return pod, nil
 }
 
-// fetchPodByJobName grabs the Pod metadata from the Kubernetes API
-func (e EventHandler) fetchPodByJobName(namespace string, jobName string) (*corev1.Pod, error) {
+// fetchPodByJobName grabs the Pod metadata from the Kubernetes API. When the Job has run several
+// Pods, e.g. retries with restartPolicy Never, it returns the latest; when it has none, e.g. because
+// they could not be created or were deleted past a deadline, it returns nil.
+func (e *EventHandler) fetchPodByJobName(namespace string, jobName string) (*corev1.Pod, error) {
 	clientset := e.collection.clientset
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(e.collection.context())
 	defer cancel()
 	podsClient := clientset.CoreV1().Pods(namespace)
 	listOptions := meta_v1.ListOptions{
@@ -66,20 +64,19 @@ func (e EventHandler) fetchPodByJobName(namespace string, jobName string) (*core
 		return nil, err
 	}
 
-	switch itemsLength := len(pods.Items); itemsLength {
-	case 0:
-		return nil, fmt.Errorf("no pod matching job name %s found", jobName)
-	case 1:
-		return &pods.Items[0], nil
-	default:
-		return nil, fmt.Errorf("more than one pod matching job name %s, %d found", jobName, itemsLength)
+	var latest *corev1.Pod
+	for i := range pods.Items {
+		if latest == nil || latest.CreationTimestamp.Before(&pods.Items[i].CreationTimestamp) {
+			latest = &pods.Items[i]
+		}
 	}
+	return latest, nil
 }
 
 // fetchJob gets the Job's information from the Kubernetes API.
-func (e EventHandler) fetchJob(namespace string, name string) (*v1.Job, error) {
+func (e *EventHandler) fetchJob(namespace string, name string) (*v1.Job, error) {
 	clientset := e.collection.clientset
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(e.collection.context())
 	defer cancel()
 	jobsClient := clientset.BatchV1().Jobs(namespace)
 	job, err := jobsClient.Get(ctx, name, meta_v1.GetOptions{})
@@ -89,18 +86,41 @@ func (e EventHandler) fetchJob(namespace string, name string) (*v1.Job, error) {
 	return job, nil
 }
 
-func (e EventHandler) fetchCronJob(uid types.UID) (*v1.CronJob, error) {
+func (e *EventHandler) fetchCronJob(uid types.UID) (*v1.CronJob, error) {
 	if cronjob, ok := e.collection.GetCronJob(uid); ok {
 		return cronjob, nil
 	}
+	if cronjob, ok := e.collection.getPendingCronJob(uid); ok {
+		return cronjob, nil
+	}
 	return nil, fmt.Errorf("cronjob %s not found in collection", string(uid))
 }
 
-func (e EventHandler) fetchPodLogs(pod *corev1.Pod) (string, error) {
-	podLogOpts := corev1.PodLogOptions{}
+// fetchPodLogs returns the logs of the given containers of a Pod, one after the other, or of its
+// default container if none are given.
+func (e *EventHandler) fetchPodLogs(pod *corev1.Pod, containers []string) (string, error) {
+	if len(containers) == 0 {
+		return e.fetchContainerLogs(pod, "")
+	}
+	if len(containers) == 1 {
+		return e.fetchContainerLogs(pod, containers[0])
+	}
+	var all strings.Builder
+	for _, container := range containers {
+		logs, err := e.fetchContainerLogs(pod, container)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&all, "==> container %s <==\n%s", container, logs)
+	}
+	return all.String(), nil
+}
+
+func (e *EventHandler) fetchContainerLogs(pod *corev1.Pod, container string) (string, error) {
+	podLogOpts := corev1.PodLogOptions{Container: container}
 	clientset := e.collection.clientset
 	req := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &podLogOpts)
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(e.collection.context())
 	defer cancel()
 	podLogs, err := req.Stream(ctx)
 	if err != nil {
@@ -122,7 +142,7 @@ func (e EventHandler) fetchPodLogs(pod *corev1.Pod) (string, error) {
 // the owner chain to a watched CronJob, and retrieves the associated Pod and logs.
 // It replaces the old CheckJobIsWatched + FetchObjectsFromJobEvent combination,
 // eliminating redundant API calls.
-func (e EventHandler) FetchAndCheckJobEvent(namespace, jobName string, includeLogs bool) (pod *corev1.Pod, logs string, job *v1.Job, cronjob *v1.CronJob, watched bool, err error) {
+func (e *EventHandler) FetchAndCheckJobEvent(namespace, jobName string, includeLogs bool) (pod *corev1.Pod, logs string, job *v1.Job, cronjob *v1.CronJob, watched bool, err error) {
 	// 1. Single GET for the Job
 	job, err = e.fetchJob(namespace, jobName)
 	if err != nil {
@@ -138,9 +158,9 @@ func (e EventHandler) FetchAndCheckJobEvent(namespace, jobName string, includeLo
 		return
 	}
 
-	// 3. Check if tracked (in-memory)
+	// 3. Check if tracked or waiting to be synced (in-memory)
 	ownerUID := ownerReference.UID
-	if !e.collection.IsTracked(ownerUID) {
+	if !e.collection.IsTracked(ownerUID) && !e.collection.IsPendingSync(ownerUID) {
 		return
 	}
 	watched = true
@@ -158,8 +178,8 @@ func (e EventHandler) FetchAndCheckJobEvent(namespace, jobName string, includeLo
 	}
 
 	// 6. Conditionally fetch logs (only on terminal events to avoid duplicates)
-	if includeLogs && viper.GetBool("ship-logs") {
-		logs, _ = e.fetchPodLogs(pod)
+	if includeLogs && pod != nil && e.collection.config.Get().ShipLogs {
+		logs, _ = e.fetchPodLogs(pod, e.logContainers(pod, cronjob))
 	}
 	return
 }
@@ -168,7 +188,7 @@ func (e EventHandler) FetchAndCheckJobEvent(namespace, jobName string, includeLo
 // owner chain through Job to CronJob, checks whether the CronJob is watched,
 // and retrieves logs. It replaces the old fetchJobByPod + CheckJobIsWatched +
 // FetchObjectsFromPodEvent combination, eliminating redundant API calls.
-func (e EventHandler) FetchAndCheckPodEvent(namespace, podName string, includeLogs bool) (pod *corev1.Pod, logs string, job *v1.Job, cronjob *v1.CronJob, watched bool, err error) {
+func (e *EventHandler) FetchAndCheckPodEvent(namespace, podName string, includeLogs bool) (pod *corev1.Pod, logs string, job *v1.Job, cronjob *v1.CronJob, watched bool, err error) {
 	// 1. Single GET for the Pod
 	pod, err = e.fetchPod(namespace, podName)
 	if err != nil {
@@ -204,9 +224,9 @@ func (e EventHandler) FetchAndCheckPodEvent(namespace, podName string, includeLo
 		return
 	}
 
-	// 5. Check if tracked (in-memory)
+	// 5. Check if tracked or waiting to be synced (in-memory)
 	ownerUID := ownerReference.UID
-	if !e.collection.IsTracked(ownerUID) {
+	if !e.collection.IsTracked(ownerUID) && !e.collection.IsPendingSync(ownerUID) {
 		return
 	}
 	watched = true
@@ -219,55 +239,105 @@ func (e EventHandler) FetchAndCheckPodEvent(namespace, podName string, includeLo
 
 	// 7. Conditionally fetch logs (only on terminal events to avoid duplicates)
 	if includeLogs {
-		logs, _ = e.fetchPodLogs(pod)
+		logs, _ = e.fetchPodLogs(pod, e.logContainers(pod, cronjob))
 	}
 	return
 }
 
-func (e EventHandler) CheckPodFilter(podName string) bool {
-	if e.podFilter == nil {
+func (e *EventHandler) CheckPodFilter(podName string) bool {
+	podFilter := e.collection.config.Get().PodFilterRegexp()
+	if podFilter == nil {
 		return true
 	}
-	return e.podFilter.MatchString(podName)
+	return podFilter.MatchString(podName)
 }
 
-func (e EventHandler) OnAdd(obj interface{}) {
-	event := obj.(*corev1.Event)
-	eventTime := event.LastTimestamp
+// isStaleEvent reports whether an event happened before the watch started, e.g. before this
+// instance of the agent started to run, in which case it is ignored.
+func (e *EventHandler) isStaleEvent(event *corev1.Event) bool {
+	watchStart := e.watchStartTime.Load()
+	if watchStart == nil || !event.LastTimestamp.Before(watchStart) {
+		return false
+	}
+	slog.Info("ignored event from the past",
+		"name", event.InvolvedObject.Name,
+		"kind", event.InvolvedObject.Kind,
+		"eventMessage", event.Message,
+		"eventReason", event.Reason,
+		"eventTime", event.LastTimestamp,
+		"watchStartTime", *watchStart)
+	return true
+}
+
+func (e *EventHandler) OnAdd(obj interface{}) {
+	event, ok := obj.(*corev1.Event)
+	if !ok {
+		slog.Debug("ignoring unexpected object from the event watch", "type", fmt.Sprintf("%T", obj))
+		return
+	}
 
+	// Skip events in namespaces outside the agent's scope before making any API calls for them
+	if !e.collection.namespaceScope.IsNamespaceIncluded(event.InvolvedObject.Namespace) {
+		return
+	}
 	switch event.InvolvedObject.Kind {
 	case "Job":
 		typedEvent := pkg.JobEvent(*event)
 
-		// If this event is an older, stale event--e.g., it happened before this version of the agent started to run--
-		// then ignore the event
-		watchStart := e.watchStartTime.Load()
-		if watchStart != nil && eventTime.Before(watchStart) {
-			slog.Info("ignored event from the past",
-				"name", typedEvent.InvolvedObject.Name,
-				"kind", typedEvent.InvolvedObject.Kind,
-				"eventMessage", typedEvent.Message,
-				"eventReason", typedEvent.Reason,
-				"eventTime", eventTime,
-				"watchStartTime", *watchStart)
+		if e.isStaleEvent(event) {
 			return
 		}
 
-		// Only fetch logs on terminal events (Completed, BackoffLimitExceeded) to avoid
+		// As with pods, check the reason before calling the Kubernetes API for the related objects
+		cfg := e.collection.config.Get()
+		status, err := api.TranslateJobEventReasonToTelemetryEventStatus(&typedEvent, nil, cfg)
+		if err != nil && !e.collection.eventRules.namesReason(typedEvent.Reason) {
+			return
+		}
+
+		// Only fetch logs on terminal events (e.g. Completed, BackoffLimitExceeded) to avoid
 		// shipping duplicate/incomplete logs on SuccessfulCreate (run) events
-		isTerminalEvent := typedEvent.Reason == "Completed" || typedEvent.Reason == "BackoffLimitExceeded"
+		isTerminalEvent := err == nil && (*status == api.Complete || *status == api.Fail)
+
+		// A run already completed when its main containers exited isn't reported again when its
+		// sidecars are stopped, e.g. by its activeDeadlineSeconds
+		if isTerminalEvent && e.exitedPods.isReported(typedEvent.InvolvedObject.UID) {
+			slog.Debug("job already completed with its main containers",
+				"namespace", typedEvent.InvolvedObject.Namespace,
+				"job", typedEvent.InvolvedObject.Name,
+				"eventReason", typedEvent.Reason)
+			return
+		}
 		pod, logs, job, cronjob, watched, err := e.FetchAndCheckJobEvent(typedEvent.InvolvedObject.Namespace, typedEvent.InvolvedObject.Name, isTerminalEvent)
 		if err != nil {
 			slog.Warn("could not fetch objects related to event", "error", err)
 			return
 		}
 		if watched {
+			// The CronJob's own event rules come first
+			status, err := api.TranslateJobEventReasonToTelemetryEventStatus(&typedEvent, e.collection.cronjobEventRules(cronjob), cfg)
+			if err != nil {
+				return
+			}
+			if typedEvent.Reason == "FailedCreate" && *status == api.Fail && !e.failedCreates.report(job.UID) {
+				slog.Debug("job already failed to create its pods",
+					"namespace", job.Namespace,
+					"job", job.Name)
+				return
+			}
 			slog.Info("job event added",
 				"name", typedEvent.InvolvedObject.Name,
 				"kind", typedEvent.InvolvedObject.Kind,
 				"eventMessage", typedEvent.Message,
 				"eventReason", typedEvent.Reason)
-			_ = e.collection.cronitorApi.MakeAndSendTelemetryJobEventAndLogs(&typedEvent, logs, pod, job, cronjob)
+			cronitorApi, err := e.collection.apiFor(cronjob.Namespace)
+			if err != nil {
+				slog.Warn("could not send job event", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+				return
+			}
+			e.collection.forwardTelemetry(cronjob, func() {
+				_ = cronitorApi.MakeAndSendTelemetryJobEventAndLogs(e.collection.context(), &typedEvent, logs, pod, job, cronjob)
+			})
 		}
 
 	case "Pod":
@@ -280,24 +350,28 @@ func (e EventHandler) OnAdd(obj interface{}) {
 			return
 		}
 
-		// If this event is an older, stale event--e.g., it happened before this version of the agent started to run--
-		// then ignore the event
-		watchStart := e.watchStartTime.Load()
-		if watchStart != nil && eventTime.Before(watchStart) {
-			slog.Info("ignored event from the past",
-				"name", typedEvent.InvolvedObject.Name,
-				"kind", typedEvent.InvolvedObject.Kind,
-				"eventMessage", typedEvent.Message,
-				"eventReason", typedEvent.Reason,
-				"eventTime", eventTime,
-				"watchStartTime", *watchStart)
+		if e.isStaleEvent(event) {
 			return
 		}
 
 		// If it's not an event we care about, we don't want to do all of the work of calling the Kubernetes API
 		// to get all of the related objects, which would put heavy load on it given all of the pod events.
-		// So we check early against our pod event list, even though it's somewhat redundant.
-		if _, err := api.TranslatePodEventReasonToTelemetryEventStatus(&typedEvent); err != nil {
+		// So we check early against the agent's event rules, unless a CronJob's own rules name its reason.
+		cfg := e.collection.config.Get()
+		status, err := api.TranslatePodEventReasonToTelemetryEventStatus(&typedEvent, nil, cfg)
+		cronjobRulesNameReason := e.collection.eventRules.namesReason(typedEvent.Reason)
+
+		// Pods kept from starting are checked again once the grace period has passed, rather than
+		// reported right away, as Kubernetes may still get them running. Event rules matching the
+		// event come first, e.g. one ignoring image pull back-offs.
+		stuck := cfg.PendingGracePeriod > 0 && isStuckPodEvent(&typedEvent)
+		if stuck && !cronjobRulesNameReason {
+			if _, ruled := cfg.MatchEventRules("Pod", typedEvent.Reason, typedEvent.Message, nil); !ruled {
+				e.watchStuckPod(typedEvent, cfg.PendingGracePeriod)
+				return
+			}
+		}
+		if err != nil && !cronjobRulesNameReason {
 			return
 		}
 
@@ -306,7 +380,7 @@ func (e EventHandler) OnAdd(obj interface{}) {
 
 		// Only fetch logs on terminal events (BackOff/fail) to avoid
 		// shipping duplicate/incomplete logs on Started (run) events
-		isTerminalEvent := typedEvent.Reason == "BackOff"
+		isTerminalEvent := err == nil && (*status == api.Complete || *status == api.Fail)
 		pod, logs, job, cronjob, watched, err := e.FetchAndCheckPodEvent(podNamespace, podName, isTerminalEvent)
 		if err != nil {
 			switch t := err.(type) {
@@ -339,9 +413,21 @@ func (e EventHandler) OnAdd(obj interface{}) {
 			return
 		}
 
+		// The CronJob's own event rules come first
+		cronjobRules := e.collection.cronjobEventRules(cronjob)
+		if stuck {
+			if _, ruled := cfg.MatchEventRules("Pod", typedEvent.Reason, typedEvent.Message, cronjobRules); !ruled {
+				e.watchStuckPod(typedEvent, cfg.PendingGracePeriod)
+				return
+			}
+		}
+		if _, err := api.TranslatePodEventReasonToTelemetryEventStatus(&typedEvent, cronjobRules, cfg); err != nil {
+			return
+		}
+
 		// By default, skip Pod "Started" → run events because the Job-level SuccessfulCreate
 		// already sends a run event. Users can opt in via the send-pod-start-event annotation.
-		if typedEvent.Reason == "Started" && !pkg.NewCronitorConfigParser(cronjob).SendPodStartEvent() {
+		if typedEvent.Reason == "Started" && !e.collection.configParser(cronjob).SendPodStartEvent() {
 			slog.Debug("pod Started event skipped (opt in via send-pod-start-event annotation)",
 				"namespace", podNamespace,
 				"pod", podName)
@@ -355,7 +441,58 @@ func (e EventHandler) OnAdd(obj interface{}) {
 			"eventReason", typedEvent.Reason,
 			"eventTime", typedEvent.EventTime,
 			"lastTimestamp", typedEvent.LastTimestamp)
-		_ = e.collection.cronitorApi.MakeAndSendTelemetryPodEventAndLogs(&typedEvent, logs, pod, job, cronjob)
+		cronitorApi, err := e.collection.apiFor(cronjob.Namespace)
+		if err != nil {
+			slog.Warn("could not send pod event", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+			return
+		}
+		e.collection.forwardTelemetry(cronjob, func() {
+			_ = cronitorApi.MakeAndSendTelemetryPodEventAndLogs(e.collection.context(), &typedEvent, logs, pod, job, cronjob)
+		})
+
+	case "CronJob":
+		typedEvent := pkg.CronJobEvent(*event)
+
+		if e.isStaleEvent(event) {
+			return
+		}
+
+		// The event names the CronJob itself, so there is nothing to fetch
+		cronjobUID := typedEvent.InvolvedObject.UID
+		if !e.collection.IsTracked(cronjobUID) && !e.collection.IsPendingSync(cronjobUID) {
+			return
+		}
+		cronjob, err := e.fetchCronJob(cronjobUID)
+		if err != nil {
+			slog.Debug("cronjob not found, probably a stale event", "uid", cronjobUID, "error", err)
+			return
+		}
+		state, err := api.TranslateEventToTelemetryEventStatus("CronJob", typedEvent.Reason, typedEvent.Message, e.collection.cronjobEventRules(cronjob), e.collection.config.Get())
+		if err != nil {
+			return
+		}
+		// The missed run checker may have reported the run already, or may report it later
+		if isMissedRunReason(typedEvent.Reason) && !e.collection.missedRuns.claimMissEvent(cronjob, eventTime(event)) {
+			slog.Debug("missed run already reported",
+				"namespace", cronjob.Namespace,
+				"cronjob", cronjob.Name,
+				"eventReason", typedEvent.Reason)
+			return
+		}
+
+		slog.Info("cronjob event added",
+			"namespace", cronjob.Namespace,
+			"name", typedEvent.InvolvedObject.Name,
+			"eventMessage", typedEvent.Message,
+			"eventReason", typedEvent.Reason)
+		cronitorApi, err := e.collection.apiFor(cronjob.Namespace)
+		if err != nil {
+			slog.Warn("could not send cronjob event", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+			return
+		}
+		e.collection.forwardTelemetry(cronjob, func() {
+			_ = cronitorApi.MakeAndSendTelemetryCronJobEvent(e.collection.context(), &typedEvent, *state, cronjob)
+		})
 
 	default:
 		return
@@ -367,9 +504,14 @@ type WatchWrapper struct {
 	eventHandler   *EventHandler
 	stopped        bool
 	workerPoolSize int
+	// done is closed once Start has returned, after the events it took in have been handled
+	done chan struct{}
 }
 
 func (w *WatchWrapper) Start() {
+	if w.done != nil {
+		defer close(w.done)
+	}
 	defer runtime.HandleCrash()
 	slog.Info("the jobs watcher is starting...")
 
@@ -395,18 +537,26 @@ func (w *WatchWrapper) Stop() {
 	slog.Info("the jobs watcher is stopping...")
 	w.stopped = true
 	w.watcher.Stop()
+	// Pods whose grace period hasn't passed yet aren't checked anymore
+	w.eventHandler.stuckPods.stop()
 }
 
-func NewJobsEventWatcher(collection *CronJobCollection) *WatchWrapper {
-	clientset := collection.clientset
-	namespace := corev1.NamespaceAll
-	if collection.kubernetesNamespace != "" {
-		namespace = collection.kubernetesNamespace
+// wait waits until ctx is done for the watcher to stop and the events it took in to be handled,
+// including the stuck Pod checks already running, and reports whether they were.
+func (w *WatchWrapper) wait(ctx context.Context) bool {
+	if w.done != nil && !waitUntil(ctx, w.done) {
+		return false
 	}
+	return w.eventHandler.stuckPods.wait(ctx)
+}
+
+// NewJobsEventWatcher watches the events of a single namespace, or of every namespace
+// when given metav1.NamespaceAll.
+func NewJobsEventWatcher(collection *CronJobCollection, namespace string) (*WatchWrapper, error) {
+	clientset := collection.clientset
 
 	eventHandler := &EventHandler{
 		collection: collection,
-		podFilter:  createPodFilter(),
 	}
 	// Initialize watchStartTime
 	now := meta_v1.Now()
@@ -416,17 +566,18 @@ func NewJobsEventWatcher(collection *CronJobCollection) *WatchWrapper {
 		// Update watchStartTime when the watch restarts
 		now := meta_v1.Now()
 		eventHandler.watchStartTime.Store(&now)
-		return clientset.CoreV1().Events(namespace).Watch(context.Background(), meta_v1.ListOptions{})
+		return clientset.CoreV1().Events(namespace).Watch(collection.context(), meta_v1.ListOptions{})
 	}
 
 	watcher, err := watch.NewRetryWatcher("1", &cache.ListWatch{WatchFunc: watchFunc})
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return &WatchWrapper{
 		watcher:        watcher,
 		eventHandler:   eventHandler,
 		workerPoolSize: 4,
-	}
+		done:           make(chan struct{}),
+	}, nil
 }
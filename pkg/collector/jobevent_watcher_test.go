@@ -1,14 +1,14 @@
 package collector
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/cronitorio/cronitor-kubernetes/pkg"
 	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
-	"github.com/spf13/viper"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
 	v1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -313,8 +313,7 @@ func TestFetchAndCheckJobEvent_SkipsLogsOnNonTerminalEvent(t *testing.T) {
 	watched := map[types.UID]*v1.CronJob{cj.UID: cj}
 	handler := newTestEventHandler([]runtime.Object{job, pod}, watched)
 
-	viper.Set("ship-logs", true)
-	defer viper.Set("ship-logs", "")
+	handler.collection.config = config.NewStore(&config.Config{ShipLogs: true})
 
 	// includeLogs=false (simulating SuccessfulCreate/run event)
 	_, logs, _, _, isWatched, err := handler.FetchAndCheckJobEvent("default", "nologs-job", false)
@@ -399,7 +398,7 @@ func TestOnAdd_PodStartedEvent_EnabledByAnnotation(t *testing.T) {
 	}
 
 	// With the annotation set to "true", SendPodStartEvent should return true
-	parser := pkg.NewCronitorConfigParser(gotCJ)
+	parser := handler.collection.configParser(gotCJ)
 	if !parser.SendPodStartEvent() {
 		t.Error("expected SendPodStartEvent()=true with annotation set")
 	}
@@ -450,9 +449,7 @@ func TestFetchAndCheckJobEvent_MinimalAPICalls(t *testing.T) {
 	}
 	handler := &EventHandler{collection: collection}
 
-	// ship-logs=false so no log fetch call
-	viper.Set("ship-logs", false)
-	defer viper.Set("ship-logs", "")
+	// ship-logs is off by default, so no log fetch call
 
 	_, _, _, _, watched, err := handler.FetchAndCheckJobEvent("default", "countjob", false)
 	if err != nil {
@@ -733,3 +730,57 @@ func TestWorkerPool_GracefulShutdown(t *testing.T) {
 		t.Error("expected stopped=true after Stop()")
 	}
 }
+
+func TestCheckPodFilter_UsesConfig(t *testing.T) {
+	handler := newTestEventHandler(nil, map[types.UID]*v1.CronJob{})
+	if !handler.CheckPodFilter("anything") {
+		t.Error("expected every pod to pass without a pod filter")
+	}
+
+	cfg := &config.Config{PodFilter: "^job-"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler.collection.config = config.NewStore(cfg)
+	if !handler.CheckPodFilter("job-123") || handler.CheckPodFilter("other-123") {
+		t.Error("expected the configured pod filter to be applied")
+	}
+}
+
+func TestOnAdd_IgnoresNonEventObjects(t *testing.T) {
+	handler := newTestEventHandler(nil, map[types.UID]*v1.CronJob{})
+
+	// Watches can deliver *metav1.Status objects on errors; these must not panic
+	handler.OnAdd(&metav1.Status{Status: metav1.StatusFailure})
+}
+
+func TestWatchWrapper_WaitsForHandledEvents(t *testing.T) {
+	handler := &EventHandler{
+		collection: &CronJobCollection{
+			cronitorApi: &api.CronitorApi{DryRun: true},
+			cronjobs:    map[types.UID]*v1.CronJob{},
+		},
+	}
+	fakeWatcher := apiWatch.NewFake()
+	wrapper := &WatchWrapper{
+		watcher:        fakeWatcher,
+		eventHandler:   handler,
+		workerPoolSize: 2,
+		done:           make(chan struct{}),
+	}
+	go wrapper.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if wrapper.wait(ctx) {
+		t.Fatal("expected wait to give up while the watcher is running")
+	}
+
+	fakeWatcher.Action(apiWatch.Added, &corev1.Event{InvolvedObject: corev1.ObjectReference{Kind: "Unknown"}})
+	wrapper.Stop()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !wrapper.wait(ctx) {
+		t.Fatal("expected wait to return once the watcher stopped and its events were handled")
+	}
+}
@@ -0,0 +1,242 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+	// CronJob time zones must resolve in images without zoneinfo, such as the agent's
+	_ "time/tzdata"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/robfig/cron/v3"
+	v1 "k8s.io/api/batch/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// missedRunCheckInterval is how often tracked CronJobs are checked for missed runs.
+const missedRunCheckInterval = time.Minute
+
+// missedRunLookback bounds how far back missed runs are looked for, e.g. after the agent
+// restarts; older ones were reported while they happened, or are left to Cronitor.
+const missedRunLookback = 24 * time.Hour
+
+// scheduledTimestampAnnotation holds, on Jobs created by the CronJob controller of Kubernetes
+// 1.28 and later, the time the Job was scheduled for.
+const scheduledTimestampAnnotation = "batch.kubernetes.io/cronjob-scheduled-timestamp"
+
+// missedRunChecker periodically compares the expected fire times of tracked CronJobs with the
+// runs Kubernetes actually started, and reports those that are overdue by the configured margin.
+// That catches runs that never produce a Job, and so no event, such as when the CronJob
+// controller is down or behind.
+type missedRunChecker struct {
+	coll *CronJobCollection
+	// cronjobs lists every watched CronJob, from the informer caches, so that their status is current
+	cronjobs func() []*v1.CronJob
+	// reported holds the latest missed fire time reported for each CronJob, by the checker or
+	// by an event Kubernetes recorded for the miss
+	mu       sync.Mutex
+	reported map[types.UID]time.Time
+	// started is when the checker was created. Runs that were already overdue by then may
+	// have been reported by a previous instance of the agent, so they aren't reported again.
+	started time.Time
+}
+
+func newMissedRunChecker(coll *CronJobCollection, cronjobs func() []*v1.CronJob) *missedRunChecker {
+	return &missedRunChecker{coll: coll, cronjobs: cronjobs, reported: make(map[types.UID]time.Time), started: time.Now()}
+}
+
+// run checks for missed runs every missedRunCheckInterval until stop is closed.
+func (m *missedRunChecker) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(missedRunCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		m.check(m.coll.context(), time.Now())
+	}
+}
+
+// check reports the runs of tracked CronJobs that should have started by now, but haven't.
+func (m *missedRunChecker) check(ctx context.Context, now time.Time) {
+	cfg := m.coll.config.Get()
+	if cfg.MissedRunMargin <= 0 {
+		return
+	}
+	state := api.Fail
+	if cfg.MissedRunAction == "log" {
+		state = api.Logs
+	}
+
+	seen := make(map[types.UID]bool)
+	jobsByNamespace := make(map[string][]v1.Job)
+	for _, cronjob := range m.cronjobs() {
+		if !m.coll.IsTracked(cronjob.UID) {
+			continue
+		}
+		seen[cronjob.UID] = true
+		if cronjob.Spec.Suspend != nil && *cronjob.Spec.Suspend {
+			continue
+		}
+		// Runs skipped while another is still active are intended
+		if cronjob.Spec.ConcurrencyPolicy == v1.ForbidConcurrent && len(cronjob.Status.Active) > 0 {
+			continue
+		}
+
+		schedule, err := parseCronJobSchedule(cronjob)
+		if err != nil {
+			slog.Debug("could not parse CronJob schedule to check for missed runs",
+				"namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+			continue
+		}
+		lastRun := cronjob.CreationTimestamp.Time
+		if cronjob.Status.LastScheduleTime != nil && cronjob.Status.LastScheduleTime.After(lastRun) {
+			lastRun = cronjob.Status.LastScheduleTime.Time
+		}
+		missed, ok := lastMissedRun(schedule, lastRun, now, cfg.MissedRunMargin)
+		if !ok || !missed.After(m.lastReported(cronjob.UID)) {
+			continue
+		}
+
+		// The CronJob's status may lag behind, so confirm with the Jobs it owns
+		jobs, listed := jobsByNamespace[cronjob.Namespace]
+		if !listed {
+			list, err := m.coll.clientset.BatchV1().Jobs(cronjob.Namespace).List(ctx, meta_v1.ListOptions{})
+			if err != nil {
+				slog.Warn("could not list Jobs to check for missed runs", "namespace", cronjob.Namespace, "error", err)
+				continue
+			}
+			jobs = list.Items
+			jobsByNamespace[cronjob.Namespace] = jobs
+		}
+		if latest := latestJobScheduleTime(cronjob, jobs); latest.After(lastRun) {
+			if missed, ok = lastMissedRun(schedule, latest, now, cfg.MissedRunMargin); !ok {
+				continue
+			}
+		}
+
+		if !m.claim(cronjob.UID, missed) || !missed.Add(cfg.MissedRunMargin).After(m.started) {
+			continue
+		}
+		m.report(ctx, cronjob, state, missed)
+	}
+
+	m.mu.Lock()
+	for uid := range m.reported {
+		if !seen[uid] {
+			delete(m.reported, uid)
+		}
+	}
+	m.mu.Unlock()
+}
+
+func (m *missedRunChecker) lastReported(uid types.UID) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reported[uid]
+}
+
+// claim records the run of a CronJob scheduled at fireTime as reported, and reports whether it
+// wasn't already.
+func (m *missedRunChecker) claim(uid types.UID, fireTime time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !fireTime.After(m.reported[uid]) {
+		return false
+	}
+	m.reported[uid] = fireTime
+	return true
+}
+
+// isMissedRunReason reports whether a CronJob event with reason is about runs it missed.
+func isMissedRunReason(reason string) bool {
+	return reason == "MissSchedule" || reason == "TooManyMissedTimes"
+}
+
+// claimMissEvent records the run a CronJob's MissSchedule or TooManyMissedTimes event recorded
+// at eventTime is about, the latest scheduled by then, as reported, and reports whether it
+// wasn't already. That keeps a miss from being reported both for the event and by the checker.
+func (m *missedRunChecker) claimMissEvent(cronjob *v1.CronJob, eventTime time.Time) bool {
+	if m == nil {
+		return true
+	}
+	schedule, err := parseCronJobSchedule(cronjob)
+	if err != nil {
+		return true
+	}
+	missed, ok := lastMissedRun(schedule, eventTime.Add(-missedRunLookback), eventTime, 0)
+	if !ok {
+		return true
+	}
+	return m.claim(cronjob.UID, missed)
+}
+
+func (m *missedRunChecker) report(ctx context.Context, cronjob *v1.CronJob, state api.TelemetryEventStatus, missed time.Time) {
+	slog.Info("cronjob missed a scheduled run",
+		"namespace", cronjob.Namespace,
+		"cronjob", cronjob.Name,
+		"scheduledTime", missed)
+	cronitorApi, err := m.coll.apiFor(cronjob.Namespace)
+	if err != nil {
+		slog.Warn("could not report missed run", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+		return
+	}
+	message := fmt.Sprintf("missed schedule: no Job was started for the run scheduled at %s", missed.UTC().Format(time.RFC3339))
+	m.coll.forwardTelemetry(cronjob, func() {
+		if err := cronitorApi.MakeAndSendTelemetryMissedRun(ctx, cronjob, state, missed, message); err != nil {
+			slog.Error("could not report missed run", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+		}
+	})
+}
+
+// parseCronJobSchedule parses the schedule of a CronJob in its time zone, which defaults to UTC
+// like the controller manager's usually does.
+func parseCronJobSchedule(cronjob *v1.CronJob) (cron.Schedule, error) {
+	spec := cronjob.Spec.Schedule
+	if cronjob.Spec.TimeZone != nil && *cronjob.Spec.TimeZone != "" && !strings.Contains(spec, "TZ=") {
+		spec = "CRON_TZ=" + *cronjob.Spec.TimeZone + " " + spec
+	}
+	return cron.ParseStandard(spec)
+}
+
+// lastMissedRun returns the latest fire time of schedule after lastRun that is overdue by margin
+// at now, if there is one.
+func lastMissedRun(schedule cron.Schedule, lastRun, now time.Time, margin time.Duration) (time.Time, bool) {
+	deadline := now.Add(-margin)
+	if earliest := deadline.Add(-missedRunLookback); lastRun.Before(earliest) {
+		lastRun = earliest
+	}
+	var missed time.Time
+	for next := schedule.Next(lastRun); !next.IsZero() && !next.After(deadline); next = schedule.Next(next) {
+		missed = next
+	}
+	return missed, !missed.IsZero()
+}
+
+// latestJobScheduleTime returns the time the latest of the cronjob's Jobs was scheduled for,
+// or created at if it doesn't say.
+func latestJobScheduleTime(cronjob *v1.CronJob, jobs []v1.Job) time.Time {
+	var latest time.Time
+	for _, job := range jobs {
+		owner := meta_v1.GetControllerOf(&job)
+		if owner == nil || owner.UID != cronjob.UID {
+			continue
+		}
+		scheduled := job.CreationTimestamp.Time
+		if value, ok := job.Annotations[scheduledTimestampAnnotation]; ok {
+			if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+				scheduled = parsed
+			}
+		}
+		if scheduled.After(latest) {
+			latest = scheduled
+		}
+	}
+	return latest
+}
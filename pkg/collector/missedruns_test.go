@@ -0,0 +1,209 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/cronitortest"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLastMissedRun(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 3, 0, 0, time.UTC)
+	tokyo := "Asia/Tokyo"
+	tests := []struct {
+		name     string
+		schedule string
+		timeZone *string
+		lastRun  time.Time
+		margin   time.Duration
+		expected time.Time
+	}{
+		{"on time", "0 * * * *", nil, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC), time.Minute, time.Time{}},
+		{"within margin", "0 * * * *", nil, time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC), 5 * time.Minute, time.Time{}},
+		{"overdue", "0 * * * *", nil, time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC), time.Minute, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)},
+		{"latest of several", "*/10 * * * *", nil, time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC), time.Minute, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)},
+		{"bounded lookback", "0 * * * *", nil, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Minute, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)},
+		// 21:00 in Tokyo is 12:00 UTC; in UTC, the run isn't due until 21:00
+		{"time zone", "0 21 * * *", &tokyo, time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC), time.Minute, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)},
+		{"not due in UTC", "0 21 * * *", nil, time.Date(2024, 3, 9, 21, 0, 0, 0, time.UTC), time.Minute, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cronjob := createTestCronJob("job", "default", "uid", tt.schedule)
+			cronjob.Spec.TimeZone = tt.timeZone
+			schedule, err := parseCronJobSchedule(cronjob)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			missed, ok := lastMissedRun(schedule, tt.lastRun, now, tt.margin)
+			if ok != !tt.expected.IsZero() || !missed.Equal(tt.expected) {
+				t.Errorf("expected missed run %v, got %v (%t)", tt.expected, missed, ok)
+			}
+		})
+	}
+}
+
+func newMissedRunTestChecker(t *testing.T, server *cronitortest.Server, cfg *config.Config, cronjobs []*v1.CronJob, objects ...runtime.Object) *missedRunChecker {
+	t.Helper()
+	cfg.HostnameOverride = server.URL
+	store := config.NewStore(cfg)
+	coll := &CronJobCollection{
+		clientset:   fake.NewSimpleClientset(objects...),
+		cronitorApi: &api.CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: store},
+		cronjobs:    make(map[types.UID]*v1.CronJob),
+		config:      store,
+	}
+	for _, cronjob := range cronjobs {
+		coll.cronjobs[cronjob.UID] = cronjob
+	}
+	checker := newMissedRunChecker(coll, func() []*v1.CronJob { return cronjobs })
+	// The tests check at fixed times, after the agent "started"
+	checker.started = time.Time{}
+	return checker
+}
+
+func hourlyCronJob(name string, lastScheduleTime time.Time) *v1.CronJob {
+	cronjob := createTestCronJob(name, "default", name+"-uid", "0 * * * *")
+	cronjob.CreationTimestamp = metav1.NewTime(lastScheduleTime.Add(-24 * time.Hour))
+	cronjob.Status.LastScheduleTime = &metav1.Time{Time: lastScheduleTime}
+	return cronjob
+}
+
+func TestMissedRunChecker_ReportsEachMissedRunOnce(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	now := time.Date(2024, 3, 10, 12, 10, 0, 0, time.UTC)
+	late := hourlyCronJob("late", time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC))
+	onTime := hourlyCronJob("on-time", time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+	checker := newMissedRunTestChecker(t, server, &config.Config{MissedRunMargin: 5 * time.Minute}, []*v1.CronJob{late, onTime})
+
+	checker.check(context.Background(), now)
+	checker.check(context.Background(), now.Add(time.Minute))
+	pings := server.ExpectPings(t, "late-uid", "fail")
+	if !strings.Contains(pings[0].Message, "missed schedule") || !strings.Contains(pings[0].Message, "2024-03-10T12:00:00Z") {
+		t.Errorf("expected the failure to name the missed run, got %q", pings[0].Message)
+	}
+	if pings[0].Series != "late-uid-missed-1710072000" {
+		t.Errorf("unexpected series %q", pings[0].Series)
+	}
+	server.ExpectPings(t, "on-time-uid")
+
+	// The next missed run is reported too
+	checker.check(context.Background(), now.Add(time.Hour))
+	server.ExpectPings(t, "late-uid", "fail", "fail")
+}
+
+func TestMissedRunChecker_SkipsRunsOverdueBeforeStart(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	now := time.Date(2024, 3, 10, 12, 10, 0, 0, time.UTC)
+	late := hourlyCronJob("late", time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC))
+	checker := newMissedRunTestChecker(t, server, &config.Config{MissedRunMargin: 5 * time.Minute}, []*v1.CronJob{late})
+	// Restarted after the 12:00 run was overdue, which the previous agent may have reported
+	checker.started = now.Add(-time.Minute)
+
+	checker.check(context.Background(), now)
+	server.ExpectPings(t, "late-uid")
+
+	// Runs that become overdue after the restart are reported
+	checker.check(context.Background(), now.Add(time.Hour))
+	server.ExpectPings(t, "late-uid", "fail")
+}
+
+func TestMissedRunChecker_DedupesMissEvents(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	now := time.Date(2024, 3, 10, 12, 10, 0, 0, time.UTC)
+	cfg := &config.Config{MissedRunMargin: 5 * time.Minute}
+
+	// A miss Kubernetes recorded an event for isn't reported again by the checker
+	late := hourlyCronJob("late", time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC))
+	checker := newMissedRunTestChecker(t, server, cfg, []*v1.CronJob{late})
+	if !checker.claimMissEvent(late, time.Date(2024, 3, 10, 12, 1, 0, 0, time.UTC)) {
+		t.Error("expected the first report of the miss to be claimed")
+	}
+	checker.check(context.Background(), now)
+	server.ExpectPings(t, "late-uid")
+
+	// Nor is an event for a miss the checker already reported
+	checker = newMissedRunTestChecker(t, server, cfg, []*v1.CronJob{late})
+	checker.check(context.Background(), now)
+	server.ExpectPings(t, "late-uid", "fail")
+	if checker.claimMissEvent(late, now.Add(time.Minute)) {
+		t.Error("expected the event for a reported miss not to be claimed")
+	}
+}
+
+func TestMissedRunChecker_ConfirmsWithJobs(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	now := time.Date(2024, 3, 10, 12, 10, 0, 0, time.UTC)
+	cronjob := hourlyCronJob("stale", time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC))
+	controller := true
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:            "stale-28500000",
+		Namespace:       "default",
+		OwnerReferences: []metav1.OwnerReference{{UID: cronjob.UID, Controller: &controller}},
+		Annotations:     map[string]string{scheduledTimestampAnnotation: "2024-03-10T12:00:00Z"},
+	}}
+	checker := newMissedRunTestChecker(t, server, &config.Config{MissedRunMargin: 5 * time.Minute}, []*v1.CronJob{cronjob}, job)
+
+	// The CronJob's status is behind, but the Job shows the run started
+	checker.check(context.Background(), now)
+	server.ExpectPings(t, "stale-uid")
+}
+
+func TestMissedRunChecker_Skips(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 10, 0, 0, time.UTC)
+	lastRun := time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC)
+	suspend := true
+	suspended := hourlyCronJob("suspended", lastRun)
+	suspended.Spec.Suspend = &suspend
+	forbidden := hourlyCronJob("forbidden", lastRun)
+	forbidden.Spec.ConcurrencyPolicy = v1.ForbidConcurrent
+	forbidden.Status.Active = []corev1.ObjectReference{{Name: "forbidden-28499940"}}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     *config.Config
+		cronjob *v1.CronJob
+		tracked bool
+	}{
+		{"disabled", &config.Config{}, hourlyCronJob("disabled", lastRun), true},
+		{"untracked", &config.Config{MissedRunMargin: time.Minute}, hourlyCronJob("untracked", lastRun), false},
+		{"suspended", &config.Config{MissedRunMargin: time.Minute}, suspended, true},
+		{"still running", &config.Config{MissedRunMargin: time.Minute}, forbidden, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := cronitortest.NewServer()
+			defer server.Close()
+			checker := newMissedRunTestChecker(t, server, tc.cfg, []*v1.CronJob{tc.cronjob})
+			if !tc.tracked {
+				delete(checker.coll.cronjobs, tc.cronjob.UID)
+			}
+			checker.check(context.Background(), now)
+			server.ExpectPings(t, string(tc.cronjob.UID))
+		})
+	}
+}
+
+func TestMissedRunChecker_LogAction(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	cronjob := hourlyCronJob("logged", time.Date(2024, 3, 10, 11, 0, 0, 0, time.UTC))
+	cfg := &config.Config{MissedRunMargin: 5 * time.Minute, MissedRunAction: "log"}
+	checker := newMissedRunTestChecker(t, server, cfg, []*v1.CronJob{cronjob})
+
+	checker.check(context.Background(), time.Date(2024, 3, 10, 12, 10, 0, 0, time.UTC))
+	server.ExpectPings(t, "logged-uid", "logs")
+}
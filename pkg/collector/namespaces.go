@@ -0,0 +1,226 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// namespacePattern matches namespace names, either with a glob (e.g. "kube-*")
+// or, if the pattern is wrapped in slashes, a regular expression (e.g. "/^kube-.*$/").
+type namespacePattern struct {
+	glob  string
+	regex *regexp.Regexp
+}
+
+func newNamespacePattern(pattern string) (namespacePattern, error) {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return namespacePattern{}, fmt.Errorf("invalid namespace regular expression %q: %w", pattern, err)
+		}
+		return namespacePattern{regex: regex}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return namespacePattern{}, fmt.Errorf("invalid namespace glob %q: %w", pattern, err)
+	}
+	return namespacePattern{glob: pattern}, nil
+}
+
+func (p namespacePattern) Matches(namespace string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(namespace)
+	}
+	matched, _ := path.Match(p.glob, namespace)
+	return matched
+}
+
+// NamespaceScope decides which namespaces the agent monitors CronJobs in, and keeps track
+// of namespace labels and annotations as namespaces are created or changed.
+// A nil *NamespaceScope includes every namespace.
+type NamespaceScope struct {
+	// namespaces is an allow list of namespaces. Empty means all namespaces.
+	namespaces []string
+	// exclude is a deny list of namespace patterns, applied after the allow list.
+	exclude []namespacePattern
+	// selector, when not empty, limits monitoring to namespaces whose labels match it.
+	selector labels.Selector
+
+	namespaceMetaMu sync.RWMutex
+	namespaceMeta   map[string]meta_v1.ObjectMeta
+	// namespacesWatchable is set when the agent may list and watch Namespace objects
+	namespacesWatchable bool
+}
+
+func NewNamespaceScope(namespaces []string, exclude []string, selector string) (*NamespaceScope, error) {
+	scope := &NamespaceScope{
+		namespaces:    namespaces,
+		selector:      labels.Everything(),
+		namespaceMeta: make(map[string]meta_v1.ObjectMeta),
+	}
+	for _, pattern := range exclude {
+		compiled, err := newNamespacePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		scope.exclude = append(scope.exclude, compiled)
+	}
+	if selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace selector %q: %w", selector, err)
+		}
+		scope.selector = parsed
+	}
+	return scope, nil
+}
+
+// WatchedNamespaces returns the namespaces that need their own watches: either the
+// allow list or, when every namespace is eligible, just metav1.NamespaceAll.
+func (s *NamespaceScope) WatchedNamespaces() []string {
+	if s == nil || len(s.namespaces) == 0 {
+		return []string{meta_v1.NamespaceAll}
+	}
+	return s.namespaces
+}
+
+// usesSelector reports whether namespace labels need to be tracked.
+func (s *NamespaceScope) usesSelector() bool {
+	return s != nil && s.selector != nil && !s.selector.Empty()
+}
+
+func (s *NamespaceScope) IsNamespaceIncluded(namespace string) bool {
+	if s == nil {
+		return true
+	}
+
+	if len(s.namespaces) > 0 {
+		allowed := false
+		for _, ns := range s.namespaces {
+			if ns == namespace {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for _, pattern := range s.exclude {
+		if pattern.Matches(namespace) {
+			return false
+		}
+	}
+
+	if s.usesSelector() {
+		s.namespaceMetaMu.RLock()
+		meta, known := s.namespaceMeta[namespace]
+		s.namespaceMetaMu.RUnlock()
+		// A namespace we haven't seen yet is included once its creation is observed
+		if !known || !s.selector.Matches(labels.Set(meta.Labels)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NamespaceAnnotations returns the annotations of a namespace, if it has been seen.
+// CronJobs inherit namespace-level defaults through it.
+func (s *NamespaceScope) NamespaceAnnotations(namespace string) (map[string]string, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.namespaceMetaMu.RLock()
+	defer s.namespaceMetaMu.RUnlock()
+	meta, known := s.namespaceMeta[namespace]
+	return meta.Annotations, known
+}
+
+// NamespaceLabels returns the labels of a namespace, if it has been seen.
+func (s *NamespaceScope) NamespaceLabels(namespace string) (map[string]string, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.namespaceMetaMu.RLock()
+	defer s.namespaceMetaMu.RUnlock()
+	meta, known := s.namespaceMeta[namespace]
+	return meta.Labels, known
+}
+
+// setNamespace records a namespace's labels and annotations, returning the previously
+// recorded annotations and whether the namespace was included before and after the change.
+func (s *NamespaceScope) setNamespace(namespace *corev1.Namespace) (previousAnnotations map[string]string, wasIncluded bool, nowIncluded bool) {
+	wasIncluded = s.IsNamespaceIncluded(namespace.Name)
+	s.namespaceMetaMu.Lock()
+	previousAnnotations = s.namespaceMeta[namespace.Name].Annotations
+	s.namespaceMeta[namespace.Name] = meta_v1.ObjectMeta{
+		Name:        namespace.Name,
+		Labels:      namespace.Labels,
+		Annotations: namespace.Annotations,
+	}
+	s.namespaceMetaMu.Unlock()
+	nowIncluded = s.IsNamespaceIncluded(namespace.Name)
+	return
+}
+
+func (s *NamespaceScope) deleteNamespace(namespace string) {
+	s.namespaceMetaMu.Lock()
+	delete(s.namespaceMeta, namespace)
+	s.namespaceMetaMu.Unlock()
+}
+
+// loadNamespaces fetches every namespace so that the selector and namespace-level defaults
+// can be evaluated before the namespace watch has started. Without cluster-wide access to
+// namespaces, it falls back to fetching each namespace of the allow list individually,
+// in which case namespace changes are only picked up when the agent restarts.
+func (s *NamespaceScope) loadNamespaces(ctx context.Context, clientset kubernetes.Interface) error {
+	namespaceList, err := clientset.CoreV1().Namespaces().List(ctx, meta_v1.ListOptions{})
+	if err == nil {
+		for i := range namespaceList.Items {
+			s.setNamespace(&namespaceList.Items[i])
+		}
+		s.namespacesWatchable = true
+		return nil
+	}
+	if s.usesSelector() {
+		return fmt.Errorf("could not list namespaces for the namespace selector: %w", err)
+	}
+
+	for _, name := range s.namespaces {
+		namespace, getErr := clientset.CoreV1().Namespaces().Get(ctx, name, meta_v1.GetOptions{})
+		if getErr != nil {
+			return fmt.Errorf("could not read namespace %s: %w", name, getErr)
+		}
+		s.setNamespace(namespace)
+	}
+	return nil
+}
+
+// hasCronitorAnnotationChanges reports whether any k8s.cronitor.io annotation differs
+// between two sets of namespace annotations.
+func hasCronitorAnnotationChanges(before map[string]string, after map[string]string) bool {
+	isCronitorAnnotation := func(key string) bool {
+		return strings.HasPrefix(key, "k8s.cronitor.io/")
+	}
+	for key, value := range after {
+		if isCronitorAnnotation(key) && before[key] != value {
+			return true
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; isCronitorAnnotation(key) && !ok {
+			return true
+		}
+	}
+	return false
+}
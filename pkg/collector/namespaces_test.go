@@ -0,0 +1,250 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testNamespace(name string, namespaceLabels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: namespaceLabels,
+		},
+	}
+}
+
+func TestNamespaceScope_NilIncludesEverything(t *testing.T) {
+	var scope *NamespaceScope
+	if !scope.IsNamespaceIncluded("anything") {
+		t.Error("expected a nil scope to include every namespace")
+	}
+	if namespaces := scope.WatchedNamespaces(); len(namespaces) != 1 || namespaces[0] != metav1.NamespaceAll {
+		t.Errorf("expected a nil scope to watch all namespaces, got %v", namespaces)
+	}
+}
+
+func TestNamespaceScope_AllowAndDenyLists(t *testing.T) {
+	tests := []struct {
+		name       string
+		namespaces []string
+		exclude    []string
+		namespace  string
+		expected   bool
+	}{
+		{"no lists", nil, nil, "default", true},
+		{"in allow list", []string{"team-a", "team-b"}, nil, "team-b", true},
+		{"not in allow list", []string{"team-a", "team-b"}, nil, "team-c", false},
+		{"glob deny", nil, []string{"kube-*"}, "kube-system", false},
+		{"glob deny does not match", nil, []string{"kube-*"}, "default", true},
+		{"regex deny", nil, []string{"/^(kube|istio)-/"}, "istio-system", false},
+		{"regex deny does not match", nil, []string{"/^(kube|istio)-/"}, "my-kube-app", true},
+		{"deny wins over allow", []string{"kube-system"}, []string{"kube-*"}, "kube-system", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := NewNamespaceScope(tt.namespaces, tt.exclude, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := scope.IsNamespaceIncluded(tt.namespace); got != tt.expected {
+				t.Errorf("IsNamespaceIncluded(%q) = %v, expected %v", tt.namespace, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNamespaceScope_InvalidPatterns(t *testing.T) {
+	if _, err := NewNamespaceScope(nil, []string{"/kube-(/"}, ""); err == nil {
+		t.Error("expected an error for an invalid regular expression")
+	}
+	if _, err := NewNamespaceScope(nil, []string{"kube-["}, ""); err == nil {
+		t.Error("expected an error for an invalid glob")
+	}
+	if _, err := NewNamespaceScope(nil, nil, "cronitor in (enabled"); err == nil {
+		t.Error("expected an error for an invalid label selector")
+	}
+}
+
+func TestNamespaceScope_SelectorFollowsRelabeling(t *testing.T) {
+	scope, err := NewNamespaceScope(nil, nil, "cronitor=enabled")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if scope.IsNamespaceIncluded("team-a") {
+		t.Error("expected a namespace with unknown labels to be excluded")
+	}
+
+	_, wasIncluded, nowIncluded := scope.setNamespace(testNamespace("team-a", map[string]string{"cronitor": "enabled"}))
+	if wasIncluded || !nowIncluded {
+		t.Errorf("expected labeling to include the namespace, got was=%v now=%v", wasIncluded, nowIncluded)
+	}
+
+	_, wasIncluded, nowIncluded = scope.setNamespace(testNamespace("team-a", map[string]string{"cronitor": "disabled"}))
+	if !wasIncluded || nowIncluded {
+		t.Errorf("expected relabeling to exclude the namespace, got was=%v now=%v", wasIncluded, nowIncluded)
+	}
+}
+
+func TestLoadAllExistingCronJobs_RespectsNamespaceScope(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	objects := []runtime.Object{
+		testNamespace("team-a", map[string]string{"cronitor": "enabled"}),
+		testNamespace("team-b", nil),
+		testNamespace("kube-system", map[string]string{"cronitor": "enabled"}),
+		createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *"),
+		createTestCronJob("job-b", "team-b", "uid-b", "*/5 * * * *"),
+		createTestCronJob("job-kube", "kube-system", "uid-kube", "*/5 * * * *"),
+	}
+
+	scope, err := NewNamespaceScope(nil, []string{"kube-*"}, "cronitor=enabled")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL})
+	coll := &CronJobCollection{
+		clientset:      fake.NewSimpleClientset(objects...),
+		serverVersion:  &version.Info{Major: "1", Minor: "25"},
+		cronitorApi:    &api.CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: cfg},
+		cronjobs:       make(map[types.UID]*v1.CronJob),
+		namespaceScope: scope,
+		config:         cfg,
+	}
+
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !coll.IsTracked("uid-a") {
+		t.Error("expected the CronJob in the selected namespace to be tracked")
+	}
+	if coll.IsTracked("uid-b") {
+		t.Error("expected the CronJob in the unlabeled namespace not to be tracked")
+	}
+	if coll.IsTracked("uid-kube") {
+		t.Error("expected the CronJob in the excluded namespace not to be tracked")
+	}
+}
+
+func TestLoadAllExistingCronJobs_InheritsNamespaceAnnotations(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	namespace := testNamespace("team-a", nil)
+	namespace.Annotations = map[string]string{"k8s.cronitor.io/group": "team-a-jobs"}
+
+	scope, err := NewNamespaceScope(nil, nil, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	coll := createTestCollection(mockServer)
+	coll.clientset = fake.NewSimpleClientset(namespace, createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *"))
+	coll.serverVersion = &version.Info{Major: "1", Minor: "25"}
+	coll.namespaceScope = scope
+
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(mockServer.lastBody, `"group":"team-a-jobs"`) {
+		t.Errorf("expected the monitor to inherit the namespace group, got body %s", mockServer.lastBody)
+	}
+}
+
+func TestResyncCronJob_FollowsNamespaceAnnotations(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	namespace := testNamespace("team-a", nil)
+	scope, err := NewNamespaceScope(nil, nil, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	scope.setNamespace(namespace)
+
+	coll := createTestCollection(mockServer)
+	coll.namespaceScope = scope
+	cronjob := createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *")
+	onAdd(coll, cronjob)
+	if !coll.IsTracked("uid-a") {
+		t.Fatal("expected the CronJob to be tracked")
+	}
+
+	relabeled := namespace.DeepCopy()
+	relabeled.Annotations = map[string]string{"k8s.cronitor.io/default-behavior": "exclude"}
+	previous, _, _ := scope.setNamespace(relabeled)
+	if !hasCronitorAnnotationChanges(previous, relabeled.Annotations) {
+		t.Fatal("expected the default-behavior change to be detected")
+	}
+	coll.resyncCronJob(cronjob)
+
+	if coll.IsTracked("uid-a") {
+		t.Error("expected the CronJob to stop being tracked once its namespace excludes it by default")
+	}
+}
+
+func TestHasCronitorAnnotationChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   map[string]string
+		after    map[string]string
+		expected bool
+	}{
+		{"no annotations", nil, nil, false},
+		{"unrelated annotation", nil, map[string]string{"owner": "team-a"}, false},
+		{"added", nil, map[string]string{"k8s.cronitor.io/env": "staging"}, true},
+		{"changed", map[string]string{"k8s.cronitor.io/env": "staging"}, map[string]string{"k8s.cronitor.io/env": "production"}, true},
+		{"removed", map[string]string{"k8s.cronitor.io/env": "staging"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasCronitorAnnotationChanges(tt.before, tt.after); got != tt.expected {
+				t.Errorf("hasCronitorAnnotationChanges() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRemoveCronJobsInNamespace(t *testing.T) {
+	coll := &CronJobCollection{cronjobs: make(map[types.UID]*v1.CronJob)}
+	for _, cronjob := range []*v1.CronJob{
+		createTestCronJob("job-1", "team-a", "uid-1", "*/5 * * * *"),
+		createTestCronJob("job-2", "team-a", "uid-2", "*/5 * * * *"),
+		createTestCronJob("job-3", "team-b", "uid-3", "*/5 * * * *"),
+	} {
+		cronjob.Annotations = map[string]string{
+			string(pkg.AnnotationEventRules): `[{"reason": "Evicted-` + cronjob.Namespace + `", "state": "fail"}]`,
+		}
+		coll.cronjobs[cronjob.UID] = cronjob
+		coll.storeEventRules(cronjob)
+	}
+
+	coll.RemoveCronJobsInNamespace("team-a")
+
+	if coll.IsTracked("uid-1") || coll.IsTracked("uid-2") {
+		t.Error("expected CronJobs in team-a to be removed")
+	}
+	if !coll.IsTracked("uid-3") {
+		t.Error("expected CronJobs in team-b to remain tracked")
+	}
+	if coll.eventRules.namesReason("Evicted-team-a") || !coll.eventRules.namesReason("Evicted-team-b") {
+		t.Error("expected only the event rules of CronJobs in team-a to be forgotten")
+	}
+}
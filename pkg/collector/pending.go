@@ -0,0 +1,248 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/metrics"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// pendingSyncInitialBackoff and pendingSyncMaxBackoff bound how long the agent waits
+	// between attempts to sync CronJobs that Cronitor could not be reached for.
+	pendingSyncInitialBackoff = 5 * time.Second
+	pendingSyncMaxBackoff     = 5 * time.Minute
+
+	// maxPendingTelemetry is how many telemetry events are held per CronJob while its
+	// monitor is waiting to be synced; older events are dropped beyond it.
+	maxPendingTelemetry = 20
+)
+
+// markPendingSync records CronJobs whose monitors have not been synced yet, e.g. because
+// Cronitor was unreachable. Their telemetry is held until they are.
+func (coll *CronJobCollection) markPendingSync(cronjobs ...*v1.CronJob) {
+	coll.cronjobsMu.Lock()
+	defer coll.cronjobsMu.Unlock()
+	if coll.pendingSync == nil {
+		coll.pendingSync = make(map[types.UID]*v1.CronJob)
+	}
+	for _, cronjob := range cronjobs {
+		coll.pendingSync[cronjob.GetUID()] = cronjob
+	}
+	metrics.CronJobsPendingSync.Set(int64(len(coll.pendingSync)))
+}
+
+// IsPendingSync reports whether a CronJob is included but its monitor has not been synced yet.
+func (coll *CronJobCollection) IsPendingSync(uid types.UID) bool {
+	coll.cronjobsMu.RLock()
+	defer coll.cronjobsMu.RUnlock()
+	_, exists := coll.pendingSync[uid]
+	return exists
+}
+
+func (coll *CronJobCollection) getPendingCronJob(uid types.UID) (*v1.CronJob, bool) {
+	coll.cronjobsMu.RLock()
+	defer coll.cronjobsMu.RUnlock()
+	cronjob, exists := coll.pendingSync[uid]
+	return cronjob, exists
+}
+
+// removePendingSyncLocked drops a CronJob from the pending set, along with its held telemetry.
+// cronjobsMu must be held.
+func (coll *CronJobCollection) removePendingSyncLocked(uid types.UID) {
+	delete(coll.pendingSync, uid)
+	delete(coll.pendingTelemetry, uid)
+	metrics.CronJobsPendingSync.Set(int64(len(coll.pendingSync)))
+}
+
+// forwardTelemetry sends a CronJob's telemetry right away if its monitor exists, or holds
+// it until the monitor has been synced.
+func (coll *CronJobCollection) forwardTelemetry(cronjob *v1.CronJob, send func()) {
+	coll.cronjobsMu.Lock()
+	if _, pending := coll.pendingSync[cronjob.GetUID()]; !pending {
+		coll.cronjobsMu.Unlock()
+		send()
+		return
+	}
+	if coll.pendingTelemetry == nil {
+		coll.pendingTelemetry = make(map[types.UID][]func())
+	}
+	held := append(coll.pendingTelemetry[cronjob.GetUID()], send)
+	if len(held) > maxPendingTelemetry {
+		slog.Warn("dropping telemetry held for a cronjob waiting to be synced",
+			"namespace", cronjob.Namespace,
+			"name", cronjob.Name,
+			"dropped", len(held)-maxPendingTelemetry)
+		held = held[len(held)-maxPendingTelemetry:]
+	}
+	coll.pendingTelemetry[cronjob.GetUID()] = held
+	coll.cronjobsMu.Unlock()
+
+	slog.Info("holding telemetry until the cronjob's monitor is synced",
+		"namespace", cronjob.Namespace,
+		"name", cronjob.Name)
+}
+
+// syncPending sends the monitors of every pending CronJob. Those left to retry are queued
+// to be retried in the background, and it returns an error if there are any.
+func (coll *CronJobCollection) syncPending(ctx context.Context) error {
+	coll.cronjobsMu.RLock()
+	var cronjobs []*v1.CronJob
+	for _, cronjob := range coll.pendingSync {
+		cronjobs = append(cronjobs, cronjob)
+	}
+	coll.cronjobsMu.RUnlock()
+
+	retrying, err := coll.syncCronJobs(ctx, cronjobs)
+	if len(retrying) == 0 {
+		return nil
+	}
+	if coll.syncQueue != nil {
+		for _, cronjob := range retrying {
+			coll.syncQueue.retry(cronjob)
+		}
+	}
+	return err
+}
+
+// syncCronJobs sends the monitors of the given CronJobs in batched requests. The CronJobs
+// that were synced become tracked and the telemetry held for them is sent; those whose
+// monitors Cronitor rejected are reported and dropped. It returns the CronJobs left to
+// retry, which stay pending if they weren't tracked yet, along with the error.
+func (coll *CronJobCollection) syncCronJobs(ctx context.Context, cronjobs []*v1.CronJob) (map[types.UID]*v1.CronJob, error) {
+	if len(cronjobs) == 0 {
+		return nil, nil
+	}
+
+	// Monitors that haven't changed since they were last synced aren't sent again
+	var changed []*v1.CronJob
+	for _, cronjob := range cronjobs {
+		if !coll.syncState.unchanged(cronjob) {
+			changed = append(changed, cronjob)
+		}
+	}
+	failed := coll.putCronJobsByAccount(ctx, changed)
+	var err error
+	if len(failed) > 0 {
+		err = api.SyncError{Failed: failed}
+	}
+	for _, cronjob := range changed {
+		if _, ok := failed[cronjob.GetUID()]; !ok {
+			coll.recordSynced(cronjob)
+		}
+	}
+
+	var held []func()
+	var synced int
+	retrying := make(map[types.UID]*v1.CronJob)
+	rejected := make(map[*v1.CronJob]error)
+	coll.cronjobsMu.Lock()
+	for _, cronjob := range cronjobs {
+		uid := cronjob.GetUID()
+		// A CronJob updated or removed while the request was in flight stays as it is now
+		pendingCronJob, pending := coll.pendingSync[uid]
+		_, tracked := coll.cronjobs[uid]
+		if pending && pendingCronJob != cronjob || !pending && !tracked {
+			continue
+		}
+		if failure, ok := failed[uid]; ok {
+			if ClassifyError(failure) == ErrorClassConfig && !api.IsRejectedApiKey(failure) {
+				rejected[cronjob] = failure
+				coll.removePendingSyncLocked(uid)
+			} else {
+				retrying[uid] = cronjob
+			}
+			continue
+		}
+		held = append(held, coll.pendingTelemetry[uid]...)
+		coll.removePendingSyncLocked(uid)
+		coll.cronjobs[uid] = cronjob
+		synced++
+	}
+	coll.cronjobsMu.Unlock()
+
+	for cronjob, failure := range rejected {
+		coll.reportCronJobError(cronjob, fmt.Errorf("error adding cronjob to Cronitor: %w", failure))
+	}
+	if synced > 0 {
+		slog.Info("cronjobs have been synced to Cronitor",
+			"synced_count", synced,
+			"unchanged_count", len(cronjobs)-len(changed),
+			"rejected_count", len(rejected),
+			"held_telemetry_count", len(held))
+	}
+	for _, send := range held {
+		send()
+	}
+	if len(retrying) > 0 {
+		return retrying, err
+	}
+	return nil, nil
+}
+
+// recordSynced remembers the monitors of CronJobs that have just been synced, unless in dry
+// run, where nothing was actually sent to Cronitor.
+func (coll *CronJobCollection) recordSynced(cronjobs ...*v1.CronJob) {
+	if coll.config.Get().DryRun {
+		return
+	}
+	coll.syncState.record(cronjobs...)
+}
+
+// putCronJobsByAccount sends the monitors of each Cronitor account in its own batch, and
+// returns the errors of the CronJobs that could not be synced. When an account's API key is
+// rejected, its CronJobs fail with a NoAccountError rather than stopping the agent, which
+// only happens for the agent's own key.
+func (coll *CronJobCollection) putCronJobsByAccount(ctx context.Context, cronjobs []*v1.CronJob) map[types.UID]error {
+	failed := make(map[types.UID]error)
+	type accountBatch struct {
+		name     string
+		api      *api.CronitorApi
+		cronjobs []*v1.CronJob
+	}
+	batches := make(map[string]*accountBatch)
+	for _, cronjob := range cronjobs {
+		name, cronitorApi, err := coll.resolveAccount(cronjob.Namespace)
+		if err != nil {
+			failed[cronjob.GetUID()] = err
+			continue
+		}
+		if batches[name] == nil {
+			batches[name] = &accountBatch{name: name, api: cronitorApi}
+		}
+		batches[name].cronjobs = append(batches[name].cronjobs, cronjob)
+	}
+
+	for _, batch := range batches {
+		_, err := batch.api.PutCronJobs(ctx, batch.cronjobs)
+		if err == nil {
+			continue
+		}
+		var syncErr api.SyncError
+		isSyncErr := errors.As(err, &syncErr)
+		for _, cronjob := range batch.cronjobs {
+			failure := err
+			if isSyncErr {
+				var ok bool
+				if failure, ok = syncErr.Failed[cronjob.GetUID()]; !ok {
+					continue
+				}
+			}
+			if batch.name != "" && api.IsRejectedApiKey(failure) {
+				failure = NoAccountError{
+					Namespace: cronjob.Namespace,
+					Account:   batch.name,
+					Err:       fmt.Errorf("its API key was rejected: %w", failure),
+				}
+			}
+			failed[cronjob.GetUID()] = failure
+		}
+	}
+	return failed
+}
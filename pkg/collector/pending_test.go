@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/cronitortest"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestLoadAllExistingCronJobs_CronitorUnreachable(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+	mockServer.setStatus(http.StatusServiceUnavailable)
+
+	coll := createTestCollection(mockServer)
+	coll.clientset = fake.NewSimpleClientset(createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"))
+	coll.serverVersion = &version.Info{Major: "1", Minor: "25"}
+
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("expected the agent to start while Cronitor is unreachable, got: %v", err)
+	}
+	if coll.IsTracked("uid-a") || !coll.IsPendingSync("uid-a") {
+		t.Fatal("expected the CronJob to be pending sync")
+	}
+
+	// Telemetry is held until the monitor exists
+	sent := 0
+	cronjob, _ := coll.getPendingCronJob("uid-a")
+	coll.forwardTelemetry(cronjob, func() { sent++ })
+	if sent != 0 {
+		t.Fatal("expected telemetry to be held while the CronJob is pending sync")
+	}
+
+	if err := coll.syncPending(context.Background()); err == nil {
+		t.Fatal("expected the retry to fail while Cronitor is unreachable")
+	}
+	mockServer.setStatus(http.StatusOK)
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !coll.IsTracked("uid-a") || coll.IsPendingSync("uid-a") {
+		t.Error("expected the CronJob to be tracked once synced")
+	}
+	if sent != 1 {
+		t.Errorf("expected the held telemetry to be sent once synced, got %d", sent)
+	}
+
+	coll.forwardTelemetry(cronjob, func() { sent++ })
+	if sent != 2 {
+		t.Error("expected telemetry for a synced CronJob to be sent right away")
+	}
+}
+
+func TestLoadAllExistingCronJobs_RejectedApiKey(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+	mockServer.setStatus(http.StatusUnauthorized)
+
+	coll := createTestCollection(mockServer)
+	coll.clientset = fake.NewSimpleClientset(createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"))
+	coll.serverVersion = &version.Info{Major: "1", Minor: "25"}
+
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err == nil {
+		t.Fatal("expected an error when Cronitor rejects the API key")
+	}
+	if coll.IsPendingSync("uid-a") {
+		t.Error("expected a rejected sync not to be retried")
+	}
+}
+
+func TestForwardTelemetry_DropsOldestBeyondLimit(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+	coll.markPendingSync(cronjob)
+
+	var sent []int
+	for i := 0; i < maxPendingTelemetry+5; i++ {
+		i := i
+		coll.forwardTelemetry(cronjob, func() { sent = append(sent, i) })
+	}
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sent) != maxPendingTelemetry || sent[0] != 5 {
+		t.Errorf("expected the %d most recent events to be sent, got %v", maxPendingTelemetry, sent)
+	}
+}
+
+func TestRemoveCronJob_ClearsPendingSync(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+	coll.markPendingSync(cronjob)
+
+	onDelete(coll, cronjob)
+	if coll.IsPendingSync("uid-a") {
+		t.Error("expected a deleted CronJob to no longer be pending sync")
+	}
+	if err := coll.syncPending(context.Background()); err != nil || mockServer.getRequestCount() != 0 {
+		t.Errorf("expected nothing left to sync, got err=%v requests=%d", err, mockServer.getRequestCount())
+	}
+}
+
+func TestSyncPending_OnlyRejectedCronJobsUnsynced(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	server.Reject("uid-b", "schedule is invalid")
+
+	cfg := config.NewStore(&config.Config{HostnameOverride: server.URL})
+	coll := &CronJobCollection{
+		cronitorApi: &api.CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: cfg},
+		cronjobs:    make(map[types.UID]*v1.CronJob),
+		config:      cfg,
+	}
+	coll.markPendingSync(
+		createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"),
+		createTestCronJob("job-b", "default", "uid-b", "*/5 * * * *"),
+		createTestCronJob("job-c", "default", "uid-c", "*/5 * * * *"),
+	)
+
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("expected a rejected monitor not to be retried, got: %v", err)
+	}
+	if !coll.IsTracked("uid-a") || !coll.IsTracked("uid-c") {
+		t.Error("expected the valid CronJobs to be tracked")
+	}
+	if coll.IsTracked("uid-b") || coll.IsPendingSync("uid-b") {
+		t.Error("expected the rejected CronJob to be neither tracked nor retried")
+	}
+	server.ExpectMonitor(t, "uid-a")
+	server.ExpectMonitor(t, "uid-c")
+	server.ExpectNoMonitor(t, "uid-b")
+}
+
+func TestSyncPending_RetriesAfterScriptedFailures(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	server.Fail(cronitortest.MonitorsEndpoint,
+		cronitortest.TooManyRequests(time.Second),
+		cronitortest.ServerError(http.StatusServiceUnavailable))
+
+	cfg := config.NewStore(&config.Config{HostnameOverride: server.URL})
+	coll := &CronJobCollection{
+		cronitorApi: &api.CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: cfg},
+		cronjobs:    make(map[types.UID]*v1.CronJob),
+		config:      cfg,
+	}
+	coll.markPendingSync(createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"))
+
+	for i := 0; i < 2; i++ {
+		if err := coll.syncPending(context.Background()); err == nil {
+			t.Fatalf("expected attempt %d to fail", i+1)
+		}
+		if !coll.IsPendingSync("uid-a") {
+			t.Fatalf("expected the CronJob to stay pending after attempt %d", i+1)
+		}
+	}
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !coll.IsTracked("uid-a") {
+		t.Error("expected the CronJob to be tracked once synced")
+	}
+	if monitor := server.ExpectMonitor(t, "uid-a"); monitor.Schedule != "*/5 * * * *" {
+		t.Errorf("unexpected monitor %+v", monitor)
+	}
+}
@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/policy"
+	v1 "k8s.io/api/batch/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// policyWatch is a policy resource, and the namespace it is listed in, that the agent was
+// able to load and therefore keeps watching.
+type policyWatch struct {
+	resource  schema.GroupVersionResource
+	namespace string
+}
+
+// loadPolicies reads the existing CronitorPolicies and ClusterCronitorPolicies before the
+// CronJobs are first synced. Policies are optional: if their CRDs aren't installed or the
+// agent isn't allowed to read them, CronJobs are synced without them.
+func (coll *CronJobCollection) loadPolicies(ctx context.Context) {
+	if coll.dynamicClient == nil {
+		return
+	}
+
+	toLoad := []policyWatch{{resource: policy.ClusterCronitorPolicyResource}}
+	for _, namespace := range coll.namespaceScope.WatchedNamespaces() {
+		toLoad = append(toLoad, policyWatch{resource: policy.CronitorPolicyResource, namespace: namespace})
+	}
+
+	coll.policyWatches = nil
+	for _, watch := range toLoad {
+		list, err := coll.dynamicClient.Resource(watch.resource).Namespace(watch.namespace).List(ctx, meta_v1.ListOptions{})
+		if err != nil {
+			slog.Info("cronitor policies unavailable",
+				"resource", watch.resource.Resource,
+				"namespace", watch.namespace,
+				"error", err)
+			continue
+		}
+		for i := range list.Items {
+			coll.setPolicy(&list.Items[i])
+		}
+		coll.policyWatches = append(coll.policyWatches, watch)
+	}
+}
+
+// setPolicy records a policy read from the cluster, returning the version it replaced
+// and whether it changed.
+func (coll *CronJobCollection) setPolicy(obj *unstructured.Unstructured) (previous *policy.CronitorPolicy, current *policy.CronitorPolicy, changed bool) {
+	current, err := policy.FromUnstructured(obj)
+	if err == nil {
+		previous, changed, err = coll.policies.Set(current)
+	}
+	if err != nil {
+		slog.Warn("ignoring invalid cronitor policy",
+			"kind", obj.GetKind(),
+			"namespace", obj.GetNamespace(),
+			"name", obj.GetName(),
+			"error", err)
+		return nil, nil, false
+	}
+	return previous, current, changed
+}
+
+func newPolicyInformer(watcher *CronJobWatcher, watch policyWatch) cache.SharedIndexInformer {
+	coll := watcher.collection
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(coll.dynamicClient, 0, watch.namespace, nil)
+	informer := factory.ForResource(watch.resource).Informer()
+
+	onPolicy := func(obj interface{}) {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			return
+		}
+		previous, current, changed := coll.setPolicy(u)
+		if changed {
+			watcher.resyncPolicyCronJobs(previous, current)
+		}
+	}
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onPolicy,
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			onPolicy(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			u, ok := obj.(*unstructured.Unstructured)
+			if !ok {
+				return
+			}
+			if removed := coll.policies.Delete(u.GetNamespace(), u.GetName()); removed != nil {
+				watcher.resyncPolicyCronJobs(removed, nil)
+			}
+		},
+	})
+
+	return informer
+}
+
+// resyncPolicyCronJobs re-syncs the monitors of the CronJobs that a created, changed or
+// deleted policy applied to either before or after the change.
+func (c *CronJobWatcher) resyncPolicyCronJobs(before *policy.CronitorPolicy, after *policy.CronitorPolicy) {
+	changed := after
+	if changed == nil {
+		changed = before
+	}
+	slog.Info("cronitor policy changed, resyncing cronjobs",
+		"namespace", changed.Namespace,
+		"name", changed.Name)
+
+	for _, cronjob := range c.cachedCronJobs(changed.Namespace) {
+		if !c.collection.namespaceScope.IsNamespaceIncluded(cronjob.Namespace) {
+			continue
+		}
+		if (before != nil && policy.Matches(before, cronjob)) || (after != nil && policy.Matches(after, cronjob)) {
+			c.collection.resyncCronJob(cronjob)
+		}
+	}
+}
+
+// cachedCronJobs returns the CronJobs in the informer caches, limited to one namespace
+// unless namespace is metav1.NamespaceAll.
+func (c *CronJobWatcher) cachedCronJobs(namespace string) []*v1.CronJob {
+	var objs []interface{}
+	if namespace == meta_v1.NamespaceAll {
+		for _, informer := range c.informers {
+			objs = append(objs, informer.GetIndexer().List()...)
+		}
+	} else if informer, ok := c.informerForNamespace(namespace); ok {
+		var err error
+		objs, err = informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		if err != nil {
+			slog.Error("could not list cached CronJobs for namespace", "namespace", namespace, "error", err)
+			return nil
+		}
+	}
+
+	var cronjobs []*v1.CronJob
+	for _, obj := range objs {
+		if cronjob := coerceObjToV1CronJob(c.version, obj); cronjob != nil {
+			cronjobs = append(cronjobs, cronjob)
+		}
+	}
+	return cronjobs
+}
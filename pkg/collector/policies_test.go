@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/policy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testClusterPolicy(name string, group string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": policy.Group + "/" + policy.Version,
+		"kind":       policy.KindClusterCronitorPolicy,
+		"metadata":   map[string]interface{}{"name": name, "resourceVersion": "1"},
+		"spec": map[string]interface{}{
+			"defaults": map[string]interface{}{"group": group},
+		},
+	}}
+}
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		policy.CronitorPolicyResource:        "CronitorPolicyList",
+		policy.ClusterCronitorPolicyResource: "ClusterCronitorPolicyList",
+	}, objects...)
+}
+
+func TestLoadAllExistingCronJobs_AppliesPolicies(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.clientset = fake.NewSimpleClientset(createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *"))
+	coll.serverVersion = &version.Info{Major: "1", Minor: "25"}
+	coll.dynamicClient = newFakeDynamicClient(testClusterPolicy("defaults", "platform"))
+	coll.policies = policy.NewStore()
+
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(mockServer.lastBody, `"group":"platform"`) {
+		t.Errorf("expected the monitor to get the policy's group, got body %s", mockServer.lastBody)
+	}
+	if len(coll.policyWatches) != 2 {
+		t.Errorf("expected both policy kinds to be watched, got %v", coll.policyWatches)
+	}
+}
+
+func TestResyncPolicyCronJobs(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.policies = policy.NewStore()
+
+	informer, err := newCronJobInformer(coll, metav1.NamespaceAll, "v1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	matching := createTestCronJob("job-a", "team-a", "uid-a", "*/5 * * * *")
+	matching.Labels = map[string]string{"tier": "prod"}
+	other := createTestCronJob("job-b", "team-a", "uid-b", "*/5 * * * *")
+	informer.GetIndexer().Add(matching)
+	informer.GetIndexer().Add(other)
+	watcher := &CronJobWatcher{
+		informers:  map[string]cache.SharedIndexInformer{metav1.NamespaceAll: informer},
+		version:    "v1",
+		collection: coll,
+	}
+
+	prodPolicy := testClusterPolicy("prod", "production-jobs")
+	unstructured.SetNestedStringMap(prodPolicy.Object, map[string]string{"tier": "prod"}, "spec", "selector", "matchLabels")
+	previous, current, changed := coll.setPolicy(prodPolicy)
+	if !changed {
+		t.Fatal("expected the new policy to be a change")
+	}
+	watcher.resyncPolicyCronJobs(previous, current)
+
+	if count := mockServer.getRequestCount(); count != 1 {
+		t.Errorf("expected only the matching CronJob to be resynced, got %d requests", count)
+	}
+	if !strings.Contains(mockServer.lastBody, `"group":"production-jobs"`) {
+		t.Errorf("expected the resynced monitor to get the policy's group, got body %s", mockServer.lastBody)
+	}
+}
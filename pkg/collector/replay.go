@@ -0,0 +1,148 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/normalizer"
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// DecodeObjects reads Kubernetes objects from YAML or JSON, as written by `kubectl get -o yaml`
+// or `-o json`: single objects, lists, or several YAML documents. Lists are flattened.
+func DecodeObjects(r io.Reader) ([]runtime.Object, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	var objects []runtime.Object
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, err
+		}
+		if len(raw.Raw) == 0 {
+			continue
+		}
+		decoded, err := decodeObject(raw.Raw)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+}
+
+func decodeObject(data []byte) ([]runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode object: %w", err)
+	}
+	if list, ok := obj.(*corev1.List); ok {
+		var objects []runtime.Object
+		for _, item := range list.Items {
+			decoded, err := decodeObject(item.Raw)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, decoded...)
+		}
+		return objects, nil
+	}
+	if meta.IsListType(obj) {
+		return meta.ExtractList(obj)
+	}
+	return []runtime.Object{obj}, nil
+}
+
+// Replay feeds recorded Events through the event handler, in the order they happened, against
+// a fake cluster holding objects (the CronJobs, Jobs and Pods they refer to), and records the
+// pings and log uploads the agent would have sent with recorder. Included CronJobs are treated
+// as already synced to Cronitor. Pod logs aren't part of the snapshot, so log uploads hold the
+// fake cluster's placeholder logs.
+func Replay(ctx context.Context, cfg *config.Store, objects []runtime.Object, recorder *api.DryRunRecorder) error {
+	var events []*corev1.Event
+	var clusterObjects []runtime.Object
+	cronjobs := make(map[types.UID]*v1.CronJob)
+	for _, obj := range objects {
+		switch typed := obj.(type) {
+		case *corev1.Event:
+			events = append(events, typed)
+			continue
+		case *v1.CronJob:
+			cronjobs[typed.UID] = typed
+		case *v1beta1.CronJob:
+			cronjobs[typed.UID] = normalizer.CronJobConvertV1Beta1ToV1(typed)
+		}
+		clusterObjects = append(clusterObjects, obj)
+	}
+
+	cronitorApi := api.NewCronitorApi(cfg)
+	cronitorApi.DryRun = true
+	cronitorApi.Recorder = recorder
+	coll := &CronJobCollection{
+		clientset:   fake.NewSimpleClientset(clusterObjects...),
+		config:      cfg,
+		cronitorApi: &cronitorApi,
+		cronjobs:    make(map[types.UID]*v1.CronJob),
+		ctx:         ctx,
+	}
+	for uid, cronjob := range cronjobs {
+		included, err := coll.isCronJobIncluded(cronjob)
+		if err != nil {
+			slog.Warn("cronjob has invalid annotations, its events are ignored",
+				"namespace", cronjob.Namespace,
+				"name", cronjob.Name,
+				"error", err)
+			continue
+		}
+		if !included {
+			slog.Info("cronjob is not included, its events are ignored",
+				"namespace", cronjob.Namespace,
+				"name", cronjob.Name)
+			continue
+		}
+		coll.cronjobs[uid] = cronjob
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+	// Without a watch start time, no event is ignored for having happened in the past
+	handler := &EventHandler{collection: coll}
+	for _, event := range events {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		handler.OnAdd(event)
+	}
+	// The recorded events are all in the past, so the Pods they left stuck are checked right away
+	handler.stuckPods.flush()
+	return cronitorApi.WaitForUploads(ctx)
+}
+
+// eventTime returns when an Event last happened.
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.FirstTimestamp.Time
+	}
+}
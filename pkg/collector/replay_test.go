@@ -0,0 +1,219 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const replayEvents = `{
+  "apiVersion": "v1",
+  "kind": "List",
+  "items": [
+    {
+      "apiVersion": "v1",
+      "kind": "Event",
+      "metadata": {"name": "nightly-28000000.2", "namespace": "default"},
+      "involvedObject": {"kind": "Job", "name": "nightly-28000000", "namespace": "default"},
+      "reason": "BackoffLimitExceeded",
+      "message": "Job has reached the specified backoff limit",
+      "lastTimestamp": "2026-01-01T00:05:00Z"
+    },
+    {
+      "apiVersion": "v1",
+      "kind": "Event",
+      "metadata": {"name": "nightly-28000000.1", "namespace": "default"},
+      "involvedObject": {"kind": "Job", "name": "nightly-28000000", "namespace": "default"},
+      "reason": "SuccessfulCreate",
+      "message": "Created pod: nightly-28000000-abcde",
+      "lastTimestamp": "2026-01-01T00:00:00Z"
+    },
+    {
+      "apiVersion": "v1",
+      "kind": "Event",
+      "metadata": {"name": "other-28000000.1", "namespace": "default"},
+      "involvedObject": {"kind": "Job", "name": "other-28000000", "namespace": "default"},
+      "reason": "SuccessfulCreate",
+      "lastTimestamp": "2026-01-01T00:00:00Z"
+    }
+  ]
+}`
+
+const replayObjects = `apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: nightly
+  namespace: default
+  uid: nightly-uid
+spec:
+  schedule: "0 0 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: main
+            image: busybox
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: other
+  namespace: default
+  uid: other-uid
+  annotations:
+    k8s.cronitor.io/exclude: "true"
+spec:
+  schedule: "0 0 * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: main
+            image: busybox
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: batch/v1
+  kind: Job
+  metadata:
+    name: nightly-28000000
+    namespace: default
+    uid: nightly-job-uid
+    ownerReferences:
+    - apiVersion: batch/v1
+      kind: CronJob
+      name: nightly
+      uid: nightly-uid
+- apiVersion: batch/v1
+  kind: Job
+  metadata:
+    name: other-28000000
+    namespace: default
+    uid: other-job-uid
+    ownerReferences:
+    - apiVersion: batch/v1
+      kind: CronJob
+      name: other
+      uid: other-uid
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: nightly-28000000-abcde
+    namespace: default
+    labels:
+      job-name: nightly-28000000
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: other-28000000-fghij
+    namespace: default
+    labels:
+      job-name: other-28000000
+`
+
+func TestReplay_PrintsTelemetry(t *testing.T) {
+	events, err := DecodeObjects(strings.NewReader(replayEvents))
+	if err != nil {
+		t.Fatalf("could not decode events: %v", err)
+	}
+	objects, err := DecodeObjects(strings.NewReader(replayObjects))
+	if err != nil {
+		t.Fatalf("could not decode objects: %v", err)
+	}
+	if len(events) != 3 || len(objects) != 6 {
+		t.Fatalf("expected 3 events and 6 objects, got %d and %d", len(events), len(objects))
+	}
+
+	var out bytes.Buffer
+	cfg := config.NewStore(&config.Config{ShipLogs: true})
+	if err := Replay(context.Background(), cfg, append(events, objects...), api.NewDryRunRecorder(&out)); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+
+	var pings []string
+	var uploads int
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var record api.DryRunRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		switch record.Type {
+		case api.DryRunPing:
+			if strings.Contains(record.URL, "other") {
+				t.Errorf("expected no telemetry for the excluded cronjob, got %s", record.URL)
+			}
+			pings = append(pings, record.URL)
+		case api.DryRunLogUpload:
+			uploads++
+		}
+	}
+
+	// The events are replayed in the order they happened: run, then fail and its logs
+	if len(pings) != 3 {
+		t.Fatalf("expected run, fail and logs pings, got %v", pings)
+	}
+	for i, state := range []string{"state=run", "state=fail", "state=logs"} {
+		if !strings.Contains(pings[i], state) {
+			t.Errorf("expected ping %d to hold %s, got %s", i, state, pings[i])
+		}
+	}
+	if uploads != 1 {
+		t.Errorf("expected one log upload for the failed job, got %d", uploads)
+	}
+}
+
+func TestReplay_ChecksStuckPodsBeforeReturning(t *testing.T) {
+	cronjob := createTestCronJob("nightly", "default", "nightly-uid", "0 0 * * *")
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{
+		Name:            "nightly-28000000",
+		Namespace:       "default",
+		UID:             "nightly-job-uid",
+		OwnerReferences: []metav1.OwnerReference{{Kind: "CronJob", Name: "nightly", UID: "nightly-uid"}},
+	}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "nightly-28000000-abcde",
+			Namespace:       "default",
+			UID:             "nightly-pod-uid",
+			Labels:          map[string]string{jobPodLabel: job.Name},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "Job", Name: job.Name, UID: job.UID}},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "main",
+				Image: "busybox:missing",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}},
+			}},
+		},
+	}
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "nightly-28000000-abcde.1", Namespace: "default"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: pod.Name, Namespace: "default", UID: pod.UID},
+		Reason:         "BackOff",
+		Message:        `Back-off pulling image "busybox:missing"`,
+	}
+
+	// The grace period would only pass long after the replay is done
+	var out bytes.Buffer
+	cfg := config.NewStore(&config.Config{PendingGracePeriod: time.Hour})
+	if err := Replay(context.Background(), cfg, []runtime.Object{event, cronjob, job, pod}, api.NewDryRunRecorder(&out)); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if !strings.Contains(out.String(), "state=fail") || !strings.Contains(out.String(), "ImagePullBackOff") {
+		t.Errorf("expected the stuck Pod to be reported as failed, got %s", out.String())
+	}
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// reportRetention is how long reported objects are remembered, so that the events and updates
+// Kubernetes keeps recording for them aren't reported again.
+const reportRetention = 24 * time.Hour
+
+// reportedSet remembers the UIDs of the Pods and Jobs whose runs were reported, for
+// reportRetention. Its zero value is ready to use.
+type reportedSet struct {
+	mu       sync.Mutex
+	reported map[types.UID]time.Time
+}
+
+// report records uid as reported, and reports whether it wasn't already.
+func (r *reportedSet) report(uid types.UID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if reportedAt, ok := r.reported[uid]; ok && now.Sub(reportedAt) <= reportRetention {
+		return false
+	}
+	if r.reported == nil {
+		r.reported = make(map[types.UID]time.Time)
+	}
+	for reported, reportedAt := range r.reported {
+		if now.Sub(reportedAt) > reportRetention {
+			delete(r.reported, reported)
+		}
+	}
+	r.reported[uid] = now
+	return true
+}
+
+// isReported reports whether the object with uid was reported within reportRetention.
+func (r *reportedSet) isReported(uid types.UID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reportedAt, ok := r.reported[uid]
+	return ok && time.Since(reportedAt) <= reportRetention
+}
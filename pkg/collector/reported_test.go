@@ -0,0 +1,25 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReportedSet(t *testing.T) {
+	var reported reportedSet
+	if !reported.report("pod-a") || reported.report("pod-a") {
+		t.Fatal("expected a Pod to be reported once")
+	}
+	if !reported.isReported("pod-a") || reported.isReported("pod-b") {
+		t.Error("expected only the reported UIDs to be remembered")
+	}
+
+	// Past the retention, a UID counts as not reported even before it is evicted
+	reported.reported["pod-a"] = time.Now().Add(-reportRetention - time.Minute)
+	if reported.isReported("pod-a") {
+		t.Error("expected an expired report to be forgotten")
+	}
+	if !reported.report("pod-a") {
+		t.Error("expected an expired report to be reported again")
+	}
+}
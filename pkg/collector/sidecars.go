@@ -0,0 +1,215 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// DefaultSidecarContainers are the names of the containers that service meshes and common
+// proxies inject into Pods, which keep running after a Job's own containers have exited.
+var DefaultSidecarContainers = []string{"istio-proxy", "linkerd-proxy", "consul-dataplane", "kuma-sidecar", "cloud-sql-proxy"}
+
+// jobPodLabel is set by the Job controller on the Pods it creates.
+const jobPodLabel = "job-name"
+
+// mainContainers returns the names of a Pod's main containers: those the CronJob names with the
+// main-container annotation, or else every container but the sidecars. Native sidecars, init
+// containers with restartPolicy Always, aren't among the Pod's containers, so they are never
+// main containers. The main-container annotation is only read from the CronJob itself.
+func mainContainers(pod *corev1.Pod, cronjob *v1.CronJob, sidecars []string) []string {
+	named := pkg.NewCronitorConfigParser(cronjob, pkg.ConfigSources{}).GetMainContainers()
+	var main []string
+	for _, container := range pod.Spec.Containers {
+		if len(named) > 0 {
+			if containsString(named, container.Name) {
+				main = append(main, container.Name)
+			}
+		} else if !containsString(sidecars, container.Name) {
+			main = append(main, container.Name)
+		}
+	}
+	return main
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// mainContainersExited returns how to report the run of a Pod whose main containers have all
+// exited while other containers keep it running, and a message naming how they exited, if
+// that is the case. Failed containers that Kubernetes will restart, as restartPolicy
+// OnFailure does, don't end the run.
+func mainContainersExited(pod *corev1.Pod, main []string) (api.TelemetryEventStatus, string, bool) {
+	if pod.Status.Phase != corev1.PodRunning || len(main) == 0 || len(main) == len(pod.Spec.Containers) {
+		return "", "", false
+	}
+	state := api.Complete
+	var exits []string
+	for _, name := range main {
+		var terminated *corev1.ContainerStateTerminated
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == name {
+				terminated = status.State.Terminated
+			}
+		}
+		if terminated == nil {
+			return "", "", false
+		}
+		exit := fmt.Sprintf("container %q exited with code %d", name, terminated.ExitCode)
+		if terminated.ExitCode != 0 {
+			state = api.Fail
+			if terminated.Reason != "" {
+				exit += " (" + terminated.Reason + ")"
+			}
+		}
+		exits = append(exits, exit)
+	}
+	if state == api.Fail && pod.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		return "", "", false
+	}
+	return state, fmt.Sprintf("Pod %s: %s; its sidecar containers are still running", pod.Name, strings.Join(exits, ", ")), true
+}
+
+// mainContainersMayHaveExited reports whether mainContainersExited may find that a Pod's main
+// containers have exited, whichever of its containers the CronJob names as main ones: the Pod is
+// running more than one container, and one of them exited in a way that would end its run.
+func mainContainersMayHaveExited(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || len(pod.Spec.Containers) < 2 {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if terminated := status.State.Terminated; terminated != nil && (terminated.ExitCode == 0 || pod.Spec.RestartPolicy == corev1.RestartPolicyNever) {
+			return true
+		}
+	}
+	return false
+}
+
+// sidecarContainers returns the configured sidecar container names.
+func (e *EventHandler) sidecarContainers() []string {
+	if sidecars := e.collection.config.Get().SidecarContainers; len(sidecars) > 0 {
+		return sidecars
+	}
+	return DefaultSidecarContainers
+}
+
+// logContainers returns the containers of a Pod to collect logs from, or nil for the Pod's
+// default container.
+func (e *EventHandler) logContainers(pod *corev1.Pod, cronjob *v1.CronJob) []string {
+	if !e.collection.config.Get().MainContainerLogs {
+		return nil
+	}
+	return mainContainers(pod, cronjob, e.sidecarContainers())
+}
+
+// onJobPod reports the run of a Job's Pod once its main containers have all exited, if sidecar
+// containers keep the Pod running, which would otherwise keep the Job from ever completing.
+func (e *EventHandler) onJobPod(pod *corev1.Pod) {
+	// Check the Pod itself before calling the Kubernetes API for the related objects
+	if !mainContainersMayHaveExited(pod) || e.exitedPods.isReported(pod.UID) {
+		return
+	}
+	var jobOwnerRef *meta_v1.OwnerReference
+	for i := range pod.OwnerReferences {
+		if pod.OwnerReferences[i].Kind == "Job" {
+			jobOwnerRef = &pod.OwnerReferences[i]
+			break
+		}
+	}
+	if jobOwnerRef == nil {
+		return
+	}
+
+	job, err := e.fetchJob(pod.Namespace, jobOwnerRef.Name)
+	if err != nil {
+		slog.Debug("job not found, probably already deleted", "namespace", pod.Namespace, "pod", pod.Name, "error", err)
+		return
+	}
+	if len(job.OwnerReferences) == 0 || job.OwnerReferences[0].Kind != "CronJob" {
+		return
+	}
+	ownerUID := job.OwnerReferences[0].UID
+	if !e.collection.IsTracked(ownerUID) && !e.collection.IsPendingSync(ownerUID) {
+		return
+	}
+	cronjob, err := e.fetchCronJob(ownerUID)
+	if err != nil {
+		return
+	}
+
+	main := mainContainers(pod, cronjob, e.sidecarContainers())
+	state, message, exited := mainContainersExited(pod, main)
+	if !exited {
+		return
+	}
+	if !e.exitedPods.report(pod.UID) {
+		return
+	}
+	// A completed Job is done, whatever ends its sidecars later, e.g. its activeDeadlineSeconds
+	if state == api.Complete {
+		e.exitedPods.report(job.UID)
+	}
+
+	var logs string
+	if e.collection.config.Get().ShipLogs {
+		logs, _ = e.fetchPodLogs(pod, e.logContainers(pod, cronjob))
+	}
+	slog.Info("main containers exited while sidecars are running",
+		"namespace", pod.Namespace,
+		"pod", pod.Name,
+		"state", state,
+		"message", message)
+	cronitorApi, err := e.collection.apiFor(cronjob.Namespace)
+	if err != nil {
+		slog.Warn("could not send pod completion", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+		return
+	}
+	e.collection.forwardTelemetry(cronjob, func() {
+		_ = cronitorApi.MakeAndSendTelemetryMainContainersExited(e.collection.context(), state, message, logs, pod, job, cronjob)
+	})
+}
+
+// newJobPodInformer watches the Pods of Jobs in a namespace, or in every namespace when given
+// metav1.NamespaceAll, for runs whose main containers have exited.
+func newJobPodInformer(eventHandler *EventHandler, namespace string) cache.SharedIndexInformer {
+	coll := eventHandler.collection
+	factoryOptions := []informers.SharedInformerOption{
+		informers.WithTweakListOptions(func(options *meta_v1.ListOptions) {
+			options.LabelSelector = jobPodLabel
+		}),
+	}
+	if namespace != meta_v1.NamespaceAll {
+		factoryOptions = append(factoryOptions, informers.WithNamespace(namespace))
+	}
+	factory := informers.NewSharedInformerFactoryWithOptions(coll.clientset, 0, factoryOptions...)
+	informer := factory.Core().V1().Pods().Informer()
+
+	onPod := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || !coll.namespaceScope.IsNamespaceIncluded(pod.Namespace) {
+			return
+		}
+		eventHandler.onJobPod(pod)
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: onPod,
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			onPod(newObj)
+		},
+	})
+	return informer
+}
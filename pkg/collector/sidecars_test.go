@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func sidecarTestPod(restartPolicy corev1.RestartPolicy, statuses ...corev1.ContainerStatus) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-abcde"},
+		Spec:       corev1.PodSpec{RestartPolicy: restartPolicy},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: statuses},
+	}
+	for _, status := range statuses {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: status.Name})
+	}
+	return pod
+}
+
+func exited(name string, exitCode int32, reason string) corev1.ContainerStatus {
+	return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: reason},
+	}}
+}
+
+func running(name string) corev1.ContainerStatus {
+	return corev1.ContainerStatus{Name: name, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+}
+
+func TestMainContainers(t *testing.T) {
+	pod := sidecarTestPod(corev1.RestartPolicyNever, running("app"), running("istio-proxy"), running("exporter"))
+
+	cronjob := createTestCronJob("job", "default", "uid", "0 * * * *")
+	if got := mainContainers(pod, cronjob, DefaultSidecarContainers); !reflect.DeepEqual(got, []string{"app", "exporter"}) {
+		t.Errorf("expected the known sidecars to be left out, got %v", got)
+	}
+
+	cronjob.Annotations = map[string]string{"k8s.cronitor.io/main-container": "app, missing"}
+	if got := mainContainers(pod, cronjob, DefaultSidecarContainers); !reflect.DeepEqual(got, []string{"app"}) {
+		t.Errorf("expected the annotated containers, got %v", got)
+	}
+}
+
+func TestMainContainersExited(t *testing.T) {
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		expectedState api.TelemetryEventStatus
+		expected      string
+	}{
+		{
+			name:          "succeeded",
+			pod:           sidecarTestPod(corev1.RestartPolicyOnFailure, exited("app", 0, "Completed"), running("istio-proxy")),
+			expectedState: api.Complete,
+			expected:      `Pod job-abcde: container "app" exited with code 0; its sidecar containers are still running`,
+		},
+		{
+			name:          "failed",
+			pod:           sidecarTestPod(corev1.RestartPolicyNever, exited("app", 2, "Error"), running("istio-proxy")),
+			expectedState: api.Fail,
+			expected:      `Pod job-abcde: container "app" exited with code 2 (Error); its sidecar containers are still running`,
+		},
+		{
+			name: "failed and restarted",
+			pod:  sidecarTestPod(corev1.RestartPolicyOnFailure, exited("app", 2, "Error"), running("istio-proxy")),
+		},
+		{
+			name: "still running",
+			pod:  sidecarTestPod(corev1.RestartPolicyNever, running("app"), running("istio-proxy")),
+		},
+		{
+			name: "no sidecar",
+			pod:  sidecarTestPod(corev1.RestartPolicyNever, exited("app", 0, "Completed")),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, message, done := mainContainersExited(tt.pod, []string{"app"})
+			if done != (tt.expected != "") || state != tt.expectedState || message != tt.expected {
+				t.Errorf("expected %s %q, got %s %q (done=%t)", tt.expectedState, tt.expected, state, message, done)
+			}
+			// Pods whose main containers exited always pass the check made before the CronJob is known
+			if done && !mainContainersMayHaveExited(tt.pod) {
+				t.Error("expected the main containers to possibly have exited")
+			}
+		})
+	}
+}
+
+func TestMainContainersMayHaveExited(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		pod      *corev1.Pod
+		expected bool
+	}{
+		{"one container exited", sidecarTestPod(corev1.RestartPolicyOnFailure, running("app"), exited("istio-proxy", 0, "Completed")), true},
+		{"failed and not restarted", sidecarTestPod(corev1.RestartPolicyNever, exited("app", 1, "Error"), running("istio-proxy")), true},
+		{"failed and restarted", sidecarTestPod(corev1.RestartPolicyOnFailure, exited("app", 1, "Error"), running("istio-proxy")), false},
+		{"still running", sidecarTestPod(corev1.RestartPolicyNever, running("app"), running("istio-proxy")), false},
+		{"single container", sidecarTestPod(corev1.RestartPolicyNever, exited("app", 0, "Completed")), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mainContainersMayHaveExited(tt.pod); got != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
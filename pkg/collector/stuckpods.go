@@ -0,0 +1,195 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DefaultPendingGracePeriod is how long a Pod may be kept from starting, e.g. by an image that
+// can't be pulled, before its run is reported as failed.
+const DefaultPendingGracePeriod = 5 * time.Minute
+
+// Reasons of the waiting containers of a Pod that can't start without a change
+var stuckContainerReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// isStuckPodEvent reports whether a Pod event may mean that the Pod can't start: it can't be
+// scheduled, its image can't be pulled, or a Secret or ConfigMap it uses is missing.
+func isStuckPodEvent(event *pkg.PodEvent) bool {
+	switch event.Reason {
+	case "FailedScheduling", "Failed", "FailedMount":
+		return true
+	case "BackOff":
+		return strings.HasPrefix(event.Message, "Back-off pulling image")
+	}
+	return false
+}
+
+// stuckPodTracker schedules a single check of each Pod with a stuck Pod event, and remembers
+// the Pods already reported. Its zero value is ready to use.
+type stuckPodTracker struct {
+	mu        sync.Mutex
+	scheduled map[types.UID]*scheduledCheck
+	reported  reportedSet
+	stopped   bool
+	// checks counts the checks scheduled or running
+	checks sync.WaitGroup
+}
+
+// scheduledCheck is a check of a Pod waiting for its timer.
+type scheduledCheck struct {
+	timer *time.Timer
+	run   func()
+}
+
+// schedule runs check for the Pod with uid after delay, unless a check of it is already
+// scheduled, it was reported, or the tracker was stopped. check returns whether it reported
+// the Pod.
+func (s *stuckPodTracker) schedule(uid types.UID, delay time.Duration, check func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped || s.scheduled[uid] != nil {
+		return
+	}
+	if s.reported.isReported(uid) {
+		return
+	}
+	if s.scheduled == nil {
+		s.scheduled = make(map[types.UID]*scheduledCheck)
+	}
+	s.checks.Add(1)
+	scheduled := &scheduledCheck{run: func() {
+		defer s.checks.Done()
+		s.done(uid, check())
+	}}
+	scheduled.timer = time.AfterFunc(delay, scheduled.run)
+	s.scheduled[uid] = scheduled
+}
+
+// done records that the check of the Pod with uid has run, and whether it reported the Pod.
+func (s *stuckPodTracker) done(uid types.UID, reported bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.scheduled, uid)
+	if reported {
+		s.reported.report(uid)
+	}
+}
+
+// stop cancels the checks that haven't started, and keeps new ones from being scheduled.
+func (s *stuckPodTracker) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	for uid, scheduled := range s.scheduled {
+		if scheduled.timer.Stop() {
+			delete(s.scheduled, uid)
+			s.checks.Done()
+		}
+	}
+}
+
+// flush runs the checks that haven't started right away, rather than once their delay has
+// passed, and waits for every check to finish.
+func (s *stuckPodTracker) flush() {
+	s.mu.Lock()
+	var due []func()
+	for _, scheduled := range s.scheduled {
+		if scheduled.timer.Stop() {
+			due = append(due, scheduled.run)
+		}
+	}
+	s.mu.Unlock()
+	for _, run := range due {
+		run()
+	}
+	s.checks.Wait()
+}
+
+// wait waits until ctx is done for the checks already running to finish, and reports whether
+// they did.
+func (s *stuckPodTracker) wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.checks.Wait()
+		close(done)
+	}()
+	return waitUntil(ctx, done)
+}
+
+// watchStuckPod checks, once the pending grace period has passed, whether the Pod named by a
+// stuck Pod event is still kept from starting, and if so reports its run as failed.
+func (e *EventHandler) watchStuckPod(event pkg.PodEvent, gracePeriod time.Duration) {
+	e.stuckPods.schedule(event.InvolvedObject.UID, gracePeriod, func() bool {
+		return e.checkStuckPod(event)
+	})
+}
+
+// checkStuckPod reports the run of the Pod named by event as failed if it is still Pending for
+// a reason it can't recover from by itself, and returns whether it did.
+func (e *EventHandler) checkStuckPod(event pkg.PodEvent) bool {
+	namespace, name := event.InvolvedObject.Namespace, event.InvolvedObject.Name
+	pod, _, job, cronjob, watched, err := e.FetchAndCheckPodEvent(namespace, name, false)
+	if err != nil || !watched {
+		return false
+	}
+	if pod.UID != event.InvolvedObject.UID || pod.Status.Phase != corev1.PodPending {
+		return false
+	}
+	message, stuck := stuckPodMessage(pod, &event)
+	if !stuck {
+		return false
+	}
+
+	slog.Info("pod is stuck pending",
+		"namespace", namespace,
+		"pod", name,
+		"message", message)
+	cronitorApi, err := e.collection.apiFor(cronjob.Namespace)
+	if err != nil {
+		slog.Warn("could not send pod event", "namespace", cronjob.Namespace, "cronjob", cronjob.Name, "error", err)
+		return false
+	}
+	e.collection.forwardTelemetry(cronjob, func() {
+		_ = cronitorApi.MakeAndSendTelemetryStuckPod(e.collection.context(), message, pod, job, cronjob)
+	})
+	return true
+}
+
+// stuckPodMessage describes what keeps a Pending Pod from starting, naming the container and
+// image at fault, if it is something the Pod can't recover from by itself.
+func stuckPodMessage(pod *corev1.Pod, event *pkg.PodEvent) (string, bool) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil && stuckContainerReasons[waiting.Reason] {
+			message := fmt.Sprintf("Pod %s did not start: container %q (image %q) is waiting with %s", pod.Name, status.Name, status.Image, waiting.Reason)
+			if waiting.Message != "" {
+				message += ": " + waiting.Message
+			}
+			return message, true
+		}
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			return fmt.Sprintf("Pod %s could not be scheduled: %s", pod.Name, condition.Message), true
+		}
+	}
+	// A volume whose Secret or ConfigMap is missing keeps the Pod from creating its containers
+	if event.Reason == "FailedMount" {
+		return fmt.Sprintf("Pod %s did not start: %s", pod.Name, event.Message), true
+	}
+	return "", false
+}
@@ -0,0 +1,155 @@
+package collector
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsStuckPodEvent(t *testing.T) {
+	for _, tc := range []struct {
+		reason, message string
+		expected        bool
+	}{
+		{"FailedScheduling", "0/3 nodes are available: 3 Insufficient memory.", true},
+		{"Failed", "Error: secret \"db-credentials\" not found", true},
+		{"FailedMount", "MountVolume.SetUp failed for volume \"config\" : configmap \"app\" not found", true},
+		{"BackOff", "Back-off pulling image \"registry.example.com/app:missing\"", true},
+		{"BackOff", "Back-off restarting failed container", false},
+		{"Started", "Started container main", false},
+	} {
+		event := &pkg.PodEvent{Reason: tc.reason, Message: tc.message}
+		if got := isStuckPodEvent(event); got != tc.expected {
+			t.Errorf("expected %s %q to be stuck=%t, got %t", tc.reason, tc.message, tc.expected, got)
+		}
+	}
+}
+
+func TestStuckPodMessage(t *testing.T) {
+	waiting := func(name, image, reason, message string) corev1.ContainerStatus {
+		return corev1.ContainerStatus{Name: name, Image: image, State: corev1.ContainerState{
+			Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: message},
+		}}
+	}
+	tests := []struct {
+		name     string
+		status   corev1.PodStatus
+		event    pkg.PodEvent
+		expected string
+	}{
+		{
+			name: "image pull",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				waiting("sidecar", "envoy:1.27", "PodInitializing", ""),
+				waiting("main", "app:missing", "ErrImagePull", "manifest unknown"),
+			}},
+			event:    pkg.PodEvent{Reason: "Failed"},
+			expected: `Pod job-abcde did not start: container "main" (image "app:missing") is waiting with ErrImagePull: manifest unknown`,
+		},
+		{
+			name: "missing Secret",
+			status: corev1.PodStatus{InitContainerStatuses: []corev1.ContainerStatus{
+				waiting("migrate", "app:1.0", "CreateContainerConfigError", `secret "db-credentials" not found`),
+			}},
+			event:    pkg.PodEvent{Reason: "Failed"},
+			expected: `Pod job-abcde did not start: container "migrate" (image "app:1.0") is waiting with CreateContainerConfigError: secret "db-credentials" not found`,
+		},
+		{
+			name: "unschedulable",
+			status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+				Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: "Unschedulable",
+				Message: "0/3 nodes are available: 3 Insufficient memory.",
+			}}},
+			event:    pkg.PodEvent{Reason: "FailedScheduling"},
+			expected: "Pod job-abcde could not be scheduled: 0/3 nodes are available: 3 Insufficient memory.",
+		},
+		{
+			name:     "missing volume",
+			status:   corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("main", "app:1.0", "ContainerCreating", "")}},
+			event:    pkg.PodEvent{Reason: "FailedMount", Message: `MountVolume.SetUp failed for volume "config" : secret "config" not found`},
+			expected: `Pod job-abcde did not start: MountVolume.SetUp failed for volume "config" : secret "config" not found`,
+		},
+		{
+			name:   "starting",
+			status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{waiting("main", "app:1.0", "ContainerCreating", "")}},
+			event:  pkg.PodEvent{Reason: "Failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "job-abcde"}, Status: tt.status}
+			message, stuck := stuckPodMessage(pod, &tt.event)
+			if stuck != (tt.expected != "") || message != tt.expected {
+				t.Errorf("expected %q, got %q (stuck=%t)", tt.expected, message, stuck)
+			}
+		})
+	}
+}
+
+func TestStuckPodTracker_SchedulesEachPodOnce(t *testing.T) {
+	var tracker stuckPodTracker
+	checks := make(chan bool)
+	check := func(reported bool) func() bool {
+		return func() bool {
+			checks <- reported
+			return reported
+		}
+	}
+	tracker.schedule("pod-a", 10*time.Millisecond, check(false))
+	tracker.schedule("pod-a", 0, check(false))
+	<-checks
+	tracker.flush()
+
+	tracker.schedule("pod-a", 0, check(true))
+	if !<-checks {
+		t.Fatal("expected a Pod that wasn't reported to be checked again")
+	}
+	tracker.flush()
+	tracker.schedule("pod-a", 0, check(true))
+	tracker.flush()
+	select {
+	case <-checks:
+		t.Error("expected a reported Pod not to be checked again")
+	default:
+	}
+}
+
+func TestStuckPodTracker_StopCancelsChecks(t *testing.T) {
+	var tracker stuckPodTracker
+	var checked atomic.Int32
+	check := func() bool {
+		checked.Add(1)
+		return false
+	}
+	tracker.schedule("pod-a", time.Hour, check)
+	tracker.stop()
+	tracker.schedule("pod-b", 0, check)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !tracker.wait(ctx) {
+		t.Fatal("expected no check to be left running")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if checked.Load() != 0 {
+		t.Errorf("expected no check to run once stopped, got %d", checked.Load())
+	}
+}
+
+func TestStuckPodTracker_FlushRunsScheduledChecks(t *testing.T) {
+	var tracker stuckPodTracker
+	var checked atomic.Int32
+	tracker.schedule("pod-a", time.Hour, func() bool {
+		checked.Add(1)
+		return true
+	})
+	tracker.flush()
+	if checked.Load() != 1 {
+		t.Errorf("expected the scheduled check to run right away, got %d checks", checked.Load())
+	}
+}
@@ -0,0 +1,171 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultSyncWindow is how long changes to a CronJob are collected before its monitor is
+// synced, so that bursts of updates (e.g. a Helm upgrade or a GitOps sync loop) are coalesced
+// into a single batched request.
+const DefaultSyncWindow = 2 * time.Second
+
+// syncQueue sits between the informer handlers and the Cronitor API. It keeps the latest
+// version of each queued CronJob, keyed by UID, so that repeated updates within the sync
+// window are sent once; CronJobs that fail to sync are retried with per-item backoff.
+type syncQueue struct {
+	queue workqueue.RateLimitingInterface
+
+	mu     sync.Mutex
+	latest map[types.UID]*v1.CronJob
+}
+
+// newSyncQueue creates a queue that retries each failed CronJob after initialBackoff,
+// doubling up to maxBackoff.
+func newSyncQueue(initialBackoff time.Duration, maxBackoff time.Duration) *syncQueue {
+	return &syncQueue{
+		queue: workqueue.NewNamedRateLimitingQueue(
+			workqueue.NewItemExponentialFailureRateLimiter(initialBackoff, maxBackoff),
+			"cronitor-monitor-sync"),
+		latest: make(map[types.UID]*v1.CronJob),
+	}
+}
+
+// add queues a CronJob to be synced once the window has passed. If the CronJob is already
+// queued, only its latest version is synced, at the time it was first queued for.
+func (q *syncQueue) add(cronjob *v1.CronJob, window time.Duration) {
+	q.mu.Lock()
+	q.latest[cronjob.GetUID()] = cronjob
+	q.mu.Unlock()
+	q.queue.AddAfter(cronjob.GetUID(), window)
+}
+
+// retry queues a CronJob that failed to sync again after its backoff, unless a newer
+// version of it has been queued in the meantime.
+func (q *syncQueue) retry(cronjob *v1.CronJob) {
+	q.mu.Lock()
+	if _, queued := q.latest[cronjob.GetUID()]; !queued {
+		q.latest[cronjob.GetUID()] = cronjob
+	}
+	q.mu.Unlock()
+	q.queue.AddRateLimited(cronjob.GetUID())
+}
+
+// forget drops a CronJob from the queue, e.g. once it is deleted.
+func (q *syncQueue) forget(uid types.UID) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	delete(q.latest, uid)
+	q.mu.Unlock()
+	q.queue.Forget(uid)
+}
+
+// next blocks until at least one CronJob is due, then returns every CronJob due by then,
+// so that they can be synced together. Each returned CronJob must be passed to done.
+// It returns false once the queue has been shut down.
+func (q *syncQueue) next() ([]*v1.CronJob, bool) {
+	item, shutdown := q.queue.Get()
+	if shutdown {
+		return nil, false
+	}
+	items := []interface{}{item}
+	for q.queue.Len() > 0 {
+		item, shutdown := q.queue.Get()
+		if shutdown {
+			break
+		}
+		items = append(items, item)
+	}
+
+	var cronjobs []*v1.CronJob
+	q.mu.Lock()
+	for _, item := range items {
+		uid := item.(types.UID)
+		cronjob, queued := q.latest[uid]
+		if !queued {
+			// Forgotten, or already synced through an earlier copy of the item
+			q.queue.Done(uid)
+			continue
+		}
+		delete(q.latest, uid)
+		cronjobs = append(cronjobs, cronjob)
+	}
+	q.mu.Unlock()
+	return cronjobs, true
+}
+
+// done marks a CronJob returned by next as processed. If it failed, it is retried with
+// backoff; otherwise its backoff is reset.
+func (q *syncQueue) done(cronjob *v1.CronJob, failed bool) {
+	if failed {
+		q.retry(cronjob)
+	} else {
+		q.queue.Forget(cronjob.GetUID())
+	}
+	q.queue.Done(cronjob.GetUID())
+}
+
+// shutDown stops the queue, and returns the CronJobs still queued, e.g. waiting out the sync
+// window or their backoff, which would otherwise never be synced.
+func (q *syncQueue) shutDown() []*v1.CronJob {
+	if q == nil {
+		return nil
+	}
+	q.queue.ShutDown()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := make([]*v1.CronJob, 0, len(q.latest))
+	for uid, cronjob := range q.latest {
+		queued = append(queued, cronjob)
+		delete(q.latest, uid)
+	}
+	return queued
+}
+
+// queueSync schedules a CronJob's monitor to be synced once the sync window has passed,
+// together with any other CronJobs changed in the meantime. The telemetry of a CronJob that
+// isn't tracked yet is held until its monitor is synced. Without a queue, the monitor is
+// synced right away.
+func (coll *CronJobCollection) queueSync(cronjob *v1.CronJob) {
+	if coll.syncQueue == nil {
+		// Errors are reported in AddCronJob
+		_ = coll.AddCronJob(cronjob)
+		return
+	}
+	if !coll.IsTracked(cronjob.GetUID()) {
+		coll.markPendingSync(cronjob)
+	}
+	coll.syncQueue.add(cronjob, coll.config.Get().SyncWindow)
+}
+
+// runSyncQueue syncs queued CronJobs in batches until the queue is shut down.
+func (coll *CronJobCollection) runSyncQueue() {
+	for {
+		cronjobs, ok := coll.syncQueue.next()
+		if !ok {
+			return
+		}
+		retrying, err := coll.syncCronJobs(coll.context(), cronjobs)
+		if len(retrying) > 0 {
+			slog.Warn("could not sync cronjobs to Cronitor, will retry",
+				"retry_count", len(retrying),
+				"error", err)
+		}
+		for _, cronjob := range cronjobs {
+			_, failed := retrying[cronjob.GetUID()]
+			if failed && coll.syncQueue.queue.NumRequeues(cronjob.GetUID()) == 0 {
+				// Only the first failure is reported, rather than every retry
+				coll.reportCronJobError(cronjob, fmt.Errorf("error adding cronjob to Cronitor: %w", err))
+			}
+			coll.syncQueue.done(cronjob, failed)
+		}
+	}
+}
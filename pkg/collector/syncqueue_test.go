@@ -0,0 +1,136 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// waitForTracked waits for the sync queue to have synced the CronJob with the given UID.
+func waitForTracked(t *testing.T, coll *CronJobCollection, uid string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !coll.IsTracked(types.UID(uid)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be synced", uid)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSyncQueue_CoalescesUpdatesIntoOneRequest(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.config = config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL, SyncWindow: 50 * time.Millisecond})
+	coll.syncQueue = newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer coll.syncQueue.shutDown()
+
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+	coll.queueSync(cronjob)
+	for _, schedule := range []string{"*/10 * * * *", "*/15 * * * *"} {
+		updated := cronjob.DeepCopy()
+		updated.Spec.Schedule = schedule
+		coll.queueSync(updated)
+	}
+	coll.queueSync(createTestCronJob("job-b", "default", "uid-b", "0 * * * *"))
+
+	// Telemetry is held until the new CronJob's monitor is synced
+	if !coll.IsPendingSync("uid-a") {
+		t.Error("expected a queued CronJob to be pending sync")
+	}
+
+	go coll.runSyncQueue()
+	waitForTracked(t, coll, "uid-a")
+	waitForTracked(t, coll, "uid-b")
+
+	if count := mockServer.getRequestCount(); count != 1 {
+		t.Fatalf("expected a single batched request, got %d", count)
+	}
+	var monitors []api.CronitorJob
+	mockServer.mu.Lock()
+	err := json.Unmarshal([]byte(mockServer.lastBody), &monitors)
+	mockServer.mu.Unlock()
+	if err != nil {
+		t.Fatalf("could not decode the request body: %v", err)
+	}
+	if len(monitors) != 2 {
+		t.Fatalf("expected each CronJob to be sent once, got %d monitors", len(monitors))
+	}
+	synced, _ := coll.GetCronJob("uid-a")
+	if synced.Spec.Schedule != "*/15 * * * *" {
+		t.Errorf("expected the latest version to be synced, got schedule %q", synced.Spec.Schedule)
+	}
+}
+
+func TestSyncQueue_RetriesFailedCronJobs(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+	mockServer.setStatus(http.StatusServiceUnavailable)
+
+	coll := createTestCollection(mockServer)
+	coll.syncQueue = newSyncQueue(20*time.Millisecond, 20*time.Millisecond)
+	defer coll.syncQueue.shutDown()
+	go coll.runSyncQueue()
+
+	coll.queueSync(createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"))
+	deadline := time.Now().Add(2 * time.Second)
+	for mockServer.getRequestCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the failed sync to be retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if coll.IsTracked("uid-a") || !coll.IsPendingSync("uid-a") {
+		t.Error("expected the CronJob to stay pending while Cronitor is unavailable")
+	}
+
+	mockServer.setStatus(http.StatusOK)
+	waitForTracked(t, coll, "uid-a")
+	if coll.IsPendingSync("uid-a") {
+		t.Error("expected the CronJob to no longer be pending once synced")
+	}
+}
+
+func TestSyncQueue_DeletedCronJobIsNotSynced(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.config = config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL, SyncWindow: 20 * time.Millisecond})
+	coll.syncQueue = newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	defer coll.syncQueue.shutDown()
+	go coll.runSyncQueue()
+
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+	coll.queueSync(cronjob)
+	onDelete(coll, cronjob)
+
+	time.Sleep(100 * time.Millisecond)
+	if count := mockServer.getRequestCount(); count != 0 {
+		t.Errorf("expected a CronJob deleted while queued not to be synced, got %d requests", count)
+	}
+	if coll.IsTracked("uid-a") || coll.IsPendingSync("uid-a") {
+		t.Error("expected the deleted CronJob to be neither tracked nor pending")
+	}
+}
+
+func TestSyncQueue_ShutDownReturnsQueuedCronJobs(t *testing.T) {
+	queue := newSyncQueue(time.Millisecond, 10*time.Millisecond)
+	queue.add(createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *"), time.Hour)
+	queue.retry(createTestCronJob("job-b", "default", "uid-b", "0 * * * *"))
+
+	queued := queue.shutDown()
+	if len(queued) != 2 {
+		t.Fatalf("expected both CronJobs still queued to be returned, got %d", len(queued))
+	}
+	if _, ok := queue.next(); ok {
+		t.Error("expected the queue to be shut down")
+	}
+}
@@ -0,0 +1,196 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// syncStateFlushInterval is how often changed monitor hashes are written to the ConfigMap.
+const syncStateFlushInterval = 30 * time.Second
+
+// syncStateSaveTimeout bounds the last save on shutdown, which happens after the shutdown
+// deadline may have passed.
+const syncStateSaveTimeout = 5 * time.Second
+
+// syncState remembers a hash of the monitor last synced for each CronJob, so that monitors
+// that haven't changed aren't sent to Cronitor again. The hashes can be persisted to a
+// ConfigMap to survive restarts. A nil *syncState never skips a sync.
+type syncState struct {
+	clientset kubernetes.Interface
+	// hash returns a hash of the monitor synced for a CronJob, or "" if it can't be synced
+	hash func(cronjob *v1.CronJob) string
+	// configMapNamespace and configMapName locate the ConfigMap the hashes are persisted to;
+	// empty when they are only kept in memory
+	configMapNamespace string
+	configMapName      string
+
+	mu     sync.Mutex
+	hashes map[types.UID]string
+	dirty  bool
+}
+
+// newSyncState creates the sync state, persisted to configMap ("namespace/name") if it is set.
+func newSyncState(clientset kubernetes.Interface, configMap string, hash func(cronjob *v1.CronJob) string) (*syncState, error) {
+	state := &syncState{clientset: clientset, hash: hash, hashes: make(map[types.UID]string)}
+	if configMap != "" {
+		namespace, name, ok := strings.Cut(configMap, "/")
+		if !ok || namespace == "" || name == "" {
+			return nil, fmt.Errorf("invalid sync state ConfigMap %q (must be namespace/name)", configMap)
+		}
+		state.configMapNamespace, state.configMapName = namespace, name
+	}
+	return state, nil
+}
+
+func (s *syncState) persisted() bool {
+	return s.configMapName != "" && s.clientset != nil
+}
+
+// unchanged reports whether the CronJob's monitor is the same as the one last synced.
+func (s *syncState) unchanged(cronjob *v1.CronJob) bool {
+	if s == nil {
+		return false
+	}
+	hash := s.hash(cronjob)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return hash != "" && s.hashes[cronjob.GetUID()] == hash
+}
+
+// record remembers the monitors of CronJobs that have just been synced.
+func (s *syncState) record(cronjobs ...*v1.CronJob) {
+	if s == nil {
+		return
+	}
+	for _, cronjob := range cronjobs {
+		hash := s.hash(cronjob)
+		s.mu.Lock()
+		if hash != "" && s.hashes[cronjob.GetUID()] != hash {
+			s.hashes[cronjob.GetUID()] = hash
+			s.dirty = true
+		}
+		s.mu.Unlock()
+	}
+}
+
+// forget drops a CronJob's hash, so that its monitor is synced again if it comes back.
+func (s *syncState) forget(uid types.UID) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hashes[uid]; ok {
+		delete(s.hashes, uid)
+		s.dirty = true
+	}
+}
+
+// prune drops the hashes of the CronJobs not in cronjobs, e.g. those deleted while the agent
+// wasn't running, so that the ConfigMap doesn't keep growing.
+func (s *syncState) prune(cronjobs []*v1.CronJob) {
+	if s == nil {
+		return
+	}
+	listed := make(map[types.UID]bool, len(cronjobs))
+	for _, cronjob := range cronjobs {
+		listed[cronjob.GetUID()] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uid := range s.hashes {
+		if !listed[uid] {
+			delete(s.hashes, uid)
+			s.dirty = true
+		}
+	}
+}
+
+// load reads the hashes persisted by a previous run of the agent.
+func (s *syncState) load(ctx context.Context) error {
+	if s == nil || !s.persisted() {
+		return nil
+	}
+	configMap, err := s.clientset.CoreV1().ConfigMaps(s.configMapNamespace).Get(ctx, s.configMapName, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uid, hash := range configMap.Data {
+		s.hashes[types.UID(uid)] = hash
+	}
+	slog.Info("loaded synced monitor hashes",
+		"configmap", s.configMapNamespace+"/"+s.configMapName,
+		"count", len(configMap.Data))
+	return nil
+}
+
+// save writes the hashes to the ConfigMap if they changed since the last save.
+func (s *syncState) save(ctx context.Context) error {
+	if s == nil || !s.persisted() {
+		return nil
+	}
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data := make(map[string]string, len(s.hashes))
+	for uid, hash := range s.hashes {
+		data[string(uid)] = hash
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	configMaps := s.clientset.CoreV1().ConfigMaps(s.configMapNamespace)
+	configMap, err := configMaps.Get(ctx, s.configMapName, meta_v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: meta_v1.ObjectMeta{Namespace: s.configMapNamespace, Name: s.configMapName},
+			Data:       data,
+		}, meta_v1.CreateOptions{})
+	} else if err == nil {
+		configMap.Data = data
+		_, err = configMaps.Update(ctx, configMap, meta_v1.UpdateOptions{})
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// run saves the hashes periodically until stop is closed.
+func (s *syncState) run(stop <-chan struct{}) {
+	if s == nil || !s.persisted() {
+		return
+	}
+	ticker := time.NewTicker(syncStateFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		if err := s.save(context.Background()); err != nil {
+			slog.Error("could not save synced monitor hashes", "error", err)
+		}
+	}
+}
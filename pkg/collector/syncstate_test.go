@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAddCronJob_SkipsUnchangedMonitors(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.syncState, _ = newSyncState(nil, "", coll.monitorHash)
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+
+	if err := coll.AddCronJob(cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := coll.AddCronJob(cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count := mockServer.getRequestCount(); count != 1 {
+		t.Errorf("expected the unchanged monitor to be sent once, got %d requests", count)
+	}
+
+	updated := cronjob.DeepCopy()
+	updated.Spec.Schedule = "*/10 * * * *"
+	if err := coll.AddCronJob(updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count := mockServer.getRequestCount(); count != 2 {
+		t.Errorf("expected the changed monitor to be sent, got %d requests", count)
+	}
+
+	// Once the CronJob stops being watched, its monitor is sent again if it comes back
+	coll.RemoveCronJob(updated)
+	if err := coll.AddCronJob(updated); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count := mockServer.getRequestCount(); count != 3 {
+		t.Errorf("expected the monitor to be sent again after removal, got %d requests", count)
+	}
+}
+
+func TestAddCronJob_DryRunDoesNotRecordMonitors(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.config = config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL, DryRun: true})
+	coll.syncState, _ = newSyncState(nil, "", coll.monitorHash)
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+
+	if err := coll.AddCronJob(cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if coll.syncState.unchanged(cronjob) {
+		t.Error("expected a monitor that was only recorded in dry run not to count as synced")
+	}
+}
+
+func TestSyncPending_SkipsUnchangedMonitors(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	coll := createTestCollection(mockServer)
+	coll.syncState, _ = newSyncState(nil, "", coll.monitorHash)
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+	coll.syncState.record(cronjob)
+
+	coll.markPendingSync(cronjob)
+	if err := coll.syncPending(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count := mockServer.getRequestCount(); count != 0 {
+		t.Errorf("expected no request for an unchanged monitor, got %d", count)
+	}
+	if !coll.IsTracked("uid-a") {
+		t.Error("expected the unchanged CronJob to be tracked")
+	}
+}
+
+func TestSyncState_PersistsToConfigMap(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	cronjob := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+
+	state, err := newSyncState(clientset, "cronitor/sync-state", api.CronitorApi{}.MonitorHash)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	state.record(cronjob)
+	if err := state.save(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restarted, _ := newSyncState(clientset, "cronitor/sync-state", api.CronitorApi{}.MonitorHash)
+	if err := restarted.load(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !restarted.unchanged(cronjob) {
+		t.Error("expected the hash saved before the restart to be loaded")
+	}
+
+	// Updating the existing ConfigMap
+	restarted.forget(cronjob.UID)
+	if err := restarted.save(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configMap, _ := clientset.CoreV1().ConfigMaps("cronitor").Get(context.Background(), "sync-state", metav1.GetOptions{})
+	if len(configMap.Data) != 0 {
+		t.Errorf("expected the forgotten hash to be removed, got %v", configMap.Data)
+	}
+
+	if _, err := newSyncState(clientset, "sync-state", api.CronitorApi{}.MonitorHash); err == nil {
+		t.Error("expected an error for a ConfigMap without a namespace")
+	}
+}
+
+func TestLoadAllExistingCronJobs_PrunesSyncState(t *testing.T) {
+	mockServer := newMockAPIServer()
+	defer mockServer.close()
+
+	kept := createTestCronJob("job-a", "default", "uid-a", "*/5 * * * *")
+	deleted := createTestCronJob("job-b", "default", "uid-b", "*/5 * * * *")
+	clientset := fake.NewSimpleClientset(kept)
+	previous, _ := newSyncState(clientset, "cronitor/sync-state", api.CronitorApi{}.MonitorHash)
+	previous.record(kept, deleted)
+	if err := previous.save(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The CronJob deleted while the agent was down is no longer listed
+	cfg := config.NewStore(&config.Config{HostnameOverride: mockServer.server.URL})
+	coll := &CronJobCollection{
+		clientset:     clientset,
+		serverVersion: &version.Info{Major: "1", Minor: "25"},
+		cronitorApi:   &api.CronitorApi{ApiKey: "test-key", UserAgent: "test-agent", Config: cfg},
+		cronjobs:      make(map[types.UID]*v1.CronJob),
+		config:        cfg,
+	}
+	coll.syncState, _ = newSyncState(clientset, "cronitor/sync-state", api.CronitorApi{}.MonitorHash)
+	if err := coll.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := coll.syncState.save(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	configMap, _ := clientset.CoreV1().ConfigMaps("cronitor").Get(context.Background(), "sync-state", metav1.GetOptions{})
+	if _, ok := configMap.Data["uid-b"]; ok || len(configMap.Data) != 1 {
+		t.Errorf("expected only the hash of the listed CronJob to be kept, got %v", configMap.Data)
+	}
+}
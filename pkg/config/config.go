@@ -0,0 +1,461 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/transport"
+	"github.com/spf13/viper"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Keys under which settings are read from flags, the environment and the config file.
+// In the config file, they are used as top-level YAML keys.
+const (
+	KeyConfigFile         = "config"
+	KeyApiKey             = "apikey"
+	KeyApiKeyFile         = "apikey-file"
+	KeyTelemetryKey       = "telemetry-key"
+	KeyKubeconfig         = "kubeconfig"
+	KeyHostnameOverride   = "hostname-override"
+	KeyDev                = "dev"
+	KeyDryRun             = "dryrun"
+	KeyDryRunOutput       = "dryrun-output"
+	KeyDryRunMaxSize      = "dryrun-max-size"
+	KeyDryRunDiff         = "dryrun-diff"
+	KeyLogLevel           = "log-level"
+	KeyLogFormat          = "log-format"
+	KeyVersion            = "version"
+	KeyShipLogs           = "ship-logs"
+	KeyPodFilter          = "pod-filter"
+	KeyNamespaces         = "namespace"
+	KeyNamespaceExclude   = "namespace-exclude"
+	KeyNamespaceSelector  = "namespace-selector"
+	KeyCronJobSelector    = "cronjob-selector"
+	KeyMetricsAddress     = "metrics-address"
+	KeySyncChunkSize      = "sync-chunk-size"
+	KeySyncConcurrency    = "sync-concurrency"
+	KeySyncStateConfigMap = "sync-state-configmap"
+	KeySyncWindow         = "sync-window"
+	KeyShutdownTimeout    = "shutdown-timeout"
+	KeyMissedRunMargin    = "missed-run-margin"
+	KeyMissedRunAction    = "missed-run-action"
+	KeyPendingGracePeriod = "pending-grace-period"
+	KeyEventRules         = "event-rules"
+	KeySidecarCompletion  = "sidecar-completion"
+	KeySidecarContainers  = "sidecar-containers"
+	KeyMainContainerLogs  = "main-container-logs"
+	KeyDefaultBehavior    = "default-behavior"
+	KeyDefaultEnvironment = "default-env"
+	KeyTags               = "tags"
+	KeyAccounts           = "accounts"
+	KeyProxy              = "proxy"
+	KeyCAFile             = "ca-file"
+	KeyClientCertFile     = "client-cert-file"
+	KeyClientKeyFile      = "client-key-file"
+	KeyConnectTimeout     = "connect-timeout"
+	KeyResponseTimeout    = "response-timeout"
+)
+
+// Default keys under which an account's Secret holds its API and telemetry keys
+const (
+	DefaultAccountApiKeyKey       = "CRONITOR_API_KEY"
+	DefaultAccountTelemetryKeyKey = "CRONITOR_TELEMETRY_KEY"
+)
+
+// Account sends the monitors and telemetry of the CronJobs in some namespaces to a separate
+// Cronitor account, whose keys are read from a Secret. A namespace belongs to the first
+// account that lists it or whose namespace selector matches its labels.
+type Account struct {
+	Name string `mapstructure:"name"`
+	// Namespaces, as globs (e.g. team-a-*) or /regular expressions/
+	Namespaces        []string `mapstructure:"namespaces"`
+	NamespaceSelector string   `mapstructure:"namespace-selector"`
+	// Secret ("namespace/name") holding the account's keys, under ApiKeyKey and the optional TelemetryKeyKey
+	Secret          string `mapstructure:"secret"`
+	ApiKeyKey       string `mapstructure:"apikey-key"`
+	TelemetryKeyKey string `mapstructure:"telemetry-key-key"`
+}
+
+// Config is the agent's configuration, merged from (in order of precedence) command-line
+// flags, environment variables and the optional YAML config file.
+type Config struct {
+	ConfigFile       string
+	ApiKey           string
+	ApiKeyFile       string
+	TelemetryKey     string
+	Kubeconfig       string
+	HostnameOverride string
+	Dev              bool
+	DryRun           bool
+	LogLevel         string
+	LogFormat        string
+	Version          string
+
+	// Where dry-run records go ("" or "-" for stdout), the size in megabytes at which the file
+	// is rotated, and whether monitors are compared with those in Cronitor
+	DryRunOutput  string
+	DryRunMaxSize int
+	DryRunDiff    bool
+
+	ShipLogs  bool
+	PodFilter string
+
+	Namespaces        []string
+	NamespaceExclude  []string
+	NamespaceSelector string
+	CronJobSelector   string
+	MetricsAddress    string
+
+	// How many monitors to send per request, and how many requests to send at once, when syncing
+	SyncChunkSize   int
+	SyncConcurrency int
+	// Optional ConfigMap ("namespace/name") in which to persist the hashes of synced monitors
+	SyncStateConfigMap string
+	// How long to collect changes to a CronJob before syncing its monitor
+	SyncWindow time.Duration
+	// How long to keep sending the telemetry already collected when the agent is asked to exit
+	ShutdownTimeout time.Duration
+
+	// How late a scheduled run may be before it is reported as missed (0 disables the check),
+	// and whether it is reported as a failure ("fail") or only logged ("log")
+	MissedRunMargin time.Duration
+	MissedRunAction string
+
+	// How long a Pod may be kept from starting (e.g. by an image that can't be pulled) before
+	// its run is reported as failed; 0 disables the check
+	PendingGracePeriod time.Duration
+
+	// Whether to watch the Pods of Jobs and report a run once its main containers have exited,
+	// even while sidecar containers (by name, defaulting to collector.DefaultSidecarContainers)
+	// keep its Pod running, and whether to collect logs from the main containers only
+	SidecarCompletion bool
+	SidecarContainers []string
+	MainContainerLogs bool
+
+	// Rules mapping Kubernetes events to the state they are reported as, checked before
+	// pkg.DefaultEventRules
+	EventRules []pkg.EventRule
+
+	// Chart-wide defaults for CronJobs that don't set their own annotations
+	DefaultBehavior    string
+	DefaultEnvironment string
+	Tags               []string
+
+	// Separate Cronitor accounts for some namespaces; the others use ApiKey
+	Accounts []Account
+
+	// HTTP client settings for every outbound request
+	Proxy           string
+	CAFile          string
+	ClientCertFile  string
+	ClientKeyFile   string
+	ConnectTimeout  time.Duration
+	ResponseTimeout time.Duration
+
+	podFilter *regexp.Regexp
+}
+
+// Load reads the config file, if one is configured, and builds a validated Config from v.
+func Load(v *viper.Viper) (*Config, error) {
+	configFile := v.GetString(KeyConfigFile)
+	if configFile != "" {
+		v.SetConfigFile(configFile)
+		v.SetConfigType("yaml")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("could not read config file %s: %w", configFile, err)
+		}
+	}
+
+	cfg := &Config{
+		ConfigFile:         configFile,
+		ApiKey:             v.GetString(KeyApiKey),
+		ApiKeyFile:         v.GetString(KeyApiKeyFile),
+		TelemetryKey:       v.GetString(KeyTelemetryKey),
+		Kubeconfig:         v.GetString(KeyKubeconfig),
+		HostnameOverride:   v.GetString(KeyHostnameOverride),
+		Dev:                v.GetBool(KeyDev),
+		DryRun:             v.GetBool(KeyDryRun),
+		DryRunOutput:       v.GetString(KeyDryRunOutput),
+		DryRunMaxSize:      v.GetInt(KeyDryRunMaxSize),
+		DryRunDiff:         v.GetBool(KeyDryRunDiff),
+		LogLevel:           v.GetString(KeyLogLevel),
+		LogFormat:          v.GetString(KeyLogFormat),
+		Version:            v.GetString(KeyVersion),
+		ShipLogs:           v.GetBool(KeyShipLogs),
+		PodFilter:          v.GetString(KeyPodFilter),
+		Namespaces:         getStringList(v, KeyNamespaces),
+		NamespaceExclude:   getStringList(v, KeyNamespaceExclude),
+		NamespaceSelector:  v.GetString(KeyNamespaceSelector),
+		CronJobSelector:    v.GetString(KeyCronJobSelector),
+		MetricsAddress:     v.GetString(KeyMetricsAddress),
+		SyncChunkSize:      v.GetInt(KeySyncChunkSize),
+		SyncConcurrency:    v.GetInt(KeySyncConcurrency),
+		SyncStateConfigMap: v.GetString(KeySyncStateConfigMap),
+		SyncWindow:         v.GetDuration(KeySyncWindow),
+		ShutdownTimeout:    v.GetDuration(KeyShutdownTimeout),
+		MissedRunMargin:    v.GetDuration(KeyMissedRunMargin),
+		MissedRunAction:    v.GetString(KeyMissedRunAction),
+		PendingGracePeriod: v.GetDuration(KeyPendingGracePeriod),
+		SidecarCompletion:  v.GetBool(KeySidecarCompletion),
+		SidecarContainers:  getStringList(v, KeySidecarContainers),
+		MainContainerLogs:  v.GetBool(KeyMainContainerLogs),
+		DefaultBehavior:    v.GetString(KeyDefaultBehavior),
+		DefaultEnvironment: v.GetString(KeyDefaultEnvironment),
+		Tags:               getStringList(v, KeyTags),
+		Proxy:              v.GetString(KeyProxy),
+		CAFile:             v.GetString(KeyCAFile),
+		ClientCertFile:     v.GetString(KeyClientCertFile),
+		ClientKeyFile:      v.GetString(KeyClientKeyFile),
+		ConnectTimeout:     v.GetDuration(KeyConnectTimeout),
+		ResponseTimeout:    v.GetDuration(KeyResponseTimeout),
+	}
+	eventRules, err := getEventRules(v, KeyEventRules)
+	if err != nil {
+		return nil, err
+	}
+	cfg.EventRules = eventRules
+	if err := v.UnmarshalKey(KeyAccounts, &cfg.Accounts); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", KeyAccounts, err)
+	}
+	for i := range cfg.Accounts {
+		if cfg.Accounts[i].ApiKeyKey == "" {
+			cfg.Accounts[i].ApiKeyKey = DefaultAccountApiKeyKey
+		}
+		if cfg.Accounts[i].TelemetryKeyKey == "" {
+			cfg.Accounts[i].TelemetryKeyKey = DefaultAccountTelemetryKeyKey
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// getStringList reads a list setting that may come from a repeated/comma-separated flag,
+// a comma-separated environment variable, or a YAML list.
+func getStringList(v *viper.Viper, key string) []string {
+	var values []string
+	for _, value := range v.GetStringSlice(key) {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// getEventRules reads a list of event rules, from YAML in the config file, or from JSON in a flag
+// or environment variable.
+func getEventRules(v *viper.Viper, key string) ([]pkg.EventRule, error) {
+	if data, ok := v.Get(key).(string); ok {
+		if strings.TrimSpace(data) == "" {
+			return nil, nil
+		}
+		var rules []pkg.EventRule
+		if err := json.Unmarshal([]byte(data), &rules); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		return rules, nil
+	}
+	var rules []pkg.EventRule
+	if err := v.UnmarshalKey(key, &rules); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return rules, nil
+}
+
+// Validate checks every setting, reporting all the problems it finds at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.ApiKey == "<api key>" {
+		errs = append(errs, errors.New("a valid api key is required. You used the string '<api key>' as the api key, which is invalid"))
+	} else if c.ApiKey != "" && !regexp.MustCompile(`[\w0-9]+`).MatchString(c.ApiKey) {
+		errs = append(errs, errors.New("you have provided an invalid API key. Cronitor API keys are comprised only of number and letter characters"))
+	}
+	if c.ApiKey != "" && c.ApiKeyFile != "" {
+		errs = append(errs, fmt.Errorf("provide either %s or %s, not both", KeyApiKey, KeyApiKeyFile))
+	}
+	if c.TelemetryKey == "<telemetry key>" {
+		errs = append(errs, errors.New("you used the string '<telemetry key>' as the telemetry key, which is invalid"))
+	} else if c.TelemetryKey != "" && !regexp.MustCompile(`[\w0-9]+`).MatchString(c.TelemetryKey) {
+		errs = append(errs, errors.New("you have provided an invalid telemetry key. Cronitor telemetry keys are comprised only of number and letter characters"))
+	}
+
+	switch c.LogLevel {
+	case "", "DEBUG", "INFO", "WARN", "ERROR":
+	default:
+		errs = append(errs, fmt.Errorf("invalid %s %q (must be DEBUG, INFO, WARN or ERROR)", KeyLogLevel, c.LogLevel))
+	}
+	switch c.LogFormat {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("invalid %s %q (must be 'text' or 'json')", KeyLogFormat, c.LogFormat))
+	}
+	switch c.DefaultBehavior {
+	case "", "include", "exclude":
+	default:
+		errs = append(errs, fmt.Errorf("invalid %s %q (must be 'include' or 'exclude')", KeyDefaultBehavior, c.DefaultBehavior))
+	}
+	switch c.MissedRunAction {
+	case "", "fail", "log":
+	default:
+		errs = append(errs, fmt.Errorf("invalid %s %q (must be 'fail' or 'log')", KeyMissedRunAction, c.MissedRunAction))
+	}
+
+	for i := range c.EventRules {
+		if err := c.EventRules[i].Compile(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s[%d]: %w", KeyEventRules, i, err))
+		}
+	}
+
+	if c.DryRunMaxSize < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %d (must not be negative)", KeyDryRunMaxSize, c.DryRunMaxSize))
+	}
+	if c.SyncChunkSize < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %d (must not be negative)", KeySyncChunkSize, c.SyncChunkSize))
+	}
+	if c.SyncConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %d (must not be negative)", KeySyncConcurrency, c.SyncConcurrency))
+	}
+	if c.SyncWindow < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %s (must not be negative)", KeySyncWindow, c.SyncWindow))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %s (must not be negative)", KeyShutdownTimeout, c.ShutdownTimeout))
+	}
+	if c.PendingGracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %s (must not be negative)", KeyPendingGracePeriod, c.PendingGracePeriod))
+	}
+	if c.MissedRunMargin < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %s (must not be negative)", KeyMissedRunMargin, c.MissedRunMargin))
+	}
+
+	if c.SyncStateConfigMap != "" {
+		if namespace, name, ok := strings.Cut(c.SyncStateConfigMap, "/"); !ok || namespace == "" || name == "" {
+			errs = append(errs, fmt.Errorf("invalid %s %q (must be namespace/name)", KeySyncStateConfigMap, c.SyncStateConfigMap))
+		}
+	}
+
+	if c.Proxy != "" {
+		if proxyURL, err := url.Parse(c.Proxy); err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid %s %q (must be a URL such as http://proxy.example.com:3128)", KeyProxy, c.Proxy))
+		}
+	}
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		errs = append(errs, fmt.Errorf("provide both %s and %s, or neither", KeyClientCertFile, KeyClientKeyFile))
+	}
+	if c.ConnectTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %s (must not be negative)", KeyConnectTimeout, c.ConnectTimeout))
+	}
+	if c.ResponseTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid %s %s (must not be negative)", KeyResponseTimeout, c.ResponseTimeout))
+	}
+
+	accountNames := make(map[string]bool)
+	for i, account := range c.Accounts {
+		if account.Name == "" {
+			errs = append(errs, fmt.Errorf("%s[%d] has no name", KeyAccounts, i))
+		} else if accountNames[account.Name] {
+			errs = append(errs, fmt.Errorf("%s[%d]: duplicate account name %q", KeyAccounts, i, account.Name))
+		}
+		accountNames[account.Name] = true
+		if len(account.Namespaces) == 0 && account.NamespaceSelector == "" {
+			errs = append(errs, fmt.Errorf("account %q must list namespaces or set a namespace-selector", account.Name))
+		}
+		if _, err := labels.Parse(account.NamespaceSelector); err != nil {
+			errs = append(errs, fmt.Errorf("account %q has an invalid namespace-selector %q: %w", account.Name, account.NamespaceSelector, err))
+		}
+		if namespace, name, ok := strings.Cut(account.Secret, "/"); !ok || namespace == "" || name == "" {
+			errs = append(errs, fmt.Errorf("account %q has an invalid secret %q (must be namespace/name)", account.Name, account.Secret))
+		}
+	}
+
+	c.podFilter = nil
+	if c.PodFilter != "" {
+		podFilter, err := regexp.Compile(c.PodFilter)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s %q: %w", KeyPodFilter, c.PodFilter, err))
+		}
+		c.podFilter = podFilter
+	}
+
+	if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s %q: %w", KeyNamespaceSelector, c.NamespaceSelector, err))
+	}
+	if _, err := labels.Parse(c.CronJobSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s %q: %w", KeyCronJobSelector, c.CronJobSelector, err))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// PodFilterRegexp returns the compiled pod filter, or nil if no filter is configured.
+func (c *Config) PodFilterRegexp() *regexp.Regexp {
+	if c == nil {
+		return nil
+	}
+	return c.podFilter
+}
+
+// EventState returns the state an event with kind, reason and message is reported as: that of
+// the first of cronjobRules, the EventRules, and pkg.DefaultEventRules to match it, or
+// pkg.EventStateIgnore if none does.
+func (c *Config) EventState(kind, reason, message string, cronjobRules []pkg.EventRule) string {
+	if state, ok := c.MatchEventRules(kind, reason, message, cronjobRules); ok {
+		return state
+	}
+	if state, ok := pkg.MatchEventRules(kind, reason, message, pkg.DefaultEventRules); ok {
+		return state
+	}
+	return pkg.EventStateIgnore
+}
+
+// MatchEventRules returns the state of the first of cronjobRules and the EventRules to match an
+// event with kind, reason and message.
+func (c *Config) MatchEventRules(kind, reason, message string, cronjobRules []pkg.EventRule) (string, bool) {
+	if c == nil {
+		return pkg.MatchEventRules(kind, reason, message, cronjobRules)
+	}
+	return pkg.MatchEventRules(kind, reason, message, cronjobRules, c.EventRules)
+}
+
+// ChartDefaults returns the agent-wide CronJob defaults.
+func (c *Config) ChartDefaults() pkg.ChartDefaults {
+	return pkg.ChartDefaults{
+		Behavior:    c.DefaultBehavior,
+		Environment: c.DefaultEnvironment,
+		Tags:        c.Tags,
+	}
+}
+
+// TransportOptions returns the settings of the HTTP client shared by every outbound request.
+func (c *Config) TransportOptions() transport.Options {
+	return transport.Options{
+		ProxyURL:        c.Proxy,
+		CAFile:          c.CAFile,
+		ClientCertFile:  c.ClientCertFile,
+		ClientKeyFile:   c.ClientKeyFile,
+		ConnectTimeout:  c.ConnectTimeout,
+		ResponseTimeout: c.ResponseTimeout,
+	}
+}
+
+// Hostname returns the base URL to send a request to: the override, if configured, or defaultHostname.
+func (c *Config) Hostname(defaultHostname string) string {
+	if c != nil && c.HostnameOverride != "" {
+		return c.HostnameOverride
+	}
+	return defaultHostname
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/spf13/viper"
+)
+
+// writeConfigFile replaces the config file atomically, as Kubernetes does for a mounted
+// ConfigMap, so that a watcher never reads it half-written.
+func writeConfigFile(t *testing.T, path string, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path+".tmp", []byte(contents), 0o644); err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatalf("could not replace config file: %v", err)
+	}
+}
+
+func TestValidate_ReportsEveryError(t *testing.T) {
+	cfg := &Config{
+		ApiKey:          "<api key>",
+		TelemetryKey:    "<telemetry key>",
+		LogLevel:        "verbose",
+		DefaultBehavior: "sometimes",
+		PodFilter:       "job-[",
+		CronJobSelector: "tier in (prod",
+		Proxy:           "proxy:3128",
+		ClientCertFile:  "/etc/cronitor/client.crt",
+		MissedRunAction: "page",
+		EventRules:      []pkg.EventRule{{Reason: "Completed", State: "done"}},
+	}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error for an invalid config")
+	}
+	for _, expected := range []string{"'<api key>'", "'<telemetry key>'", KeyLogLevel, KeyDefaultBehavior, KeyPodFilter, KeyCronJobSelector, KeyProxy, KeyClientKeyFile, KeyMissedRunAction, KeyEventRules} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected the error to mention %q, got: %v", expected, err)
+		}
+	}
+}
+
+func TestLoad_ReadsConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, `
+ship-logs: true
+pod-filter: "^job-"
+namespace:
+  - team-a
+  - team-b
+tags: [prod, "billing"]
+`)
+
+	v := viper.New()
+	v.Set(KeyConfigFile, path)
+	v.Set(KeyApiKey, "abc123")
+	cfg, err := Load(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !cfg.ShipLogs {
+		t.Error("expected ship-logs from the config file")
+	}
+	if cfg.PodFilterRegexp() == nil || !cfg.PodFilterRegexp().MatchString("job-123") {
+		t.Error("expected the pod filter to be compiled")
+	}
+	if len(cfg.Namespaces) != 2 || cfg.Namespaces[1] != "team-b" {
+		t.Errorf("expected namespaces from the config file, got %v", cfg.Namespaces)
+	}
+	if len(cfg.Tags) != 2 || cfg.Tags[0] != "prod" {
+		t.Errorf("expected tags from the config file, got %v", cfg.Tags)
+	}
+}
+
+func TestLoad_ReadsEventRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, `
+event-rules:
+  - kind: Pod
+    reason: BackOff
+    message: "^Back-off pulling image"
+    state: ignore
+  - kind: Job
+    reason: Completed
+    state: ok
+  - kind: CronJob
+    reason: JobAlreadyActive
+    state: ignore
+`)
+	v := viper.New()
+	v.Set(KeyConfigFile, path)
+	cfg, err := Load(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, tc := range []struct {
+		kind, reason, message, expected string
+	}{
+		{"Pod", "BackOff", `Back-off pulling image "app:missing"`, "ignore"},
+		{"Pod", "BackOff", "Back-off restarting failed container", "fail"},
+		// Rules are checked before the built-in ones
+		{"Job", "Completed", "", "ok"},
+		{"Job", "SuccessfulCreate", "", "run"},
+		{"CronJob", "JobAlreadyActive", "", "ignore"},
+		{"CronJob", "FailedCreate", "", "fail"},
+		{"CronJob", "SawCompletedJob", "", "ignore"},
+	} {
+		if state := cfg.EventState(tc.kind, tc.reason, tc.message, nil); state != tc.expected {
+			t.Errorf("expected %s %s %q to be reported as %s, got %s", tc.kind, tc.reason, tc.message, tc.expected, state)
+		}
+	}
+
+	// The CronJob's own rules come first
+	cronjobRules := []pkg.EventRule{{Kind: "Job", Reason: "Completed", State: "fail"}}
+	if state := cfg.EventState("Job", "Completed", "", cronjobRules); state != "fail" {
+		t.Errorf("expected the CronJob's rule to apply, got %s", state)
+	}
+
+	// Flags and environment variables hold JSON
+	v = viper.New()
+	v.Set(KeyEventRules, `[{"reason": "Started", "state": "ignore"}]`)
+	if cfg, err = Load(v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state := cfg.EventState("Pod", "Started", "", nil); state != "ignore" {
+		t.Errorf("unexpected event rules %+v", cfg.EventRules)
+	}
+
+	v.Set(KeyEventRules, `[{"reason": "Started", "state": "page"}]`)
+	if _, err = Load(v); err == nil || !strings.Contains(err.Error(), KeyEventRules) {
+		t.Errorf("expected an invalid state to be rejected, got %v", err)
+	}
+	v.Set(KeyEventRules, `[{"reason": "BackOff", "message": "(", "state": "fail"}]`)
+	if _, err = Load(v); err == nil || !strings.Contains(err.Error(), KeyEventRules) {
+		t.Errorf("expected an invalid message regular expression to be rejected, got %v", err)
+	}
+}
+
+func TestLoad_ReadsAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, `
+accounts:
+  - name: team-a
+    namespaces: ["team-a-*"]
+    secret: cronitor/team-a
+  - name: team-b
+    namespace-selector: team=b
+    secret: cronitor/team-b
+    apikey-key: API_KEY
+`)
+
+	v := viper.New()
+	v.Set(KeyConfigFile, path)
+	cfg, err := Load(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Accounts) != 2 {
+		t.Fatalf("expected 2 accounts, got %+v", cfg.Accounts)
+	}
+	if cfg.Accounts[0].Namespaces[0] != "team-a-*" || cfg.Accounts[0].ApiKeyKey != DefaultAccountApiKeyKey {
+		t.Errorf("unexpected first account: %+v", cfg.Accounts[0])
+	}
+	if cfg.Accounts[1].NamespaceSelector != "team=b" || cfg.Accounts[1].ApiKeyKey != "API_KEY" {
+		t.Errorf("unexpected second account: %+v", cfg.Accounts[1])
+	}
+
+	writeConfigFile(t, path, `
+accounts:
+  - name: team-a
+    namespaces: ["team-a"]
+    secret: team-a
+`)
+	if _, err := Load(v); err == nil || !strings.Contains(err.Error(), "namespace/name") {
+		t.Errorf("expected an error for an account secret without a namespace, got %v", err)
+	}
+}
+
+func TestLoad_MissingConfigFile(t *testing.T) {
+	v := viper.New()
+	v.Set(KeyConfigFile, filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(v); err == nil {
+		t.Fatal("expected an error for a missing config file")
+	}
+}
+
+func TestStore_UpdateKeepsRestartOnlySettings(t *testing.T) {
+	store := NewStore(&Config{ApiKey: "original", Namespaces: []string{"team-a"}, SidecarCompletion: true})
+	var notified *Config
+	store.OnChange(func(previous *Config, current *Config) {
+		notified = current
+	})
+
+	store.Update(&Config{ApiKey: "changed", Namespaces: []string{"team-b"}, ShipLogs: true})
+
+	current := store.Get()
+	if current.ApiKey != "original" || current.Namespaces[0] != "team-a" || !current.SidecarCompletion {
+		t.Errorf("expected restart-only settings to be kept, got %+v", current)
+	}
+	if !current.ShipLogs {
+		t.Error("expected reloadable settings to be applied")
+	}
+	if notified != current {
+		t.Error("expected listeners to be notified with the new config")
+	}
+}
+
+func TestStore_WatchFileReloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "ship-logs: false\n")
+
+	v := viper.New()
+	v.Set(KeyConfigFile, path)
+	cfg, err := Load(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store := NewStore(cfg)
+	reloaded := make(chan *Config, 1)
+	store.OnChange(func(previous *Config, current *Config) {
+		reloaded <- current
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go store.WatchFile(v, 10*time.Millisecond, stop)
+
+	// An invalid file is ignored, keeping the last valid config
+	writeConfigFile(t, path, "pod-filter: \"job-[\"\n")
+	time.Sleep(50 * time.Millisecond)
+	if store.Get() != cfg {
+		t.Fatal("expected the invalid config file to be ignored")
+	}
+
+	writeConfigFile(t, path, "ship-logs: true\n")
+	select {
+	case current := <-reloaded:
+		if !current.ShipLogs {
+			t.Error("expected the reloaded config to ship logs")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the config file to be reloaded")
+	}
+}
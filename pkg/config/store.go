@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Store holds the current Config and swaps it when the config file changes.
+// A nil *Store behaves as an empty Config, which keeps zero-value API clients usable.
+type Store struct {
+	mu        sync.RWMutex
+	current   *Config
+	listeners []func(previous *Config, current *Config)
+}
+
+func NewStore(cfg *Config) *Store {
+	return &Store{current: cfg}
+}
+
+// Get returns the current Config. It must not be modified.
+func (s *Store) Get() *Config {
+	if s == nil {
+		return &Config{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current
+}
+
+// OnChange registers a function to call after the Config has been reloaded.
+func (s *Store) OnChange(listener func(previous *Config, current *Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, listener)
+}
+
+// restartOnlySettings are used once, when the agent starts; changing them in the config file
+// is logged but has no effect until the agent is restarted. field returns a pointer to the
+// setting, through which it is both compared and kept.
+var restartOnlySettings = []struct {
+	key   string
+	field func(*Config) interface{}
+}{
+	{KeyApiKey, func(c *Config) interface{} { return &c.ApiKey }},
+	{KeyApiKeyFile, func(c *Config) interface{} { return &c.ApiKeyFile }},
+	{KeyTelemetryKey, func(c *Config) interface{} { return &c.TelemetryKey }},
+	{KeyKubeconfig, func(c *Config) interface{} { return &c.Kubeconfig }},
+	{KeyDryRun, func(c *Config) interface{} { return &c.DryRun }},
+	{KeyDryRunOutput, func(c *Config) interface{} { return &c.DryRunOutput }},
+	{KeyDryRunMaxSize, func(c *Config) interface{} { return &c.DryRunMaxSize }},
+	{KeyLogLevel, func(c *Config) interface{} { return &c.LogLevel }},
+	{KeyLogFormat, func(c *Config) interface{} { return &c.LogFormat }},
+	{KeyNamespaces, func(c *Config) interface{} { return &c.Namespaces }},
+	{KeyNamespaceExclude, func(c *Config) interface{} { return &c.NamespaceExclude }},
+	{KeyNamespaceSelector, func(c *Config) interface{} { return &c.NamespaceSelector }},
+	{KeyCronJobSelector, func(c *Config) interface{} { return &c.CronJobSelector }},
+	{KeyMetricsAddress, func(c *Config) interface{} { return &c.MetricsAddress }},
+	{KeySidecarCompletion, func(c *Config) interface{} { return &c.SidecarCompletion }},
+	{KeySyncStateConfigMap, func(c *Config) interface{} { return &c.SyncStateConfigMap }},
+	{KeyAccounts, func(c *Config) interface{} { return &c.Accounts }},
+	{KeyProxy, func(c *Config) interface{} { return &c.Proxy }},
+	{KeyCAFile, func(c *Config) interface{} { return &c.CAFile }},
+	{KeyClientCertFile, func(c *Config) interface{} { return &c.ClientCertFile }},
+	{KeyClientKeyFile, func(c *Config) interface{} { return &c.ClientKeyFile }},
+	{KeyConnectTimeout, func(c *Config) interface{} { return &c.ConnectTimeout }},
+	{KeyResponseTimeout, func(c *Config) interface{} { return &c.ResponseTimeout }},
+}
+
+// Update replaces the current Config, keeping the restart-only settings of the previous one,
+// and notifies the registered listeners.
+func (s *Store) Update(cfg *Config) {
+	s.mu.Lock()
+	previous := s.current
+	if previous != nil {
+		for _, setting := range restartOnlySettings {
+			kept, changed := reflect.ValueOf(setting.field(previous)).Elem(), reflect.ValueOf(setting.field(cfg)).Elem()
+			if !reflect.DeepEqual(kept.Interface(), changed.Interface()) {
+				slog.Warn("config setting changed; restart the agent to apply it", "setting", setting.key)
+			}
+			changed.Set(kept)
+		}
+	}
+	s.current = cfg
+	listeners := append([]func(*Config, *Config){}, s.listeners...)
+	s.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(previous, cfg)
+	}
+}
+
+// WatchFile polls the config file and reloads it through v whenever its contents change,
+// until stop is closed. Polling, rather than filesystem notifications, copes with the way
+// Kubernetes swaps the symlinks of mounted ConfigMaps. An invalid file is logged and ignored,
+// keeping the last valid Config.
+func (s *Store) WatchFile(v *viper.Viper, interval time.Duration, stop <-chan struct{}) {
+	path := s.Get().ConfigFile
+	if path == "" {
+		return
+	}
+	lastContents, _ := os.ReadFile(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("could not read config file", "path", path, "error", err)
+			continue
+		}
+		if bytes.Equal(contents, lastContents) {
+			continue
+		}
+		lastContents = contents
+
+		cfg, err := Load(v)
+		if err != nil {
+			slog.Error("ignoring invalid config file change", "path", path, "error", err)
+			continue
+		}
+		slog.Info("config file reloaded", "path", path)
+		s.Update(cfg)
+	}
+}
@@ -0,0 +1,100 @@
+package cronitortest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ExpectMonitor fails the test if Cronitor doesn't hold the monitor with key, and returns it.
+func (s *Server) ExpectMonitor(t testing.TB, key string) Monitor {
+	t.Helper()
+	monitor, ok := s.Monitor(key)
+	if !ok {
+		t.Fatalf("expected monitor %s, got %s", key, monitorKeys(s.Monitors()))
+	}
+	return monitor
+}
+
+// ExpectNoMonitor fails the test if Cronitor holds the monitor with key.
+func (s *Server) ExpectNoMonitor(t testing.TB, key string) {
+	t.Helper()
+	if _, ok := s.Monitor(key); ok {
+		t.Errorf("expected no monitor %s", key)
+	}
+}
+
+// WaitForMonitor waits until Cronitor holds the monitor with key, failing the test after timeout.
+func (s *Server) WaitForMonitor(t testing.TB, key string, timeout time.Duration) Monitor {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		if monitor, ok := s.Monitor(key); ok {
+			return monitor
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected monitor %s within %s, got %s", key, timeout, monitorKeys(s.Monitors()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ExpectPings fails the test unless the pings received for monitor have exactly states, in order.
+func (s *Server) ExpectPings(t testing.TB, monitor string, states ...string) []Ping {
+	t.Helper()
+	pings := s.Pings(monitor)
+	if got := pingStates(pings); got != strings.Join(states, ",") {
+		t.Errorf("expected pings [%s] for monitor %s, got [%s]", strings.Join(states, ","), monitor, got)
+	}
+	return pings
+}
+
+// WaitForPings waits until at least n pings were received for monitor, failing the test
+// after timeout.
+func (s *Server) WaitForPings(t testing.TB, monitor string, n int, timeout time.Duration) []Ping {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		pings := s.Pings(monitor)
+		if len(pings) >= n {
+			return pings
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d pings for monitor %s within %s, got [%s]", n, monitor, timeout, pingStates(pings))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// WaitForLogUploads waits until at least n logs were uploaded, failing the test after timeout.
+func (s *Server) WaitForLogUploads(t testing.TB, n int, timeout time.Duration) []LogUpload {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		uploads := s.LogUploads()
+		if len(uploads) >= n {
+			return uploads
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d log uploads within %s, got %d", n, timeout, len(uploads))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func pingStates(pings []Ping) string {
+	states := make([]string, len(pings))
+	for i, ping := range pings {
+		states[i] = ping.State
+	}
+	return strings.Join(states, ",")
+}
+
+func monitorKeys(monitors []Monitor) string {
+	keys := make([]string, len(monitors))
+	for i, monitor := range monitors {
+		keys[i] = monitor.Key
+	}
+	return fmt.Sprintf("%v", keys)
+}
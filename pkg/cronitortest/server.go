@@ -0,0 +1,489 @@
+// Package cronitortest provides a fake Cronitor API for tests: monitors, pings and log
+// uploads are handled like Cronitor does and recorded, and failures can be scripted.
+package cronitortest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Endpoint is a group of Cronitor API routes, for scripting failures and reading requests.
+type Endpoint string
+
+const (
+	// MonitorsEndpoint is PUT, GET and DELETE on /api/monitors
+	MonitorsEndpoint Endpoint = "monitors"
+	// PingEndpoint is /ping/<telemetry key>/<monitor key> (and the /p/ alias)
+	PingEndpoint Endpoint = "ping"
+	// LogPresignEndpoint is POST /api/logs/presign
+	LogPresignEndpoint Endpoint = "log_presign"
+	// LogUploadEndpoint is the PUT to the URL returned by LogPresignEndpoint
+	LogUploadEndpoint Endpoint = "log_upload"
+)
+
+// Failure is a scripted answer to one request. With a Status of 0, the request is answered
+// normally after Latency.
+type Failure struct {
+	Status  int
+	Latency time.Duration
+	// RetryAfter is sent as the Retry-After header, if set
+	RetryAfter time.Duration
+}
+
+// TooManyRequests answers with 429, asking to retry after retryAfter.
+func TooManyRequests(retryAfter time.Duration) Failure {
+	return Failure{Status: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+// ServerError answers with status, which should be a 5xx code.
+func ServerError(status int) Failure {
+	return Failure{Status: status}
+}
+
+// Latency answers normally, after d.
+func Latency(d time.Duration) Failure {
+	return Failure{Latency: d}
+}
+
+// Request is a request received by the server.
+type Request struct {
+	Endpoint Endpoint
+	Method   string
+	Path     string
+	Query    url.Values
+	Header   http.Header
+	Body     []byte
+	Time     time.Time
+}
+
+// Monitor is a monitor as sent by the agent.
+type Monitor struct {
+	Key          string   `json:"key"`
+	Name         string   `json:"name,omitempty"`
+	Note         string   `json:"note,omitempty"`
+	Metadata     string   `json:"metadata"`
+	Type         string   `json:"type"`
+	Schedule     string   `json:"schedule"`
+	Timezone     string   `json:"timezone,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Notify       []string `json:"notify,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	GraceSeconds int      `json:"grace_seconds,omitempty"`
+	Assertions   []string `json:"assertions,omitempty"`
+}
+
+// Ping is a telemetry event received for a monitor.
+type Ping struct {
+	// Key is the telemetry key the ping was sent with
+	Key     string
+	Monitor string
+	State   string
+	Series  string
+	Message string
+	Env     string
+	Host    string
+	Metric  string
+	Stamp   string
+	Query   url.Values
+	Time    time.Time
+}
+
+// LogUpload is a log upload, with its logs decompressed.
+type LogUpload struct {
+	Monitor string
+	Series  string
+	Logs    string
+	Time    time.Time
+}
+
+// Server is a fake Cronitor API. It is safe for concurrent use.
+type Server struct {
+	// URL is where the server listens, to be used as the agent's hostname override.
+	// It is empty for a Server created with NewHandler.
+	URL string
+
+	httpServer *httptest.Server
+
+	mu        sync.Mutex
+	apiKey    string
+	failures  map[Endpoint][]Failure
+	rejected  map[string]string
+	requests  []Request
+	monitors  map[string]json.RawMessage
+	pings     []Ping
+	presigned map[string]LogUpload
+	uploads   []LogUpload
+	nextLogID int
+}
+
+// NewServer starts a fake Cronitor API on a local port. Call Close when done.
+func NewServer() *Server {
+	s := NewHandler()
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// NewHandler returns a fake Cronitor API that isn't listening, to be served by the caller.
+func NewHandler() *Server {
+	s := &Server{}
+	s.reset()
+	return s
+}
+
+// Close shuts down a server started with NewServer.
+func (s *Server) Close() {
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+// Reset forgets every request, monitor, ping, log upload, scripted failure and rejection.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+}
+
+func (s *Server) reset() {
+	s.failures = make(map[Endpoint][]Failure)
+	s.rejected = make(map[string]string)
+	s.requests = nil
+	s.monitors = make(map[string]json.RawMessage)
+	s.pings = nil
+	s.presigned = make(map[string]LogUpload)
+	s.uploads = nil
+}
+
+// RequireAPIKey makes the API (but not pings) answer 401 to requests without key.
+func (s *Server) RequireAPIKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKey = key
+}
+
+// Fail scripts the answers to the next requests to endpoint, one failure per request, in order.
+func (s *Server) Fail(endpoint Endpoint, failures ...Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[endpoint] = append(s.failures[endpoint], failures...)
+}
+
+// Reject makes Cronitor reject the monitor with key, with message, in every following PUT.
+func (s *Server) Reject(key string, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rejected[key] = message
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := endpointOf(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Endpoint: endpoint,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.Query(),
+		Header:   r.Header.Clone(),
+		Body:     body,
+		Time:     time.Now(),
+	})
+	var failure Failure
+	if queued := s.failures[endpoint]; len(queued) > 0 {
+		failure, s.failures[endpoint] = queued[0], queued[1:]
+	}
+	apiKey := s.apiKey
+	s.mu.Unlock()
+
+	if failure.Latency > 0 {
+		select {
+		case <-time.After(failure.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if failure.Status != 0 {
+		if failure.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(failure.RetryAfter.Seconds())))
+		}
+		http.Error(w, http.StatusText(failure.Status), failure.Status)
+		return
+	}
+	if apiKey != "" && (endpoint == MonitorsEndpoint || endpoint == LogPresignEndpoint) {
+		if username, _, _ := r.BasicAuth(); username != apiKey {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	switch endpoint {
+	case MonitorsEndpoint:
+		s.handleMonitors(w, r, body)
+	case PingEndpoint:
+		s.handlePing(w, r)
+	case LogPresignEndpoint:
+		s.handleLogPresign(w, r, body)
+	case LogUploadEndpoint:
+		s.handleLogUpload(w, r, body)
+	}
+}
+
+func endpointOf(r *http.Request) (Endpoint, bool) {
+	path := r.URL.Path
+	switch {
+	case path == "/api/monitors" || strings.HasPrefix(path, "/api/monitors/"):
+		return MonitorsEndpoint, true
+	case strings.HasPrefix(path, "/ping/") || strings.HasPrefix(path, "/p/"):
+		return PingEndpoint, true
+	case path == "/api/logs/presign":
+		return LogPresignEndpoint, true
+	case strings.HasPrefix(path, "/logs/upload/"):
+		return LogUploadEndpoint, true
+	}
+	return "", false
+}
+
+func (s *Server) handleMonitors(w http.ResponseWriter, r *http.Request, body []byte) {
+	key, _ := url.PathUnescape(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/monitors"), "/"))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut && key == "":
+		var monitors []json.RawMessage
+		if err := json.Unmarshal(body, &monitors); err != nil {
+			http.Error(w, fmt.Sprintf("invalid monitors: %s", err), http.StatusBadRequest)
+			return
+		}
+		keys := make([]string, len(monitors))
+		errs := make(map[string]string)
+		for i, monitor := range monitors {
+			var decoded Monitor
+			if err := json.Unmarshal(monitor, &decoded); err != nil || decoded.Key == "" {
+				http.Error(w, "every monitor needs a key", http.StatusBadRequest)
+				return
+			}
+			keys[i] = decoded.Key
+			if message, ok := s.rejected[decoded.Key]; ok {
+				errs[decoded.Key] = message
+			}
+		}
+		// Like Cronitor, a batch with an invalid monitor is rejected as a whole
+		if len(errs) > 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": errs})
+			return
+		}
+		for i, monitor := range monitors {
+			s.monitors[keys[i]] = monitor
+		}
+		writeJSON(w, http.StatusOK, monitors)
+	case r.Method == http.MethodGet && key == "":
+		monitors := make([]json.RawMessage, 0, len(s.monitors))
+		for _, key := range s.sortedMonitorKeys() {
+			monitors = append(monitors, s.monitors[key])
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"monitors": monitors, "page": 1})
+	case r.Method == http.MethodGet:
+		monitor, ok := s.monitors[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, monitor)
+	case r.Method == http.MethodDelete && key != "":
+		if _, ok := s.monitors[key]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.monitors, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handlePing(w http.ResponseWriter, r *http.Request) {
+	// /ping/<telemetry key>/<monitor key>
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	ping := Ping{
+		Key:     parts[1],
+		Monitor: parts[2],
+		State:   query.Get("state"),
+		Series:  query.Get("series"),
+		Message: query.Get("message"),
+		Env:     query.Get("env"),
+		Host:    query.Get("host"),
+		Metric:  query.Get("metric"),
+		Stamp:   query.Get("stamp"),
+		Query:   query,
+		Time:    time.Now(),
+	}
+	s.mu.Lock()
+	s.pings = append(s.pings, ping)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
+
+func (s *Server) handleLogPresign(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		JobKey string `json:"job_key"`
+		Series string `json:"series"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil || request.JobKey == "" {
+		http.Error(w, "job_key and series are required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.nextLogID++
+	id := strconv.Itoa(s.nextLogID)
+	s.presigned[id] = LogUpload{Monitor: request.JobKey, Series: request.Series}
+	s.mu.Unlock()
+
+	// The upload goes back to this server, wherever it is reached from
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"url": fmt.Sprintf("%s://%s/logs/upload/%s", scheme, r.Host, id)})
+}
+
+func (s *Server) handleLogUpload(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPut {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	logs, err := gunzip(body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("logs must be gzipped: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/logs/upload/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.presigned[id]
+	if !ok {
+		http.Error(w, "unknown or already used upload URL", http.StatusForbidden)
+		return
+	}
+	delete(s.presigned, id)
+	upload.Logs, upload.Time = logs, time.Now()
+	s.uploads = append(s.uploads, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func gunzip(body []byte) (string, error) {
+	if len(body) == 0 {
+		return "", nil
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	logs, err := io.ReadAll(reader)
+	return string(logs), err
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func (s *Server) sortedMonitorKeys() []string {
+	keys := make([]string, 0, len(s.monitors))
+	for key := range s.monitors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Requests returns the requests received for endpoint, in order, or every request if
+// endpoint is "".
+func (s *Server) Requests(endpoint Endpoint) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []Request
+	for _, request := range s.requests {
+		if endpoint == "" || request.Endpoint == endpoint {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// Monitors returns the monitors Cronitor currently holds, sorted by key.
+func (s *Server) Monitors() []Monitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	monitors := make([]Monitor, 0, len(s.monitors))
+	for _, key := range s.sortedMonitorKeys() {
+		var monitor Monitor
+		_ = json.Unmarshal(s.monitors[key], &monitor)
+		monitors = append(monitors, monitor)
+	}
+	return monitors
+}
+
+// Monitor returns the monitor with key, if Cronitor holds it.
+func (s *Server) Monitor(key string) (Monitor, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.monitors[key]
+	if !ok {
+		return Monitor{}, false
+	}
+	var monitor Monitor
+	_ = json.Unmarshal(raw, &monitor)
+	return monitor, true
+}
+
+// Pings returns the pings received, in order. With a monitor key, only that monitor's.
+func (s *Server) Pings(monitor ...string) []Ping {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var pings []Ping
+	for _, ping := range s.pings {
+		if len(monitor) == 0 || ping.Monitor == monitor[0] {
+			pings = append(pings, ping)
+		}
+	}
+	return pings
+}
+
+// LogUploads returns the logs uploaded, in order.
+func (s *Server) LogUploads() []LogUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]LogUpload(nil), s.uploads...)
+}
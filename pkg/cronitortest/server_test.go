@@ -0,0 +1,147 @@
+package cronitortest_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/cronitortest"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testCronJob(name string) *v1.CronJob {
+	return &v1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(name + "-uid")},
+		Spec:       v1.CronJobSpec{Schedule: "*/5 * * * *"},
+	}
+}
+
+func newTestApi(server *cronitortest.Server) api.CronitorApi {
+	return api.NewCronitorApi(config.NewStore(&config.Config{
+		HostnameOverride: server.URL,
+		ApiKey:           "test-key",
+		ShipLogs:         true,
+	}))
+}
+
+func TestServer_MonitorsAndPings(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	server.RequireAPIKey("test-key")
+	cronitorApi := newTestApi(server)
+	ctx := context.Background()
+
+	cronjob := testCronJob("nightly")
+	if _, err := cronitorApi.PutCronJob(ctx, cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	monitor := server.ExpectMonitor(t, "nightly-uid")
+	if monitor.Schedule != "*/5 * * * *" || monitor.Type != "job" {
+		t.Errorf("unexpected monitor %+v", monitor)
+	}
+
+	job := &v1.Job{ObjectMeta: metav1.ObjectMeta{Name: "nightly-1", Namespace: "default", UID: "job-uid"}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nightly-1-abcde", Namespace: "default"}}
+	jobEvent := &pkg.JobEvent{}
+	jobEvent.Reason = "BackoffLimitExceeded"
+	if err := cronitorApi.MakeAndSendTelemetryJobEventAndLogs(ctx, jobEvent, "error logs", pod, job, cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := cronitorApi.WaitForUploads(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pings := server.ExpectPings(t, "nightly-uid", "fail", "logs")
+	if pings[0].Series != "job-uid" || pings[0].Key != "test-key" {
+		t.Errorf("unexpected ping %+v", pings[0])
+	}
+	uploads := server.LogUploads()
+	if len(uploads) != 1 || uploads[0].Logs != "error logs" || uploads[0].Monitor != "nightly-uid" {
+		t.Errorf("expected the logs to be uploaded for the monitor, got %+v", uploads)
+	}
+}
+
+func TestServer_RejectsMonitors(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	server.Reject("invalid-uid", "invalid schedule")
+
+	_, err := newTestApi(server).PutCronJobs(context.Background(), []*v1.CronJob{testCronJob("valid"), testCronJob("invalid")})
+	var syncErr api.SyncError
+	if !errors.As(err, &syncErr) || len(syncErr.Failed) != 1 || syncErr.Failed["invalid-uid"] == nil {
+		t.Fatalf("expected only the rejected monitor to fail, got %v", err)
+	}
+	server.ExpectMonitor(t, "valid-uid")
+	server.ExpectNoMonitor(t, "invalid-uid")
+	if requests := server.Requests(cronitortest.MonitorsEndpoint); len(requests) != 2 {
+		t.Errorf("expected the batch to be sent again without the rejected monitor, got %d requests", len(requests))
+	}
+}
+
+func TestServer_ScriptedFailures(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	cronitorApi := newTestApi(server)
+	cronjob := testCronJob("flaky")
+
+	server.Fail(cronitortest.MonitorsEndpoint,
+		cronitortest.TooManyRequests(time.Second),
+		cronitortest.ServerError(http.StatusBadGateway))
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusBadGateway} {
+		_, err := cronitorApi.PutCronJob(context.Background(), cronjob)
+		var apiErr api.CronitorApiError
+		if !errors.As(err, &apiErr) || apiErr.Response.StatusCode != status {
+			t.Fatalf("expected a %d error, got %v", status, err)
+		}
+	}
+	server.ExpectNoMonitor(t, "flaky-uid")
+
+	// Once the scripted failures are used up, requests succeed again
+	if _, err := cronitorApi.PutCronJob(context.Background(), cronjob); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server.ExpectMonitor(t, "flaky-uid")
+
+	server.Fail(cronitortest.MonitorsEndpoint, cronitortest.Latency(time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cronitorApi.PutCronJob(ctx, cronjob); err == nil || ctx.Err() == nil {
+		t.Errorf("expected the slow request to time out, got %v", err)
+	}
+}
+
+func TestServer_GetAndDeleteMonitors(t *testing.T) {
+	server := cronitortest.NewServer()
+	defer server.Close()
+	if _, err := newTestApi(server).PutCronJob(context.Background(), testCronJob("nightly")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		method string
+		status int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodDelete, http.StatusNoContent},
+		{http.MethodGet, http.StatusNotFound},
+	} {
+		request, _ := http.NewRequest(tc.method, server.URL+"/api/monitors/nightly-uid", nil)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != tc.status {
+			t.Errorf("expected %s to answer %d, got %d", tc.method, tc.status, response.StatusCode)
+		}
+	}
+	server.ExpectNoMonitor(t, "nightly-uid")
+}
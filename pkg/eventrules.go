@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// States an event can be reported as. EventStateIgnore reports nothing.
+const (
+	EventStateRun      = "run"
+	EventStateComplete = "complete"
+	EventStateFail     = "fail"
+	EventStateOk       = "ok"
+	EventStateLogs     = "logs"
+	EventStateIgnore   = "ignore"
+)
+
+// EventRule maps the Kubernetes events with Kind and Reason, and optionally a Message matching
+// a regular expression, to the state they are reported as. An empty Kind matches every kind.
+type EventRule struct {
+	Kind    string `json:"kind,omitempty" mapstructure:"kind"`
+	Reason  string `json:"reason" mapstructure:"reason"`
+	Message string `json:"message,omitempty" mapstructure:"message"`
+	State   string `json:"state" mapstructure:"state"`
+
+	message *regexp.Regexp
+}
+
+// DefaultEventRules is how events are reported when no other rule matches them.
+var DefaultEventRules = []EventRule{
+	{Kind: "Pod", Reason: "Started", State: EventStateRun},
+	{Kind: "Pod", Reason: "BackOff", State: EventStateFail},
+
+	{Kind: "Job", Reason: "SuccessfulCreate", State: EventStateRun},
+	{Kind: "Job", Reason: "Completed", State: EventStateComplete},
+	{Kind: "Job", Reason: "BackoffLimitExceeded", State: EventStateFail},
+	// activeDeadlineSeconds passed
+	{Kind: "Job", Reason: "DeadlineExceeded", State: EventStateFail},
+	// Pods could not be created, e.g. over a ResourceQuota
+	{Kind: "Job", Reason: "FailedCreate", State: EventStateFail},
+	// A podFailurePolicy rule with action FailJob matched
+	{Kind: "Job", Reason: "PodFailurePolicy", State: EventStateFail},
+	{Kind: "Job", Reason: "MaxFailedIndexesExceeded", State: EventStateFail},
+	{Kind: "Job", Reason: "Suspended", State: EventStateLogs},
+	{Kind: "Job", Reason: "Resumed", State: EventStateLogs},
+
+	{Kind: "CronJob", Reason: "MissSchedule", State: EventStateFail},
+	{Kind: "CronJob", Reason: "TooManyMissedTimes", State: EventStateFail},
+	{Kind: "CronJob", Reason: "FailedCreate", State: EventStateFail},
+	{Kind: "CronJob", Reason: "FailedNeedsStart", State: EventStateFail},
+	// Runs skipped while another is active, as concurrencyPolicy Forbid intends
+	{Kind: "CronJob", Reason: "JobAlreadyActive", State: EventStateLogs},
+}
+
+// Compile validates the rule and compiles its message regular expression.
+func (r *EventRule) Compile() error {
+	if r.Reason == "" {
+		return fmt.Errorf("event rule %+v has no reason", *r)
+	}
+	switch r.State {
+	case EventStateRun, EventStateComplete, EventStateFail, EventStateOk, EventStateLogs, EventStateIgnore:
+	default:
+		return fmt.Errorf("event rule for %s has invalid state %q (must be run, complete, fail, ok, logs or ignore)", r.Reason, r.State)
+	}
+	r.message = nil
+	if r.Message != "" {
+		message, err := regexp.Compile(r.Message)
+		if err != nil {
+			return fmt.Errorf("event rule for %s has an invalid message regular expression: %w", r.Reason, err)
+		}
+		r.message = message
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to an event with kind, reason and message.
+// Reasons are matched regardless of case.
+func (r EventRule) Matches(kind, reason, message string) bool {
+	if r.Kind != "" && r.Kind != kind || !strings.EqualFold(r.Reason, reason) {
+		return false
+	}
+	if r.Message == "" {
+		return true
+	}
+	if r.message == nil {
+		matched, err := regexp.MatchString(r.Message, message)
+		return err == nil && matched
+	}
+	return r.message.MatchString(message)
+}
+
+// MatchEventRules returns the state of the first rule in ruleSets, taken in order, that
+// matches an event with kind, reason and message.
+func MatchEventRules(kind, reason, message string, ruleSets ...[]EventRule) (string, bool) {
+	for _, rules := range ruleSets {
+		for _, rule := range rules {
+			if rule.Matches(kind, reason, message) {
+				return rule.State, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ParseEventRules parses and compiles a YAML or JSON list of event rules.
+func ParseEventRules(data string) ([]EventRule, error) {
+	var rules []EventRule
+	if err := yaml.Unmarshal([]byte(data), &rules); err != nil {
+		return nil, fmt.Errorf("invalid event rules: %w", err)
+	}
+	for i := range rules {
+		if err := rules[i].Compile(); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
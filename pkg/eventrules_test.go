@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMatchEventRules(t *testing.T) {
+	rules, err := ParseEventRules(`
+- kind: Pod
+  reason: BackOff
+  message: "^Back-off pulling image"
+  state: ignore
+- reason: BackOff
+  state: logs
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := []struct {
+		name                  string
+		kind, reason, message string
+		expectedState         string
+		expectedMatch         bool
+	}{
+		{"message matches", "Pod", "BackOff", `Back-off pulling image "app:missing"`, EventStateIgnore, true},
+		{"first matching rule applies", "Pod", "BackOff", "Back-off restarting failed container", EventStateLogs, true},
+		{"rule without a kind matches every kind", "Job", "BackOff", "", EventStateLogs, true},
+		{"reasons match in any case", "Job", "backoff", "", EventStateLogs, true},
+		{"no rule matches", "Pod", "Started", "", "", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			state, ok := MatchEventRules(tc.kind, tc.reason, tc.message, rules)
+			if state != tc.expectedState || ok != tc.expectedMatch {
+				t.Errorf("expected %q (%t), got %q (%t)", tc.expectedState, tc.expectedMatch, state, ok)
+			}
+		})
+	}
+
+	// Earlier rule sets come first
+	override := []EventRule{{Kind: "Pod", Reason: "BackOff", State: EventStateFail}}
+	if state, _ := MatchEventRules("Pod", "BackOff", "Back-off pulling image", override, rules); state != EventStateFail {
+		t.Errorf("expected the first rule set to apply, got %q", state)
+	}
+}
+
+func TestParseEventRules_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, expected string
+	}{
+		{"not a list", `{"reason": "BackOff"}`, "invalid event rules"},
+		{"no reason", `[{"state": "fail"}]`, "has no reason"},
+		{"invalid state", `[{"reason": "BackOff", "state": "page"}]`, "invalid state"},
+		{"invalid message", `[{"reason": "BackOff", "message": "(", "state": "fail"}]`, "invalid message regular expression"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := ParseEventRules(tc.data); err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("expected an error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestGetEventRules(t *testing.T) {
+	cronJob := v1.CronJob{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		"k8s.cronitor.io/event-rules": `[{"kind": "Job", "reason": "Completed", "state": "ok"}]`,
+	}}}
+	rules, err := NewCronitorConfigParser(&cronJob, ConfigSources{}).GetEventRules()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if state, _ := MatchEventRules("Job", "Completed", "", rules); state != EventStateOk {
+		t.Errorf("expected Completed to be reported as ok, got %q", state)
+	}
+
+	cronJob.Annotations["k8s.cronitor.io/event-rules"] = `[{"reason": "Completed", "state": "done"}]`
+	if _, err := NewCronitorConfigParser(&cronJob, ConfigSources{}).GetEventRules(); err == nil || !strings.Contains(err.Error(), "k8s.cronitor.io/event-rules") {
+		t.Errorf("expected the invalid annotation to be named, got %v", err)
+	}
+}
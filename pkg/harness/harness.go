@@ -0,0 +1,460 @@
+// Package harness runs the agent's CronJobCollection in-process, against a fake Kubernetes
+// cluster and a fake Cronitor API, so that tests can play out CronJob lifecycles end to end.
+package harness
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg/api"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/collector"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/cronitortest"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/normalizer"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Timeout is how long the harness waits for the agent to react to a change in the cluster.
+const Timeout = 5 * time.Second
+
+// Options configure a Harness.
+type Options struct {
+	// BatchVersion is the batch API version the fake cluster serves CronJobs with:
+	// "v1" (the default) or "v1beta1", for clusters older than 1.21
+	BatchVersion string
+	// Config is the agent's config. The hostname override, API key and sync window are
+	// set for the fake Cronitor API if left empty.
+	Config *config.Config
+	// CronJobs exist in the cluster before the agent starts
+	CronJobs []*v1.CronJob
+}
+
+// Harness is a running agent, with the fake cluster and fake Cronitor API it talks to.
+type Harness struct {
+	Cluster    *fake.Clientset
+	Cronitor   *cronitortest.Server
+	Collection *collector.CronJobCollection
+
+	t            testing.TB
+	batchVersion string
+	stopOnce     sync.Once
+
+	// resourceVersion is given to every object created or updated in the fake cluster,
+	// which the event watch needs to resume from
+	resourceVersion int64
+	nameSuffix      int64
+
+	watchesMu sync.Mutex
+	watches   map[string]int
+}
+
+// New starts an agent against a fake cluster holding opts.CronJobs and a fake Cronitor API,
+// and waits until it watches for changes. It is stopped when the test ends.
+func New(t testing.TB, opts Options) *Harness {
+	t.Helper()
+	batchVersion := opts.BatchVersion
+	if batchVersion == "" {
+		batchVersion = "v1"
+	}
+	serverVersion := &version.Info{Major: "1", Minor: "25"}
+	if batchVersion == "v1beta1" {
+		serverVersion = &version.Info{Major: "1", Minor: "20"}
+	} else if batchVersion != "v1" {
+		t.Fatalf("unsupported batch API version %q", batchVersion)
+	}
+
+	h := &Harness{
+		Cronitor:     cronitortest.NewServer(),
+		t:            t,
+		batchVersion: batchVersion,
+		watches:      make(map[string]int),
+	}
+	t.Cleanup(h.Stop)
+
+	var objects []runtime.Object
+	for _, cronjob := range opts.CronJobs {
+		objects = append(objects, h.versionedCronJob(h.withDefaults(cronjob)))
+	}
+	h.Cluster = fake.NewSimpleClientset(objects...)
+	h.Cluster.PrependReactor("create", "*", h.setResourceVersion)
+	h.Cluster.PrependReactor("update", "*", h.setResourceVersion)
+	h.Cluster.PrependWatchReactor("*", h.trackWatch)
+
+	cfg := config.Config{}
+	if opts.Config != nil {
+		cfg = *opts.Config
+	}
+	if cfg.HostnameOverride == "" {
+		cfg.HostnameOverride = h.Cronitor.URL
+	}
+	if cfg.ApiKey == "" {
+		cfg.ApiKey = "test-key"
+	}
+	if cfg.SyncWindow == 0 {
+		cfg.SyncWindow = 10 * time.Millisecond
+	}
+	store := config.NewStore(&cfg)
+	cronitorApi := api.NewCronitorApi(store)
+	namespaceScope, err := collector.NewNamespaceScope(cfg.Namespaces, cfg.NamespaceExclude, cfg.NamespaceSelector)
+	if err != nil {
+		t.Fatalf("invalid namespace scope: %v", err)
+	}
+	h.Collection, err = collector.NewCronJobCollectionWithClients(store, namespaceScope, &cronitorApi, h.Cluster, nil, serverVersion)
+	if err != nil {
+		t.Fatalf("could not create the collection: %v", err)
+	}
+	if err := h.Collection.LoadAllExistingCronJobs(context.Background()); err != nil {
+		t.Fatalf("could not load the existing CronJobs: %v", err)
+	}
+	if err := h.Collection.StartWatchingAll(); err != nil {
+		t.Fatalf("could not start watching: %v", err)
+	}
+	// Objects created before the watches are established would go unnoticed
+	watched := len(namespaceScope.WatchedNamespaces())
+	h.WaitFor("the agent to watch CronJobs and events", func() bool {
+		h.watchesMu.Lock()
+		defer h.watchesMu.Unlock()
+		return h.watches["cronjobs"] >= watched && h.watches["events"] >= watched &&
+			(!cfg.SidecarCompletion || h.watches["pods"] >= watched)
+	})
+	return h
+}
+
+// Stop stops the agent, after it has sent the telemetry already collected, and the fake
+// Cronitor API. It is called when the test ends.
+func (h *Harness) Stop() {
+	h.stopOnce.Do(func() {
+		if h.Collection != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+			defer cancel()
+			h.Collection.StopWatchingAll(ctx)
+		}
+		h.Cronitor.Close()
+	})
+}
+
+func (h *Harness) setResourceVersion(action k8stesting.Action) (bool, runtime.Object, error) {
+	var obj runtime.Object
+	switch action := action.(type) {
+	case k8stesting.CreateAction:
+		obj = action.GetObject()
+	case k8stesting.UpdateAction:
+		obj = action.GetObject()
+	}
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetResourceVersion(strconv.FormatInt(atomic.AddInt64(&h.resourceVersion, 1), 10))
+	}
+	// Let the object tracker store it
+	return false, nil, nil
+}
+
+func (h *Harness) trackWatch(action k8stesting.Action) (bool, watch.Interface, error) {
+	gvr, namespace := action.GetResource(), action.GetNamespace()
+	watcher, err := h.Cluster.Tracker().Watch(gvr, namespace)
+	if err == nil {
+		h.watchesMu.Lock()
+		h.watches[gvr.Resource]++
+		h.watchesMu.Unlock()
+	}
+	return true, watcher, err
+}
+
+// WaitFor waits until condition is true, failing the test after Timeout.
+func (h *Harness) WaitFor(what string, condition func() bool) {
+	h.t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			h.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (h *Harness) withDefaults(cronjob *v1.CronJob) *v1.CronJob {
+	cronjob = cronjob.DeepCopy()
+	if cronjob.Namespace == "" {
+		cronjob.Namespace = meta_v1.NamespaceDefault
+	}
+	if cronjob.UID == "" {
+		cronjob.UID = types.UID(cronjob.Name + "-uid")
+	}
+	return cronjob
+}
+
+// versionedCronJob returns cronjob as served by the fake cluster's batch API version.
+func (h *Harness) versionedCronJob(cronjob *v1.CronJob) runtime.Object {
+	if h.batchVersion == "v1beta1" {
+		return normalizer.CronJobConvertV1ToV1Beta1(cronjob)
+	}
+	return cronjob
+}
+
+// CreateCronJob creates cronjob in the cluster, defaulting its namespace and UID, and
+// returns it as created.
+func (h *Harness) CreateCronJob(cronjob *v1.CronJob) *v1.CronJob {
+	h.t.Helper()
+	cronjob = h.withDefaults(cronjob)
+	var err error
+	ctx := context.Background()
+	if h.batchVersion == "v1beta1" {
+		_, err = h.Cluster.BatchV1beta1().CronJobs(cronjob.Namespace).Create(ctx, normalizer.CronJobConvertV1ToV1Beta1(cronjob), meta_v1.CreateOptions{})
+	} else {
+		_, err = h.Cluster.BatchV1().CronJobs(cronjob.Namespace).Create(ctx, cronjob, meta_v1.CreateOptions{})
+	}
+	if err != nil {
+		h.t.Fatalf("could not create CronJob %s: %v", cronjob.Name, err)
+	}
+	return cronjob
+}
+
+// UpdateCronJob updates cronjob in the cluster.
+func (h *Harness) UpdateCronJob(cronjob *v1.CronJob) {
+	h.t.Helper()
+	var err error
+	ctx := context.Background()
+	if h.batchVersion == "v1beta1" {
+		_, err = h.Cluster.BatchV1beta1().CronJobs(cronjob.Namespace).Update(ctx, normalizer.CronJobConvertV1ToV1Beta1(cronjob), meta_v1.UpdateOptions{})
+	} else {
+		_, err = h.Cluster.BatchV1().CronJobs(cronjob.Namespace).Update(ctx, cronjob, meta_v1.UpdateOptions{})
+	}
+	if err != nil {
+		h.t.Fatalf("could not update CronJob %s: %v", cronjob.Name, err)
+	}
+}
+
+// DeleteCronJob deletes cronjob from the cluster.
+func (h *Harness) DeleteCronJob(cronjob *v1.CronJob) {
+	h.t.Helper()
+	var err error
+	ctx := context.Background()
+	if h.batchVersion == "v1beta1" {
+		err = h.Cluster.BatchV1beta1().CronJobs(cronjob.Namespace).Delete(ctx, cronjob.Name, meta_v1.DeleteOptions{})
+	} else {
+		err = h.Cluster.BatchV1().CronJobs(cronjob.Namespace).Delete(ctx, cronjob.Name, meta_v1.DeleteOptions{})
+	}
+	if err != nil {
+		h.t.Fatalf("could not delete CronJob %s: %v", cronjob.Name, err)
+	}
+}
+
+// WaitForSync waits until the monitor of cronjob has been synced to Cronitor.
+func (h *Harness) WaitForSync(cronjob *v1.CronJob) cronitortest.Monitor {
+	h.t.Helper()
+	h.WaitFor(fmt.Sprintf("CronJob %s to be tracked", cronjob.Name), func() bool {
+		return h.Collection.IsTracked(cronjob.UID)
+	})
+	monitor, ok := h.Cronitor.Monitor(string(cronjob.UID))
+	if !ok {
+		h.t.Fatalf("expected the monitor of CronJob %s to be synced", cronjob.Name)
+	}
+	return monitor
+}
+
+// Event records an event about obj, of the given kind ("Job" or "Pod"), as Kubernetes would.
+func (h *Harness) Event(kind string, obj meta_v1.Object, eventType string, reason string, message string) {
+	h.t.Helper()
+	now := meta_v1.Now()
+	event := &corev1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%d", obj.GetName(), atomic.AddInt64(&h.nameSuffix, 1)),
+			Namespace: obj.GetNamespace(),
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:      kind,
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			UID:       obj.GetUID(),
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := h.Cluster.CoreV1().Events(event.Namespace).Create(context.Background(), event, meta_v1.CreateOptions{}); err != nil {
+		h.t.Fatalf("could not record event %s: %v", reason, err)
+	}
+}
+
+// Run is a Job started for a CronJob, with its single Pod.
+type Run struct {
+	Job *v1.Job
+	Pod *corev1.Pod
+	h   *Harness
+}
+
+// StartRun creates a Job for cronjob and its Pod, as the CronJob controller would.
+func (h *Harness) StartRun(cronjob *v1.CronJob) *Run {
+	h.t.Helper()
+	suffix := atomic.AddInt64(&h.nameSuffix, 1)
+	jobName := fmt.Sprintf("%s-%d", cronjob.Name, 28000000+suffix)
+	job := &v1.Job{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      jobName,
+			Namespace: cronjob.Namespace,
+			UID:       types.UID(jobName + "-uid"),
+			OwnerReferences: []meta_v1.OwnerReference{{
+				APIVersion: "batch/" + h.batchVersion,
+				Kind:       "CronJob",
+				Name:       cronjob.Name,
+				UID:        cronjob.UID,
+			}},
+		},
+		Spec:   cronjob.Spec.JobTemplate.Spec,
+		Status: v1.JobStatus{Active: 1, StartTime: &meta_v1.Time{Time: time.Now()}},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:      jobName + "-abcde",
+			Namespace: cronjob.Namespace,
+			UID:       types.UID(jobName + "-pod-uid"),
+			Labels:    map[string]string{"job-name": jobName},
+			OwnerReferences: []meta_v1.OwnerReference{{
+				APIVersion: "batch/v1",
+				Kind:       "Job",
+				Name:       jobName,
+				UID:        job.UID,
+			}},
+		},
+		Spec:   cronjob.Spec.JobTemplate.Spec.Template.Spec,
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+
+	ctx := context.Background()
+	var err error
+	if job, err = h.Cluster.BatchV1().Jobs(job.Namespace).Create(ctx, job, meta_v1.CreateOptions{}); err != nil {
+		h.t.Fatalf("could not create Job %s: %v", jobName, err)
+	}
+	if pod, err = h.Cluster.CoreV1().Pods(pod.Namespace).Create(ctx, pod, meta_v1.CreateOptions{}); err != nil {
+		h.t.Fatalf("could not create Pod %s: %v", pod.Name, err)
+	}
+	h.Event("Job", job, corev1.EventTypeNormal, "SuccessfulCreate", "Created pod: "+pod.Name)
+	return &Run{Job: job, Pod: pod, h: h}
+}
+
+// Restart fails the Pod's container, which Kubernetes restarts after a back-off.
+func (r *Run) Restart() {
+	r.h.t.Helper()
+	r.h.Event("Pod", r.Pod, corev1.EventTypeWarning, "BackOff", "Back-off restarting failed container")
+}
+
+// ImagePullBackOff keeps the Pod Pending with its first container waiting on an image that
+// can't be pulled, recording the events the kubelet would.
+func (r *Run) ImagePullBackOff() {
+	r.h.t.Helper()
+	container := r.Pod.Spec.Containers[0]
+	pod := r.Pod.DeepCopy()
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodPending,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  container.Name,
+			Image: container.Image,
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason:  "ImagePullBackOff",
+				Message: fmt.Sprintf("Back-off pulling image %q", container.Image),
+			}},
+		}},
+	}
+	var err error
+	if r.Pod, err = r.h.Cluster.CoreV1().Pods(pod.Namespace).UpdateStatus(context.Background(), pod, meta_v1.UpdateOptions{}); err != nil {
+		r.h.t.Fatalf("could not update Pod %s: %v", pod.Name, err)
+	}
+	r.h.Event("Pod", r.Pod, corev1.EventTypeWarning, "Failed", "Error: ErrImagePull")
+	r.h.Event("Pod", r.Pod, corev1.EventTypeNormal, "BackOff", fmt.Sprintf("Back-off pulling image %q", container.Image))
+}
+
+// ExitMainContainer terminates the Pod's first container with exitCode, leaving the others, such
+// as sidecars, running, as a service mesh proxy keeps the Pod of a finished run running.
+func (r *Run) ExitMainContainer(exitCode int32) {
+	r.h.t.Helper()
+	pod := r.Pod.DeepCopy()
+	pod.Status = corev1.PodStatus{Phase: corev1.PodRunning}
+	for i, container := range pod.Spec.Containers {
+		status := corev1.ContainerStatus{Name: container.Name, Image: container.Image}
+		if i == 0 {
+			status.State.Terminated = &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: "Completed"}
+			if exitCode != 0 {
+				status.State.Terminated.Reason = "Error"
+			}
+		} else {
+			status.State.Running = &corev1.ContainerStateRunning{}
+		}
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, status)
+	}
+	var err error
+	if r.Pod, err = r.h.Cluster.CoreV1().Pods(pod.Namespace).UpdateStatus(context.Background(), pod, meta_v1.UpdateOptions{}); err != nil {
+		r.h.t.Fatalf("could not update Pod %s: %v", pod.Name, err)
+	}
+}
+
+// Complete finishes the Job successfully.
+func (r *Run) Complete() {
+	r.h.t.Helper()
+	r.finish(corev1.PodSucceeded, v1.JobComplete, "")
+	r.h.Event("Job", r.Job, corev1.EventTypeNormal, "Completed", "Job completed")
+}
+
+// Fail fails the Job once it has reached its backoff limit.
+func (r *Run) Fail() {
+	r.h.t.Helper()
+	r.finish(corev1.PodFailed, v1.JobFailed, "BackoffLimitExceeded")
+	r.h.Event("Job", r.Job, corev1.EventTypeWarning, "BackoffLimitExceeded", "Job has reached the specified backoff limit")
+}
+
+// ExceedDeadline fails the Job once it has been active longer than its activeDeadlineSeconds,
+// deleting its Pod as the Job controller does.
+func (r *Run) ExceedDeadline() {
+	r.h.t.Helper()
+	r.finish(corev1.PodFailed, v1.JobFailed, "DeadlineExceeded")
+	if err := r.h.Cluster.CoreV1().Pods(r.Pod.Namespace).Delete(context.Background(), r.Pod.Name, meta_v1.DeleteOptions{}); err != nil {
+		r.h.t.Fatalf("could not delete Pod %s: %v", r.Pod.Name, err)
+	}
+	r.h.Event("Job", r.Job, corev1.EventTypeWarning, "DeadlineExceeded", "Job was active longer than specified deadline")
+}
+
+func (r *Run) finish(podPhase corev1.PodPhase, condition v1.JobConditionType, reason string) {
+	r.h.t.Helper()
+	ctx := context.Background()
+	now := meta_v1.Now()
+
+	pod := r.Pod.DeepCopy()
+	pod.Status.Phase = podPhase
+	var err error
+	if r.Pod, err = r.h.Cluster.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, pod, meta_v1.UpdateOptions{}); err != nil {
+		r.h.t.Fatalf("could not update Pod %s: %v", pod.Name, err)
+	}
+
+	job := r.Job.DeepCopy()
+	job.Status.Active = 0
+	job.Status.CompletionTime = &now
+	if condition == v1.JobComplete {
+		job.Status.Succeeded = 1
+	} else {
+		job.Status.Failed = 1
+	}
+	job.Status.Conditions = append(job.Status.Conditions, v1.JobCondition{
+		Type:               condition,
+		Status:             corev1.ConditionTrue,
+		Reason:             reason,
+		LastTransitionTime: now,
+	})
+	if r.Job, err = r.h.Cluster.BatchV1().Jobs(job.Namespace).UpdateStatus(ctx, job, meta_v1.UpdateOptions{}); err != nil {
+		r.h.t.Fatalf("could not update Job %s: %v", job.Name, err)
+	}
+}
@@ -0,0 +1,325 @@
+package harness
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cronitorio/cronitor-kubernetes/pkg"
+	"github.com/cronitorio/cronitor-kubernetes/pkg/config"
+	v1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var batchVersions = []string{"v1", "v1beta1"}
+
+func testCronJob(name string) *v1.CronJob {
+	return &v1.CronJob{
+		ObjectMeta: meta_v1.ObjectMeta{Name: name},
+		Spec: v1.CronJobSpec{
+			Schedule: "*/5 * * * *",
+			JobTemplate: v1.JobTemplateSpec{
+				Spec: v1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							Containers:    []corev1.Container{{Name: "main", Image: "busybox"}},
+							RestartPolicy: corev1.RestartPolicyOnFailure,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestLifecycle_Success(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			existing := testCronJob("existing")
+			h := New(t, Options{BatchVersion: batchVersion, CronJobs: []*v1.CronJob{existing}})
+
+			// CronJobs are synced both when the agent starts and when they are created
+			h.Cronitor.ExpectMonitor(t, "existing-uid")
+			cronjob := h.CreateCronJob(testCronJob("nightly"))
+			if monitor := h.WaitForSync(cronjob); monitor.Schedule != "*/5 * * * *" {
+				t.Errorf("unexpected monitor %+v", monitor)
+			}
+
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "nightly-uid", 1, Timeout)
+			run.Complete()
+			pings := h.Cronitor.WaitForPings(t, "nightly-uid", 2, Timeout)
+			h.Cronitor.ExpectPings(t, "nightly-uid", "run", "complete")
+			for _, ping := range pings {
+				if ping.Series != string(run.Job.UID) {
+					t.Errorf("expected the pings to share the Job's series, got %+v", ping)
+				}
+			}
+		})
+	}
+}
+
+func TestLifecycle_Retries(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			h := New(t, Options{BatchVersion: batchVersion})
+			cronjob := h.CreateCronJob(testCronJob("flaky"))
+			h.WaitForSync(cronjob)
+
+			// Each failed attempt is reported, then the Job succeeds
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "flaky-uid", 1, Timeout)
+			run.Restart()
+			h.Cronitor.WaitForPings(t, "flaky-uid", 2, Timeout)
+			run.Restart()
+			h.Cronitor.WaitForPings(t, "flaky-uid", 3, Timeout)
+			run.Complete()
+			h.Cronitor.WaitForPings(t, "flaky-uid", 4, Timeout)
+			h.Cronitor.ExpectPings(t, "flaky-uid", "run", "fail", "fail", "complete")
+		})
+	}
+}
+
+func TestLifecycle_BackoffLimitExceeded(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			h := New(t, Options{BatchVersion: batchVersion, Config: &config.Config{ShipLogs: true}})
+			cronjob := h.CreateCronJob(testCronJob("broken"))
+			h.WaitForSync(cronjob)
+
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "broken-uid", 1, Timeout)
+			run.Fail()
+
+			// The failure is followed by the Pod's logs
+			uploads := h.Cronitor.WaitForLogUploads(t, 1, Timeout)
+			h.Cronitor.WaitForPings(t, "broken-uid", 3, Timeout)
+			pings := h.Cronitor.ExpectPings(t, "broken-uid", "run", "fail", "logs")
+			if pings[1].Message != "Job has reached the specified backoff limit" {
+				t.Errorf("expected the failure to hold the event's message, got %q", pings[1].Message)
+			}
+			if uploads[0].Monitor != "broken-uid" || uploads[0].Series != string(run.Job.UID) || uploads[0].Logs == "" {
+				t.Errorf("unexpected log upload %+v", uploads[0])
+			}
+		})
+	}
+}
+
+func TestLifecycle_Deletion(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			h := New(t, Options{BatchVersion: batchVersion})
+			deleted := h.CreateCronJob(testCronJob("deleted"))
+			kept := h.CreateCronJob(testCronJob("kept"))
+			h.WaitForSync(deleted)
+			h.WaitForSync(kept)
+
+			h.DeleteCronJob(deleted)
+			h.WaitFor("the deleted CronJob not to be tracked", func() bool {
+				return !h.Collection.IsTracked(deleted.UID)
+			})
+
+			// A Job left behind by the deleted CronJob is no longer reported
+			h.StartRun(deleted)
+			h.StartRun(kept)
+			h.Cronitor.WaitForPings(t, "kept-uid", 1, Timeout)
+			h.Cronitor.ExpectPings(t, "deleted-uid")
+		})
+	}
+}
+
+func TestLifecycle_CronJobEvents(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			cfg := &config.Config{EventRules: []pkg.EventRule{
+				{Kind: "CronJob", Reason: "JobAlreadyActive", State: "ignore"},
+				{Kind: "CronJob", Reason: "MissSchedule", State: "logs"},
+			}}
+			h := New(t, Options{BatchVersion: batchVersion, Config: cfg})
+			cronjob := h.CreateCronJob(testCronJob("blocked"))
+			h.WaitForSync(cronjob)
+
+			// Events the controller records on the CronJob itself are reported on its monitor
+			h.Event("CronJob", cronjob, corev1.EventTypeWarning, "FailedCreate", "Error creating job: exceeded quota")
+			pings := h.Cronitor.WaitForPings(t, "blocked-uid", 1, Timeout)
+			if pings[0].State != "fail" || pings[0].Message != "Error creating job: exceeded quota" {
+				t.Errorf("unexpected ping %+v", pings[0])
+			}
+
+			// Unless configured otherwise
+			h.Event("CronJob", cronjob, corev1.EventTypeNormal, "JobAlreadyActive", "Not starting job because prior execution is running and concurrency policy is Forbid")
+			h.Event("CronJob", cronjob, corev1.EventTypeNormal, "SawCompletedJob", "Saw completed job: blocked-28000000")
+			h.Event("CronJob", cronjob, corev1.EventTypeWarning, "MissSchedule", "Missed scheduled time to start a job")
+			h.Cronitor.WaitForPings(t, "blocked-uid", 2, Timeout)
+			h.Cronitor.ExpectPings(t, "blocked-uid", "fail", "logs")
+		})
+	}
+}
+
+func TestLifecycle_FailedCreate(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			h := New(t, Options{BatchVersion: batchVersion})
+			cronjob := h.CreateCronJob(testCronJob("quota"))
+			h.WaitForSync(cronjob)
+
+			// The Job controller records the event again on each retry, but the run fails once
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "quota-uid", 1, Timeout)
+			for i := 0; i < 3; i++ {
+				h.Event("Job", run.Job, corev1.EventTypeWarning, "FailedCreate", "Error creating: pods is forbidden: exceeded quota")
+			}
+			h.Cronitor.WaitForPings(t, "quota-uid", 2, Timeout)
+			time.Sleep(200 * time.Millisecond)
+			h.Cronitor.ExpectPings(t, "quota-uid", "run", "fail")
+		})
+	}
+}
+
+func TestLifecycle_ImagePullBackOff(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			h := New(t, Options{BatchVersion: batchVersion, Config: &config.Config{PendingGracePeriod: 100 * time.Millisecond}})
+			cronjob := h.CreateCronJob(testCronJob("unpullable"))
+			h.WaitForSync(cronjob)
+
+			// The run fails once, after the grace period, naming the image at fault
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "unpullable-uid", 1, Timeout)
+			run.ImagePullBackOff()
+			pings := h.Cronitor.WaitForPings(t, "unpullable-uid", 2, Timeout)
+			run.ImagePullBackOff()
+			time.Sleep(300 * time.Millisecond)
+			h.Cronitor.ExpectPings(t, "unpullable-uid", "run", "fail")
+			if !strings.Contains(pings[1].Message, `container "main" (image "busybox") is waiting with ImagePullBackOff`) || pings[1].Series != string(run.Job.UID) {
+				t.Errorf("unexpected ping %+v", pings[1])
+			}
+		})
+	}
+}
+
+func TestLifecycle_ImagePullBackOffIgnored(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			cfg := &config.Config{
+				PendingGracePeriod: 100 * time.Millisecond,
+				EventRules:         []pkg.EventRule{{Kind: "Pod", Reason: "BackOff", Message: "^Back-off pulling image", State: "ignore"}},
+			}
+			h := New(t, Options{BatchVersion: batchVersion, Config: cfg})
+			cronjob := testCronJob("slow-pull")
+			cronjob.Annotations = map[string]string{
+				"k8s.cronitor.io/event-rules": `[{"kind": "Pod", "reason": "Failed", "message": "ErrImagePull", "state": "ignore"}]`,
+			}
+			cronjob = h.CreateCronJob(cronjob)
+			h.WaitForSync(cronjob)
+
+			// Rules ignoring the events of a Pod kept from starting take precedence over the
+			// grace period check
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "slow-pull-uid", 1, Timeout)
+			run.ImagePullBackOff()
+			time.Sleep(300 * time.Millisecond)
+			run.Complete()
+			h.Cronitor.WaitForPings(t, "slow-pull-uid", 2, Timeout)
+			h.Cronitor.ExpectPings(t, "slow-pull-uid", "run", "complete")
+		})
+	}
+}
+
+func TestLifecycle_DeadlineExceeded(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			cfg := &config.Config{ShipLogs: true, EventRules: []pkg.EventRule{{Kind: "Job", Reason: "Suspended", State: "ignore"}}}
+			h := New(t, Options{BatchVersion: batchVersion, Config: cfg})
+			cronjob := h.CreateCronJob(testCronJob("slow"))
+			h.WaitForSync(cronjob)
+
+			// The failure is reported even though the Pod is gone
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "slow-uid", 1, Timeout)
+			h.Event("Job", run.Job, corev1.EventTypeNormal, "Suspended", "Job suspended")
+			run.ExceedDeadline()
+			pings := h.Cronitor.WaitForPings(t, "slow-uid", 2, Timeout)
+			h.Cronitor.ExpectPings(t, "slow-uid", "run", "fail")
+			if pings[1].Message != "Job was active longer than specified deadline" || pings[1].Series != string(run.Job.UID) {
+				t.Errorf("unexpected ping %+v", pings[1])
+			}
+		})
+	}
+}
+
+func TestLifecycle_EventRules(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			cfg := &config.Config{EventRules: []pkg.EventRule{{Kind: "Pod", Reason: "BackOff", State: "logs"}}}
+			h := New(t, Options{BatchVersion: batchVersion, Config: cfg})
+			cronjob := testCronJob("ruled")
+			cronjob.Annotations = map[string]string{
+				"k8s.cronitor.io/event-rules": `[{"kind": "Pod", "reason": "Evicted", "message": "memory", "state": "fail"}]`,
+			}
+			cronjob = h.CreateCronJob(cronjob)
+			h.WaitForSync(cronjob)
+
+			// The CronJob's rules report an event the agent's ignore, and the agent's rules
+			// change how another is reported
+			run := h.StartRun(cronjob)
+			h.Cronitor.WaitForPings(t, "ruled-uid", 1, Timeout)
+			h.Event("Pod", run.Pod, corev1.EventTypeWarning, "Evicted", "The node was low on resource: ephemeral-storage.")
+			h.Event("Pod", run.Pod, corev1.EventTypeWarning, "Evicted", "The node was low on resource: memory.")
+			h.Cronitor.WaitForPings(t, "ruled-uid", 2, Timeout)
+			h.Event("Pod", run.Pod, corev1.EventTypeWarning, "BackOff", "Back-off restarting failed container")
+			pings := h.Cronitor.WaitForPings(t, "ruled-uid", 3, Timeout)
+			h.Cronitor.ExpectPings(t, "ruled-uid", "run", "fail", "logs")
+			if pings[1].Message != "The node was low on resource: memory." {
+				t.Errorf("unexpected ping %+v", pings[1])
+			}
+
+			// A changed annotation applies to the next events
+			cronjob.Annotations["k8s.cronitor.io/event-rules"] = `[{"kind": "Pod", "reason": "BackOff", "state": "ignore"}]`
+			h.UpdateCronJob(cronjob)
+			h.WaitFor("the updated CronJob", func() bool {
+				current, ok := h.Collection.GetCronJob(cronjob.UID)
+				return ok && current.Annotations["k8s.cronitor.io/event-rules"] == cronjob.Annotations["k8s.cronitor.io/event-rules"]
+			})
+			h.Event("Pod", run.Pod, corev1.EventTypeWarning, "BackOff", "Back-off restarting failed container")
+			run.Complete()
+			h.Cronitor.WaitForPings(t, "ruled-uid", 4, Timeout)
+			h.Cronitor.ExpectPings(t, "ruled-uid", "run", "fail", "logs", "complete")
+		})
+	}
+}
+
+func TestLifecycle_SidecarCompletion(t *testing.T) {
+	for _, batchVersion := range batchVersions {
+		t.Run(batchVersion, func(t *testing.T) {
+			h := New(t, Options{BatchVersion: batchVersion, Config: &config.Config{SidecarCompletion: true}})
+			meshed := testCronJob("meshed")
+			podSpec := &meshed.Spec.JobTemplate.Spec.Template.Spec
+			podSpec.Containers = append(podSpec.Containers, corev1.Container{Name: "istio-proxy", Image: "istio/proxyv2"})
+			podSpec.RestartPolicy = corev1.RestartPolicyNever
+			meshed = h.CreateCronJob(meshed)
+			h.WaitForSync(meshed)
+
+			// The run completes when its main container does, although the proxy keeps running,
+			// and not again when the Job is finally stopped
+			run := h.StartRun(meshed)
+			h.Cronitor.WaitForPings(t, "meshed-uid", 1, Timeout)
+			run.ExitMainContainer(0)
+			pings := h.Cronitor.WaitForPings(t, "meshed-uid", 2, Timeout)
+			if pings[1].State != "complete" || pings[1].Series != string(run.Job.UID) || !strings.Contains(pings[1].Message, `container "main" exited with code 0`) {
+				t.Errorf("unexpected ping %+v", pings[1])
+			}
+			run.ExceedDeadline()
+			time.Sleep(300 * time.Millisecond)
+			h.Cronitor.ExpectPings(t, "meshed-uid", "run", "complete")
+
+			// A main container that fails fails the run
+			run = h.StartRun(meshed)
+			h.Cronitor.WaitForPings(t, "meshed-uid", 3, Timeout)
+			run.ExitMainContainer(1)
+			h.Cronitor.WaitForPings(t, "meshed-uid", 4, Timeout)
+			h.Cronitor.ExpectPings(t, "meshed-uid", "run", "complete", "run", "fail")
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+)
+
+// CronJobErrors counts errors encountered while handling individual CronJobs,
+// keyed by error class ("config" or "transient").
+var CronJobErrors = expvar.NewMap("cronjob_errors")
+
+// CronJobsPendingSync is the number of included CronJobs whose monitors are waiting to be
+// synced because Cronitor could not be reached.
+var CronJobsPendingSync = expvar.NewInt("cronjobs_pending_sync")
+
+// ApiKeyRotations counts the API keys read from the key file, keyed by outcome
+// ("rotated" or "rejected").
+var ApiKeyRotations = expvar.NewMap("api_key_rotations")
+
+// Serve exposes the agent's metrics in expvar's JSON format at /debug/vars on the given address.
+// It blocks, so it should be run in its own goroutine.
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	slog.Info("serving metrics", "address", address, "path", "/debug/vars")
+	if err := http.ListenAndServe(address, mux); err != nil {
+		slog.Error("metrics server stopped", "address", address, "error", err)
+	}
+}
@@ -30,3 +30,25 @@ func CronJobConvertV1Beta1ToV1(v1beta1CJ *v1beta1.CronJob) *v1.CronJob {
 
 	return newCronJob
 }
+
+func CronJobConvertV1ToV1Beta1(v1CJ *v1.CronJob) *v1beta1.CronJob {
+	newCronJob := new(v1beta1.CronJob)
+	newCronJob.TypeMeta = v1CJ.TypeMeta
+	newCronJob.ObjectMeta = v1CJ.ObjectMeta
+	newCronJob.Spec = v1beta1.CronJobSpec{
+		Schedule:                v1CJ.Spec.Schedule,
+		TimeZone:                v1CJ.Spec.TimeZone,
+		StartingDeadlineSeconds: v1CJ.Spec.StartingDeadlineSeconds,
+		ConcurrencyPolicy:       v1beta1.ConcurrencyPolicy(v1CJ.Spec.ConcurrencyPolicy),
+		Suspend:                 v1CJ.Spec.Suspend,
+		JobTemplate: v1beta1.JobTemplateSpec{
+			ObjectMeta: v1CJ.Spec.JobTemplate.ObjectMeta,
+			Spec:       v1CJ.Spec.JobTemplate.Spec,
+		},
+		SuccessfulJobsHistoryLimit: v1CJ.Spec.SuccessfulJobsHistoryLimit,
+		FailedJobsHistoryLimit:     v1CJ.Spec.FailedJobsHistoryLimit,
+	}
+	newCronJob.Status = v1beta1.CronJobStatus(v1CJ.Status)
+
+	return newCronJob
+}
@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+)
+
+// crdSchema holds the parts of an OpenAPI v3 schema the chart's CRDs use. Decoding with
+// unknown fields disallowed catches a misplaced key, which the API server would reject.
+type crdSchema struct {
+	Type                 string               `json:"type"`
+	Description          string               `json:"description,omitempty"`
+	Properties           map[string]crdSchema `json:"properties,omitempty"`
+	Items                *crdSchema           `json:"items,omitempty"`
+	AdditionalProperties *crdSchema           `json:"additionalProperties,omitempty"`
+	Required             []string             `json:"required,omitempty"`
+	Enum                 []string             `json:"enum,omitempty"`
+	Minimum              *float64             `json:"minimum,omitempty"`
+}
+
+type crd struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		Group string `json:"group"`
+		Names struct {
+			Kind     string   `json:"kind"`
+			ListKind string   `json:"listKind,omitempty"`
+			Plural   string   `json:"plural"`
+			Singular string   `json:"singular"`
+			Short    []string `json:"shortNames,omitempty"`
+		} `json:"names"`
+		Scope    string `json:"scope"`
+		Versions []struct {
+			Name    string `json:"name"`
+			Served  bool   `json:"served"`
+			Storage bool   `json:"storage"`
+			Schema  struct {
+				OpenAPIV3Schema crdSchema `json:"openAPIV3Schema"`
+			} `json:"schema"`
+		} `json:"versions"`
+	} `json:"spec"`
+}
+
+func loadChartCRD(t *testing.T, name string) crd {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "charts", "cronitor-kubernetes", "crds", name))
+	if err != nil {
+		t.Fatalf("could not read CRD: %v", err)
+	}
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		t.Fatalf("invalid YAML in %s: %v", name, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.DisallowUnknownFields()
+	var c crd
+	if err := decoder.Decode(&c); err != nil {
+		t.Fatalf("invalid CRD %s: %v", name, err)
+	}
+	return c
+}
+
+func jsonFields(t reflect.Type) []string {
+	var fields []string
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func schemaFields(s crdSchema) []string {
+	var fields []string
+	for name := range s.Properties {
+		fields = append(fields, name)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func TestChartCRDs(t *testing.T) {
+	tests := []struct {
+		file, kind, plural, scope string
+	}{
+		{"cronitorpolicies.yaml", KindCronitorPolicy, CronitorPolicyResource.Resource, "Namespaced"},
+		{"clustercronitorpolicies.yaml", KindClusterCronitorPolicy, ClusterCronitorPolicyResource.Resource, "Cluster"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			c := loadChartCRD(t, tt.file)
+			if c.APIVersion != "apiextensions.k8s.io/v1" || c.Kind != "CustomResourceDefinition" {
+				t.Fatalf("expected a CustomResourceDefinition, got %s %s", c.APIVersion, c.Kind)
+			}
+			if c.Metadata.Name != tt.plural+"."+Group || c.Spec.Group != Group || c.Spec.Names.Plural != tt.plural {
+				t.Errorf("expected %s.%s, got %s (group %s, plural %s)", tt.plural, Group, c.Metadata.Name, c.Spec.Group, c.Spec.Names.Plural)
+			}
+			if c.Spec.Names.Kind != tt.kind || c.Spec.Scope != tt.scope {
+				t.Errorf("expected a %s %s, got a %s %s", tt.scope, tt.kind, c.Spec.Scope, c.Spec.Names.Kind)
+			}
+			if len(c.Spec.Versions) != 1 || c.Spec.Versions[0].Name != Version || !c.Spec.Versions[0].Served || !c.Spec.Versions[0].Storage {
+				t.Fatalf("expected %s to be the only served and stored version", Version)
+			}
+
+			root := c.Spec.Versions[0].Schema.OpenAPIV3Schema
+			if root.Type != "object" {
+				t.Errorf("expected the schema to be an object, got %q", root.Type)
+			}
+			spec, ok := root.Properties["spec"]
+			if !ok || spec.Type != "object" {
+				t.Fatalf("expected spec to be an object property of the schema, got %v", schemaFields(root))
+			}
+			if got, expected := schemaFields(spec), jsonFields(reflect.TypeOf(Spec{})); !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected the spec properties %v, got %v", expected, got)
+			}
+			if got, expected := schemaFields(spec.Properties["defaults"]), jsonFields(reflect.TypeOf(Defaults{})); !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected the defaults properties %v, got %v", expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	Group   = "k8s.cronitor.io"
+	Version = "v1alpha1"
+
+	// KindCronitorPolicy is namespace-scoped and applies to CronJobs in its own namespace.
+	KindCronitorPolicy = "CronitorPolicy"
+	// KindClusterCronitorPolicy is cluster-scoped and applies to CronJobs in every namespace.
+	KindClusterCronitorPolicy = "ClusterCronitorPolicy"
+)
+
+var (
+	CronitorPolicyResource        = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "cronitorpolicies"}
+	ClusterCronitorPolicyResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "clustercronitorpolicies"}
+)
+
+// CronitorPolicy holds monitor defaults for the CronJobs matching its selector.
+// The same type is used for both CronitorPolicy and ClusterCronitorPolicy objects;
+// cluster-scoped policies simply have no namespace.
+type CronitorPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec Spec `json:"spec"`
+}
+
+type Spec struct {
+	// Selector limits the policy to CronJobs with matching labels. Empty matches every CronJob.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	Defaults Defaults              `json:"defaults,omitempty"`
+}
+
+// Defaults are used for any setting a CronJob doesn't configure with its own annotations.
+type Defaults struct {
+	Env          string   `json:"env,omitempty"`
+	Tags         []string `json:"tags,omitempty"`
+	Notify       []string `json:"notify,omitempty"`
+	Group        string   `json:"group,omitempty"`
+	GraceSeconds *int     `json:"graceSeconds,omitempty"`
+	// Assertions are complete Cronitor assertions, e.g. "metric.duration < 5 min"
+	Assertions   []string `json:"assertions,omitempty"`
+	NamePrefix   string   `json:"namePrefix,omitempty"`
+	KeyInference string   `json:"keyInference,omitempty"`
+}
+
+// IsClusterScoped reports whether the policy is a ClusterCronitorPolicy.
+func (p *CronitorPolicy) IsClusterScoped() bool {
+	return p.Namespace == ""
+}
+
+// withFallback fills in every setting d leaves unset from fallback.
+func (d Defaults) withFallback(fallback Defaults) Defaults {
+	if d.Env == "" {
+		d.Env = fallback.Env
+	}
+	if len(d.Tags) == 0 {
+		d.Tags = fallback.Tags
+	}
+	if len(d.Notify) == 0 {
+		d.Notify = fallback.Notify
+	}
+	if d.Group == "" {
+		d.Group = fallback.Group
+	}
+	if d.GraceSeconds == nil {
+		d.GraceSeconds = fallback.GraceSeconds
+	}
+	if len(d.Assertions) == 0 {
+		d.Assertions = fallback.Assertions
+	}
+	if d.NamePrefix == "" {
+		d.NamePrefix = fallback.NamePrefix
+	}
+	if d.KeyInference == "" {
+		d.KeyInference = fallback.KeyInference
+	}
+	return d
+}
+
+// FromUnstructured converts a CronitorPolicy or ClusterCronitorPolicy read with the dynamic client.
+func FromUnstructured(obj *unstructured.Unstructured) (*CronitorPolicy, error) {
+	var p CronitorPolicy
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), &p); err != nil {
+		return nil, fmt.Errorf("invalid %s %s: %w", obj.GetKind(), policyName(obj.GetNamespace(), obj.GetName()), err)
+	}
+	return &p, nil
+}
+
+func policyName(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
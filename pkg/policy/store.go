@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type compiledPolicy struct {
+	policy   *CronitorPolicy
+	selector labels.Selector
+}
+
+func (c compiledPolicy) matches(cronjob *v1.CronJob) bool {
+	if !c.policy.IsClusterScoped() && c.policy.Namespace != cronjob.Namespace {
+		return false
+	}
+	return c.selector.Matches(labels.Set(cronjob.Labels))
+}
+
+// Store keeps the known policies and resolves the defaults that apply to each CronJob.
+type Store struct {
+	mu       sync.RWMutex
+	policies map[string]compiledPolicy
+}
+
+func NewStore() *Store {
+	return &Store{policies: make(map[string]compiledPolicy)}
+}
+
+func compile(p *CronitorPolicy) (compiledPolicy, error) {
+	selector := labels.Everything()
+	if p.Spec.Selector != nil {
+		var err error
+		selector, err = metav1.LabelSelectorAsSelector(p.Spec.Selector)
+		if err != nil {
+			return compiledPolicy{}, fmt.Errorf("invalid selector on policy %s: %w", policyName(p.Namespace, p.Name), err)
+		}
+	}
+	return compiledPolicy{policy: p, selector: selector}, nil
+}
+
+// Set adds or replaces a policy. It returns the policy it replaced, if any, and whether
+// anything changed (a policy seen again at the same resource version is not a change).
+func (s *Store) Set(p *CronitorPolicy) (previous *CronitorPolicy, changed bool, err error) {
+	compiled, err := compile(p)
+	if err != nil {
+		return nil, false, err
+	}
+	key := policyName(p.Namespace, p.Name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.policies[key]; ok {
+		previous = existing.policy
+		if p.ResourceVersion != "" && previous.ResourceVersion == p.ResourceVersion {
+			return previous, false, nil
+		}
+	}
+	s.policies[key] = compiled
+	return previous, true, nil
+}
+
+// Delete removes a policy, returning it if it was known.
+func (s *Store) Delete(namespace, name string) *CronitorPolicy {
+	key := policyName(namespace, name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.policies[key]
+	if !ok {
+		return nil
+	}
+	delete(s.policies, key)
+	return existing.policy
+}
+
+// Matches reports whether a policy applies to a CronJob.
+func Matches(p *CronitorPolicy, cronjob *v1.CronJob) bool {
+	compiled, err := compile(p)
+	if err != nil {
+		return false
+	}
+	return compiled.matches(cronjob)
+}
+
+// Resolve merges the defaults of every policy matching the CronJob. For each setting,
+// CronitorPolicies in the CronJob's namespace take precedence over ClusterCronitorPolicies,
+// and within each scope policies are applied in order of name, the first one to set a value winning.
+func (s *Store) Resolve(cronjob *v1.CronJob) Defaults {
+	if s == nil {
+		return Defaults{}
+	}
+	s.mu.RLock()
+	var matching []*CronitorPolicy
+	for _, compiled := range s.policies {
+		if compiled.matches(cronjob) {
+			matching = append(matching, compiled.policy)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].IsClusterScoped() != matching[j].IsClusterScoped() {
+			return !matching[i].IsClusterScoped()
+		}
+		return matching[i].Name < matching[j].Name
+	})
+
+	var defaults Defaults
+	for _, p := range matching {
+		defaults = defaults.withFallback(p.Spec.Defaults)
+	}
+	return defaults
+}
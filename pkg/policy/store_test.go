@@ -0,0 +1,138 @@
+package policy
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func testPolicy(namespace, name string, selector map[string]string, defaults Defaults) *CronitorPolicy {
+	p := &CronitorPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       Spec{Defaults: defaults},
+	}
+	if selector != nil {
+		p.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+	}
+	return p
+}
+
+func testCronJob(namespace string, cronjobLabels map[string]string) *v1.CronJob {
+	return &v1.CronJob{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "test-cronjob", Labels: cronjobLabels},
+	}
+}
+
+func TestStore_ResolvePrecedence(t *testing.T) {
+	graceSeconds := 120
+	store := NewStore()
+	for _, p := range []*CronitorPolicy{
+		testPolicy("", "b-cluster", nil, Defaults{Env: "cluster-b", Group: "cluster-group", GraceSeconds: &graceSeconds}),
+		testPolicy("", "a-cluster", nil, Defaults{Env: "cluster-a", Tags: []string{"cluster"}}),
+		testPolicy("team-a", "team-policy", nil, Defaults{Env: "team-a", Notify: []string{"team-a-oncall"}}),
+		testPolicy("team-b", "other-team", nil, Defaults{Group: "team-b"}),
+	} {
+		if _, _, err := store.Set(p); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	defaults := store.Resolve(testCronJob("team-a", nil))
+	if defaults.Env != "team-a" {
+		t.Errorf("expected the namespaced policy's env to win, got %q", defaults.Env)
+	}
+	if len(defaults.Tags) != 1 || defaults.Tags[0] != "cluster" {
+		t.Errorf("expected tags from a-cluster, got %v", defaults.Tags)
+	}
+	if defaults.Group != "cluster-group" {
+		t.Errorf("expected group from b-cluster, not another namespace's policy, got %q", defaults.Group)
+	}
+	if defaults.GraceSeconds == nil || *defaults.GraceSeconds != 120 {
+		t.Errorf("expected grace seconds from b-cluster, got %v", defaults.GraceSeconds)
+	}
+
+	if env := store.Resolve(testCronJob("team-c", nil)).Env; env != "cluster-a" {
+		t.Errorf("expected cluster policies to apply in name order, got %q", env)
+	}
+}
+
+func TestStore_Selector(t *testing.T) {
+	store := NewStore()
+	if _, _, err := store.Set(testPolicy("", "prod", map[string]string{"tier": "prod"}, Defaults{Env: "production"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if env := store.Resolve(testCronJob("default", map[string]string{"tier": "prod"})).Env; env != "production" {
+		t.Errorf("expected the matching CronJob to get the policy, got %q", env)
+	}
+	if env := store.Resolve(testCronJob("default", map[string]string{"tier": "dev"})).Env; env != "" {
+		t.Errorf("expected the non-matching CronJob not to get the policy, got %q", env)
+	}
+
+	invalid := testPolicy("", "invalid", nil, Defaults{})
+	invalid.Spec.Selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Sometimes"}}}
+	if _, _, err := store.Set(invalid); err == nil {
+		t.Error("expected an error for an invalid selector")
+	}
+}
+
+func TestStore_SetAndDelete(t *testing.T) {
+	store := NewStore()
+	p := testPolicy("team-a", "policy", nil, Defaults{Env: "staging"})
+	p.ResourceVersion = "1"
+
+	if previous, changed, _ := store.Set(p); previous != nil || !changed {
+		t.Errorf("expected a new policy to be a change, got previous=%v changed=%v", previous, changed)
+	}
+	same := *p
+	if _, changed, _ := store.Set(&same); changed {
+		t.Error("expected the same resource version not to be a change")
+	}
+
+	updated := *p
+	updated.ResourceVersion = "2"
+	updated.Spec.Defaults.Env = "production"
+	if previous, changed, _ := store.Set(&updated); previous == nil || previous.Spec.Defaults.Env != "staging" || !changed {
+		t.Errorf("expected the update to replace the previous policy, got previous=%v changed=%v", previous, changed)
+	}
+
+	if removed := store.Delete("team-a", "policy"); removed == nil {
+		t.Error("expected the policy to be removed")
+	}
+	if env := store.Resolve(testCronJob("team-a", nil)).Env; env != "" {
+		t.Errorf("expected no defaults after deletion, got %q", env)
+	}
+}
+
+func TestFromUnstructured(t *testing.T) {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "k8s.cronitor.io/v1alpha1",
+		"kind":       KindClusterCronitorPolicy,
+		"metadata":   map[string]interface{}{"name": "defaults"},
+		"spec": map[string]interface{}{
+			"selector": map[string]interface{}{"matchLabels": map[string]interface{}{"tier": "prod"}},
+			"defaults": map[string]interface{}{
+				"env":          "production",
+				"tags":         []interface{}{"prod"},
+				"graceSeconds": int64(300),
+				"assertions":   []interface{}{"metric.duration < 5 min"},
+			},
+		},
+	}}
+
+	p, err := FromUnstructured(obj)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !p.IsClusterScoped() {
+		t.Error("expected a policy without a namespace to be cluster-scoped")
+	}
+	if p.Spec.Defaults.Env != "production" || p.Spec.Defaults.GraceSeconds == nil || *p.Spec.Defaults.GraceSeconds != 300 {
+		t.Errorf("unexpected defaults: %+v", p.Spec.Defaults)
+	}
+	if p.Spec.Selector == nil || p.Spec.Selector.MatchLabels["tier"] != "prod" {
+		t.Errorf("unexpected selector: %+v", p.Spec.Selector)
+	}
+}
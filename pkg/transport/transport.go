@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultConnectTimeout bounds establishing a connection, including the TLS handshake.
+	DefaultConnectTimeout = 30 * time.Second
+	// DefaultResponseTimeout bounds a whole request, from connecting to reading the response body.
+	DefaultResponseTimeout = 120 * time.Second
+)
+
+// Options configures the shared HTTP client.
+type Options struct {
+	// ProxyURL is the proxy to send every request through. When empty, the standard
+	// HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables are used.
+	ProxyURL string
+	// CAFile is a PEM bundle of certificate authorities to trust, in addition to the system's.
+	CAFile string
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key to present for mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+	// ConnectTimeout and ResponseTimeout default to DefaultConnectTimeout and DefaultResponseTimeout.
+	ConnectTimeout  time.Duration
+	ResponseTimeout time.Duration
+}
+
+// NewClient builds an HTTP client from opts.
+func NewClient(opts Options) (*http.Client, error) {
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	responseTimeout := opts.ResponseTimeout
+	if responseTimeout <= 0 {
+		responseTimeout = DefaultResponseTimeout
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %w", opts.ProxyURL, err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, errors.New("a client certificate and key must be provided together")
+		}
+		certificate, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	dialer := &net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}
+	return &http.Client{
+		Timeout: responseTimeout,
+		Transport: &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   connectTimeout,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}, nil
+}
+
+var shared atomic.Pointer[http.Client]
+
+func init() {
+	client, _ := NewClient(Options{})
+	shared.Store(client)
+}
+
+// SetClient replaces the shared client, typically once at startup with the configured one.
+func SetClient(client *http.Client) {
+	shared.Store(client)
+}
+
+// Client returns the client every outbound request is sent with, to Cronitor and elsewhere,
+// so that proxy, TLS and timeout settings apply to all of them. Until SetClient is called,
+// it is built from the default Options.
+func Client() *http.Client {
+	return shared.Load()
+}
+
+// sharedTransport sends each request with the transport of the shared client at that time.
+type sharedTransport struct{}
+
+func (sharedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if roundTripper := Client().Transport; roundTripper != nil {
+		return roundTripper.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Transport returns a transport that sends requests with the shared client's, for libraries
+// that are given a transport before the configured client is set, such as Sentry's.
+func Transport() http.RoundTripper {
+	return sharedTransport{}
+}
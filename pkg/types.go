@@ -4,3 +4,4 @@ import "k8s.io/api/core/v1"
 
 type PodEvent v1.Event
 type JobEvent v1.Event
+type CronJobEvent v1.Event